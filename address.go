@@ -27,14 +27,14 @@ const (
 type Address struct {
 	Address string
 	Total   struct {
-		Balance  int64
-		Received int64
-		Sent     int64
+		Balance  Amount
+		Received Amount
+		Sent     Amount
 	}
 	Confirmed struct {
-		Balance  int64
-		Received int64
-		Sent     int64
+		Balance  Amount
+		Received Amount
+		Sent     Amount
 	}
 }
 
@@ -52,9 +52,12 @@ func (c *Chain) GetAddressMulti(hashes []string) ([]Address, error) {
 	if len(hashes) > MaxAddresses {
 		return nil, fmt.Errorf("max addresses allowed is %d", MaxAddresses)
 	}
+	if c.backend != nil {
+		return c.backend.GetAddressMulti(hashes)
+	}
 
 	url, addresses := c.addressURL(hashes), make([]Address, len(hashes))
-	return addresses, c.httpGetJSON(url, &addresses)
+	return addresses, c.cachedGetJSON(url, &addresses)
 }
 
 // GetAddress allows you to get one address. It returns basic balance details.
@@ -62,8 +65,19 @@ func (c *Chain) GetAddressMulti(hashes []string) ([]Address, error) {
 // Chain documentation can be found here
 // https://chain.com/docs#bitcoin-address.
 func (c *Chain) GetAddress(hash string) (Address, error) {
+	if c.backend != nil {
+		addresses, err := c.backend.GetAddressMulti([]string{hash})
+		if err != nil {
+			return Address{}, err
+		}
+		if len(addresses) != 1 {
+			return Address{}, fmt.Errorf("backend returned %d addresses for %s",
+				len(addresses), hash)
+		}
+		return addresses[0], nil
+	}
 	url, addresses := c.addressURL([]string{hash}), make([]Address, 1)
-	return addresses[0], c.httpGetJSON(url, &addresses)
+	return addresses[0], c.cachedGetJSON(url, &addresses)
 }
 
 func (c *Chain) addressTransactionsURL(hashes []string, limit int) string {
@@ -90,10 +104,13 @@ func (c *Chain) GetAddressTransactionsMulti(
 	case limit == 0:
 		limit = DefaultAddressTransactionsLimit
 	}
+	if c.backend != nil {
+		return c.backend.GetAddressTransactionsMulti(hashes, limit)
+	}
 
 	url := c.addressTransactionsURL(hashes, limit)
 	transactions := make([]Transaction, limit)
-	return transactions, c.httpGetJSON(url, &transactions)
+	return transactions, c.cachedGetJSON(url, &transactions)
 }
 
 // GetAddressTransactions returns a set of transactions for one Bitcoin
@@ -123,10 +140,13 @@ func (c *Chain) GetAddressUnspentOutputsMulti(
 	if len(hashes) > MaxAddresses {
 		return nil, fmt.Errorf("max addresses allowed is %d", MaxAddresses)
 	}
+	if c.backend != nil {
+		return c.backend.GetAddressUnspentOutputsMulti(hashes)
+	}
 
 	url := c.addressUnspentOutputsURL(hashes)
 	outputs := make([]Output, len(hashes))
-	return outputs, c.httpGetJSON(url, &outputs)
+	return outputs, c.cachedGetJSON(url, &outputs)
 }
 
 // GetAddressUnspentOutputs returns a collection of unspent outputs for a
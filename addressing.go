@@ -0,0 +1,150 @@
+package chain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// networkParams are the address and private key prefixes of a network.
+type networkParams struct {
+	pubKeyHash byte
+	scriptHash byte
+	hrp        string
+	privateKey byte
+}
+
+var networks = map[Network]networkParams{
+	MainNet:  {0x00, 0x05, "bc", 0x80},
+	TestNet3: {0x6f, 0xc4, "tb", 0xef},
+}
+
+// AddressNetworkError is returned when an address is valid but belongs to a
+// different network than expected.
+type AddressNetworkError struct {
+	Address  string
+	Expected Network
+	Actual   Network
+}
+
+func (e *AddressNetworkError) Error() string {
+	return fmt.Sprintf("address %s is a %s address, expected %s",
+		e.Address, e.Actual, e.Expected)
+}
+
+// DecodedAddress is a decoded Bitcoin address.
+type DecodedAddress struct {
+	Network Network
+
+	// Type is one of the address script types, for example
+	// PubKeyHashScript.
+	Type string
+
+	// WitnessVersion is the witness version of segwit addresses.
+	WitnessVersion byte
+
+	// Program is the hash of legacy addresses and the witness program of
+	// segwit addresses.
+	Program []byte
+}
+
+// DecodeAddress decodes a legacy base58 or segwit bech32 address of network
+// n. An address of another network returns an *AddressNetworkError.
+func DecodeAddress(address string, n Network) (*DecodedAddress, error) {
+	if _, ok := networks[n]; !ok {
+		return nil, fmt.Errorf("unknown network %q", n)
+	}
+	a, err := decodeAnyAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if a.Network != n {
+		return nil, &AddressNetworkError{address, n, a.Network}
+	}
+	return a, nil
+}
+
+func decodeAnyAddress(address string) (*DecodedAddress, error) {
+	lower := strings.ToLower(address)
+	for net, params := range networks {
+		if !strings.HasPrefix(lower, params.hrp+"1") {
+			continue
+		}
+		hrp, version, program, err := decodeSegwitAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %s: %v", address, err)
+		}
+		if hrp != params.hrp {
+			break
+		}
+		a := &DecodedAddress{net, WitnessUnknownScript, version, program}
+		switch {
+		case version == 0 && len(program) == 20:
+			a.Type = WitnessV0KeyHashScript
+		case version == 0:
+			a.Type = WitnessV0ScriptHashScript
+		case version == 1 && len(program) == 32:
+			a.Type = WitnessV1TaprootScript
+		}
+		return a, nil
+	}
+
+	version, payload, err := base58CheckDecode(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %v", address, err)
+	}
+	if len(payload) != 20 {
+		return nil, fmt.Errorf("invalid address %s: wrong length", address)
+	}
+	for net, params := range networks {
+		switch version {
+		case params.pubKeyHash:
+			return &DecodedAddress{net, PubKeyHashScript, 0, payload}, nil
+		case params.scriptHash:
+			return &DecodedAddress{net, ScriptHashScript, 0, payload}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid address %s: unknown version %d",
+		address, version)
+}
+
+// ValidateAddress returns an error if address is not a valid address of
+// network n.
+func ValidateAddress(address string, n Network) error {
+	_, err := DecodeAddress(address, n)
+	return err
+}
+
+// ValidateAddress returns an error if address is not a valid address of the
+// network of c.
+func (c *Chain) ValidateAddress(address string) error {
+	return ValidateAddress(address, c.network)
+}
+
+// String encodes a in its canonical form, lower case for segwit addresses.
+func (a *DecodedAddress) String() string {
+	params := networks[a.Network]
+	switch a.Type {
+	case PubKeyHashScript:
+		return base58CheckEncode(params.pubKeyHash, a.Program)
+	case ScriptHashScript:
+		return base58CheckEncode(params.scriptHash, a.Program)
+	}
+	return encodeSegwitAddress(params.hrp, a.WitnessVersion, a.Program)
+}
+
+// Script returns the output script paying to a.
+func (a *DecodedAddress) Script() []byte {
+	switch a.Type {
+	case PubKeyHashScript:
+		s := append([]byte{opDup, opHash160, 20}, a.Program...)
+		return append(s, opEqualVerify, opCheckSig)
+	case ScriptHashScript:
+		s := append([]byte{opHash160, 20}, a.Program...)
+		return append(s, opEqual)
+	}
+	op := byte(op0)
+	if a.WitnessVersion > 0 {
+		op = op1 + a.WitnessVersion - 1
+	}
+	return append([]byte{op, byte(len(a.Program))}, a.Program...)
+}
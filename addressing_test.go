@@ -0,0 +1,69 @@
+package chain_test
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/qedus/chain"
+)
+
+func TestDecodeAddress(t *testing.T) {
+	for _, c := range []struct {
+		address string
+		net     chain.Network
+		ty      string
+		script  string
+	}{
+		{testAddress, chain.MainNet, chain.PubKeyHashScript,
+			"76a91462e907b15cbf27d5425399ebf6f0fb50ebb88f1888ac"},
+		{"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", chain.MainNet,
+			chain.ScriptHashScript,
+			"a914b472a266d0bd89c13706a4132ccfb16f7c3b9fcb87"},
+		{"mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn", chain.TestNet3,
+			chain.PubKeyHashScript,
+			"76a914243f1394f44554f4ce3fd68649c19adc483ce92488ac"},
+		{"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4", chain.MainNet,
+			chain.WitnessV0KeyHashScript,
+			"0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7",
+			chain.TestNet3, chain.WitnessV0ScriptHashScript,
+			"00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0",
+			chain.MainNet, chain.WitnessV1TaprootScript,
+			"512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+	} {
+		a, err := chain.DecodeAddress(c.address, c.net)
+		if err != nil {
+			t.Fatal(c.address, err)
+		}
+		if a.Type != c.ty || hex.EncodeToString(a.Script()) != c.script {
+			t.Fatal("unexpected address", c.address, a.Type,
+				hex.EncodeToString(a.Script()))
+		}
+		if a.String() != c.address && a.String() != strings.ToLower(c.address) {
+			t.Fatal("unexpected encoding", a.String())
+		}
+	}
+
+	_, err := chain.DecodeAddress(testAddress, chain.TestNet3)
+	if e, ok := err.(*chain.AddressNetworkError); !ok ||
+		e.Actual != chain.MainNet {
+		t.Fatal("expected network error", err)
+	}
+
+	for _, address := range []string{
+		"",
+		"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNb",
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5",
+		// Bech32m checksum on a version 0 program.
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kemeawh",
+		// Bech32 checksum on a version 1 program.
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd",
+		"bc1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4",
+	} {
+		if err := chain.ValidateAddress(address, chain.MainNet); err == nil {
+			t.Fatal("expected invalid address", address)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Amount is a quantity of bitcoin in satoshis. It is encoded in JSON as an
+// integer number of satoshis, the same as the Chain.com API.
+type Amount int64
+
+// Amount units. They can be used to convert to and from other units, for
+// example 5*MilliBTC, and with Format.
+const (
+	Satoshi  Amount = 1
+	Bit      Amount = 100
+	MilliBTC Amount = 100000
+	BTC      Amount = 100000000
+
+	// MaxAmount is the total amount of bitcoin that will ever exist.
+	MaxAmount = 21000000 * BTC
+)
+
+// ErrAmountOverflow is returned by Amount arithmetic when the result does not
+// fit in an int64.
+var ErrAmountOverflow = errors.New("amount overflows int64")
+
+var amountUnits = []struct {
+	unit     Amount
+	name     string
+	decimals int
+}{
+	{MilliBTC, "mBTC", 5},
+	{BTC, "BTC", 8},
+	{Bit, "bits", 2},
+	{Satoshi, "sat", 0},
+}
+
+func unitDecimals(unit Amount) (string, int, error) {
+	for _, u := range amountUnits {
+		if u.unit == unit {
+			return u.name, u.decimals, nil
+		}
+	}
+	return "", 0, fmt.Errorf("unknown amount unit %d", int64(unit))
+}
+
+// Format returns the exact decimal representation of a in the given unit
+// followed by the unit name, for example "0.0015 BTC" or "150 bits". Trailing
+// fractional zeros are removed. It panics if unit is not one of BTC,
+// MilliBTC, Bit or Satoshi.
+func (a Amount) Format(unit Amount) string {
+	name, decimals, err := unitDecimals(unit)
+	if err != nil {
+		panic(err)
+	}
+	return a.formatDecimal(unit, decimals) + " " + name
+}
+
+// formatDecimal formats a as a plain decimal in unit, which has the given
+// number of decimals.
+func (a Amount) formatDecimal(unit Amount, decimals int) string {
+	sign, u := "", uint64(a)
+	if a < 0 {
+		sign, u = "-", uint64(-a)
+	}
+	whole, frac := u/uint64(unit), u%uint64(unit)
+	if frac == 0 {
+		return sign + strconv.FormatUint(whole, 10)
+	}
+	fracStr := fmt.Sprintf("%0*d", decimals, frac)
+	return sign + strconv.FormatUint(whole, 10) + "." +
+		strings.TrimRight(fracStr, "0")
+}
+
+// String formats the amount in BTC.
+func (a Amount) String() string {
+	return a.Format(BTC)
+}
+
+// ToUnit returns a as a floating point number of the given unit. It is meant
+// for display purposes only; use Format for exact output.
+func (a Amount) ToUnit(unit Amount) float64 {
+	return float64(a) / float64(unit)
+}
+
+// ParseAmount parses an exact decimal amount with an optional unit suffix,
+// for example "0.0015 BTC", "1.5mBTC", "200 bits" or "150000 sat". A number
+// without a unit is in BTC. Amounts that are more precise than one satoshi
+// are rejected rather than rounded.
+func ParseAmount(s string) (Amount, error) {
+	str := strings.TrimSpace(s)
+	unit, decimals := BTC, 8
+	for _, u := range amountUnits {
+		if strings.HasSuffix(strings.ToLower(str), strings.ToLower(u.name)) {
+			str = strings.TrimSpace(str[:len(str)-len(u.name)])
+			unit, decimals = u.unit, u.decimals
+			break
+		}
+	}
+	a, err := parseDecimalAmount(str, unit, decimals)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %s", s, err)
+	}
+	return a, nil
+}
+
+func parseDecimalAmount(s string, unit Amount, decimals int) (Amount, error) {
+	negative := false
+	if strings.HasPrefix(s, "-") {
+		negative, s = true, s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if whole == "" && frac == "" {
+		return 0, errors.New("no digits")
+	}
+	for _, part := range []string{whole, frac} {
+		for _, r := range part {
+			if r < '0' || r > '9' {
+				return 0, fmt.Errorf("unexpected character %q", r)
+			}
+		}
+	}
+
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > decimals {
+		return 0, errors.New("more precise than one satoshi")
+	}
+	frac += strings.Repeat("0", decimals-len(frac))
+
+	var a Amount
+	if whole != "" {
+		w, err := strconv.ParseInt(whole, 10, 64)
+		if err != nil {
+			return 0, ErrAmountOverflow
+		}
+		if a, err = Amount(w).MulInt(int64(unit)); err != nil {
+			return 0, err
+		}
+	}
+	if frac != "" {
+		f, err := strconv.ParseInt(frac, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		if a, err = a.Add(Amount(f)); err != nil {
+			return 0, err
+		}
+	}
+	if negative {
+		a = -a
+	}
+	return a, nil
+}
+
+// Add returns a+b or ErrAmountOverflow.
+func (a Amount) Add(b Amount) (Amount, error) {
+	c := a + b
+	if (b > 0 && c < a) || (b < 0 && c > a) {
+		return 0, ErrAmountOverflow
+	}
+	return c, nil
+}
+
+// Sub returns a-b or ErrAmountOverflow.
+func (a Amount) Sub(b Amount) (Amount, error) {
+	c := a - b
+	if (b > 0 && c > a) || (b < 0 && c < a) {
+		return 0, ErrAmountOverflow
+	}
+	return c, nil
+}
+
+// MulInt returns a*n or ErrAmountOverflow.
+func (a Amount) MulInt(n int64) (Amount, error) {
+	if a == 0 || n == 0 {
+		return 0, nil
+	}
+	if (a == -1 && n == math.MinInt64) || (n == -1 && a == math.MinInt64) {
+		return 0, ErrAmountOverflow
+	}
+	c := a * Amount(n)
+	if c/Amount(n) != a {
+		return 0, ErrAmountOverflow
+	}
+	return c, nil
+}
+
+// SumAmounts returns the total of amounts or ErrAmountOverflow.
+func SumAmounts(amounts ...Amount) (Amount, error) {
+	var total Amount
+	for _, a := range amounts {
+		var err error
+		if total, err = total.Add(a); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
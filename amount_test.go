@@ -0,0 +1,126 @@
+package chain_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/qedus/chain"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		s      string
+		amount chain.Amount
+	}{
+		{"0.0015 BTC", 150000},
+		{"0.0015", 150000},
+		{"1.5mBTC", 150000},
+		{"1500 bits", 150000},
+		{"150000 sat", 150000},
+		{"21000000 BTC", chain.MaxAmount},
+		{"-0.00000001 btc", -1},
+		{".5", 50000000},
+		{"1.10000000000", 110000000},
+	}
+	for _, test := range tests {
+		a, err := chain.ParseAmount(test.s)
+		if err != nil {
+			t.Fatal(test.s, err)
+		}
+		if a != test.amount {
+			t.Fatalf("%s: expected %d got %d", test.s, test.amount, a)
+		}
+	}
+
+	for _, s := range []string{"", "BTC", "0.000000001", "1.5 sat",
+		"1e8 sat", "99999999999999 BTC", "1,5 BTC"} {
+		if _, err := chain.ParseAmount(s); err == nil {
+			t.Fatal("expected error for", s)
+		}
+	}
+}
+
+func TestAmountFormat(t *testing.T) {
+	a := 150000 * chain.Satoshi
+	if s := a.String(); s != "0.0015 BTC" {
+		t.Fatal("unexpected BTC format", s)
+	}
+	if s := a.Format(chain.MilliBTC); s != "1.5 mBTC" {
+		t.Fatal("unexpected mBTC format", s)
+	}
+	if s := a.Format(chain.Bit); s != "1500 bits" {
+		t.Fatal("unexpected bits format", s)
+	}
+	if s := (-chain.BTC - 1).String(); s != "-1.00000001 BTC" {
+		t.Fatal("unexpected negative format", s)
+	}
+
+	for _, a := range []chain.Amount{0, 1, chain.MaxAmount, -123456789} {
+		parsed, err := chain.ParseAmount(a.String())
+		if err != nil || parsed != a {
+			t.Fatal("round trip failed", a, parsed, err)
+		}
+	}
+}
+
+func TestAmountOverflow(t *testing.T) {
+	max := chain.Amount(math.MaxInt64)
+	if _, err := max.Add(1); err != chain.ErrAmountOverflow {
+		t.Fatal("expected overflow", err)
+	}
+	if _, err := (-max).Sub(2); err != chain.ErrAmountOverflow {
+		t.Fatal("expected overflow", err)
+	}
+	if _, err := chain.MaxAmount.MulInt(1000000); err != chain.ErrAmountOverflow {
+		t.Fatal("expected overflow", err)
+	}
+	if a, err := chain.BTC.MulInt(-3); err != nil || a != -3*chain.BTC {
+		t.Fatal("unexpected product", a, err)
+	}
+	if _, err := chain.SumAmounts(max, max); err != chain.ErrAmountOverflow {
+		t.Fatal("expected overflow", err)
+	}
+}
+
+func TestTransactionJSON(t *testing.T) {
+	data := []byte(`{
+		"hash": "0bf0de38c26195919179f42d475beb7a6b15258c38b57236afdd60a07eddd2cc",
+		"block_time": "2014-09-17T19:58:15Z",
+		"outputs": [{"value": 150000}],
+		"fees": 10000
+	}`)
+	tx := chain.Transaction{}
+	if err := json.Unmarshal(data, &tx); err != nil {
+		t.Fatal(err)
+	}
+	if !tx.BlockTime.Equal(time.Date(2014, 9, 17, 19, 58, 15, 0, time.UTC)) {
+		t.Fatal("unexpected block time", tx.BlockTime)
+	}
+	if tx.Outputs[0].Value != 150000 || tx.Fees != 10000 {
+		t.Fatal("unexpected values")
+	}
+
+	encoded, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := chain.Transaction{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.BlockTime.Equal(tx.BlockTime) ||
+		decoded.Outputs[0].Value != tx.Outputs[0].Value {
+		t.Fatal("round trip failed", string(encoded))
+	}
+
+	unconfirmed := chain.Transaction{}
+	if err := json.Unmarshal([]byte(`{"block_time": null}`),
+		&unconfirmed); err != nil {
+		t.Fatal(err)
+	}
+	if !unconfirmed.BlockTime.IsZero() {
+		t.Fatal("expected zero block time")
+	}
+}
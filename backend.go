@@ -0,0 +1,119 @@
+package chain
+
+import (
+	"errors"
+	"sort"
+)
+
+// ErrNotSupported is returned by Backend methods that the upstream service
+// cannot answer, and by Chain methods that need the Chain.com API, such as
+// notifications, when the Chain was created with NewWithBackend.
+var ErrNotSupported = errors.New("not supported by backend")
+
+// Backend is the source of blockchain data used by Chain. A Chain created
+// with New uses the Chain.com v2 REST API, and *Chain itself implements
+// Backend so that one Chain can be used as the Backend of another.
+//
+// Implementations must be safe for concurrent use. Limits and the number of
+// addresses are checked by Chain before a Backend is called.
+type Backend interface {
+	GetBlockByHash(hash string) (Block, error)
+	GetBlockByHeight(height uint64) (Block, error)
+	GetLatestBlock() (Block, error)
+	GetTransaction(hash string) (Transaction, error)
+	GetAddressMulti(addresses []string) ([]Address, error)
+	GetAddressTransactionsMulti(addresses []string,
+		limit int) ([]Transaction, error)
+	GetAddressUnspentOutputsMulti(addresses []string) ([]Output, error)
+	SendTransaction(hex string) (string, error)
+}
+
+var _ Backend = (*Chain)(nil)
+
+// HistoryBackend is a Backend that can page through the full transaction
+// history of addresses rather than only the latest
+// MaxAddressTransactionsLimit transactions. Chain, EsploraBackend and
+// CompositeBackend implement it.
+type HistoryBackend interface {
+	Backend
+
+	// GetAddressHistory returns every transaction involving addresses,
+	// without duplicates, newest first with unconfirmed transactions first.
+	GetAddressHistory(addresses []string) ([]Transaction, error)
+}
+
+var _ HistoryBackend = (*Chain)(nil)
+
+// NewWithBackend creates a Chain for network n that reads blocks,
+// transactions and addresses from b and sends transactions with b.
+// Notifications are a Chain.com feature and are not available through a
+// Chain created this way. Caching set with SetCache and WithContext only
+// apply to Chain.com requests.
+func NewWithBackend(b Backend, n Network) *Chain {
+	return &Chain{
+		backend: b,
+		network: n,
+		flights: newFlightGroup(),
+		noBatch: new(int32),
+	}
+}
+
+// singleKeyScript reports whether outputs of scriptType need one signature.
+func singleKeyScript(scriptType string) bool {
+	switch scriptType {
+	case "pubkey", "pubkeyhash", "witness_v0_keyhash", "witness_v1_taproot":
+		return true
+	}
+	return false
+}
+
+// outputTotal returns the sum of the values of outputs.
+func outputTotal(outputs []Output) Amount {
+	total := Amount(0)
+	for _, out := range outputs {
+		total += out.Value
+	}
+	return total
+}
+
+// inputTotal returns the sum of the values of inputs.
+func inputTotal(inputs []Input) Amount {
+	total := Amount(0)
+	for _, in := range inputs {
+		total += in.Value
+	}
+	return total
+}
+
+// mergeTransactions combines the transactions of several addresses, without
+// duplicates, newest first with unconfirmed transactions first as the
+// Chain.com address endpoints return them, and keeps at most limit.
+func mergeTransactions(lists [][]Transaction, limit int) []Transaction {
+	seen, txns := map[string]bool{}, []Transaction{}
+	for _, list := range lists {
+		for _, tx := range list {
+			if !seen[tx.Hash] {
+				seen[tx.Hash] = true
+				txns = append(txns, tx)
+			}
+		}
+	}
+	sort.Slice(txns, func(i, j int) bool {
+		a, b := txns[i], txns[j]
+		ha, hb := a.BlockHeight, b.BlockHeight
+		if a.Confirmations == 0 {
+			ha = 1<<63 - 1
+		}
+		if b.Confirmations == 0 {
+			hb = 1<<63 - 1
+		}
+		if ha != hb {
+			return ha > hb
+		}
+		return a.Hash < b.Hash
+	})
+	if len(txns) > limit {
+		txns = txns[:limit]
+	}
+	return txns
+}
@@ -0,0 +1,77 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var (
+	errBase58Character = errors.New("invalid base58 character")
+	errBase58Checksum  = errors.New("invalid base58 checksum")
+)
+
+// base58Encode encodes b in base 58, keeping leading zero bytes as '1's.
+func base58Encode(b []byte) string {
+	n := new(big.Int).SetBytes(b)
+	radix, mod := big.NewInt(58), new(big.Int)
+	out := []byte{}
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, '1')
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+// base58Decode is the inverse of base58Encode.
+func base58Decode(s string) ([]byte, error) {
+	n, radix := new(big.Int), big.NewInt(58)
+	for i := 0; i < len(s); i++ {
+		d := bytes.IndexByte([]byte(base58Alphabet), s[i])
+		if d < 0 {
+			return nil, errBase58Character
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(d)))
+	}
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+// base58CheckEncode encodes a version byte and payload followed by the first
+// four bytes of their double SHA-256, as used by legacy addresses.
+func base58CheckEncode(version byte, payload []byte) string {
+	b := append([]byte{version}, payload...)
+	sum := doubleSHA256(b)
+	return base58Encode(append(b, sum[:4]...))
+}
+
+// base58CheckDecode is the inverse of base58CheckEncode.
+func base58CheckDecode(s string) (byte, []byte, error) {
+	b, err := base58Decode(s)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(b) < 5 {
+		return 0, nil, errBase58Checksum
+	}
+	sum := doubleSHA256(b[:len(b)-4])
+	if !bytes.Equal(sum[:4], b[len(b)-4:]) {
+		return 0, nil, errBase58Checksum
+	}
+	return b[0], b[1 : len(b)-4], nil
+}
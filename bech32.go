@@ -0,0 +1,164 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Bech32 is specified by BIP 173 and Bech32m, used for witness versions
+// above zero, by BIP 350.
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Encoding selects the checksum constant.
+type bech32Encoding uint32
+
+const (
+	bech32  bech32Encoding = 1
+	bech32m bech32Encoding = 0x2bc830a3
+)
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd,
+		0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	b := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		b = append(b, hrp[i]>>5)
+	}
+	b = append(b, 0)
+	for i := 0; i < len(hrp); i++ {
+		b = append(b, hrp[i]&31)
+	}
+	return b
+}
+
+// bech32Encode encodes 5 bit values with the human readable part hrp.
+func bech32Encode(hrp string, data []byte, enc bech32Encoding) string {
+	values := append(bech32HRPExpand(hrp), data...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ uint32(enc)
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, d := range data {
+		sb.WriteByte(bech32Charset[d])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[(polymod>>uint(5*(5-i)))&31])
+	}
+	return sb.String()
+}
+
+// bech32Decode is the inverse of bech32Encode. The string may be all upper
+// case but not mixed case; the returned hrp is lower case.
+func bech32Decode(s string) (string, []byte, bech32Encoding, error) {
+	if len(s) > 90 {
+		return "", nil, 0, errors.New("bech32 string too long")
+	}
+	lower := strings.ToLower(s)
+	if lower != s && strings.ToUpper(s) != s {
+		return "", nil, 0, errors.New("bech32 string has mixed case")
+	}
+	pos := strings.LastIndexByte(lower, '1')
+	if pos < 1 || pos+7 > len(lower) {
+		return "", nil, 0, errors.New("invalid bech32 separator position")
+	}
+	hrp := lower[:pos]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, 0, errors.New("invalid bech32 human readable part")
+		}
+	}
+	data := make([]byte, 0, len(lower)-pos-1)
+	for i := pos + 1; i < len(lower); i++ {
+		d := strings.IndexByte(bech32Charset, lower[i])
+		if d < 0 {
+			return "", nil, 0, fmt.Errorf("invalid bech32 character %q",
+				lower[i])
+		}
+		data = append(data, byte(d))
+	}
+	enc := bech32Encoding(bech32Polymod(append(bech32HRPExpand(hrp), data...)))
+	if enc != bech32 && enc != bech32m {
+		return "", nil, 0, errors.New("invalid bech32 checksum")
+	}
+	return hrp, data[:len(data)-6], enc, nil
+}
+
+// convertBits regroups data from groups of from bits to groups of to bits.
+// When decoding, pad is false and leftover bits must be zero padding.
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	acc, bits := uint32(0), uint(0)
+	maxv := uint32(1)<<to - 1
+	out := []byte{}
+	for _, v := range data {
+		if uint32(v)>>from != 0 {
+			return nil, errors.New("invalid data value")
+		}
+		acc = acc<<from | uint32(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return out, nil
+}
+
+// encodeSegwitAddress encodes a witness program as a segwit address.
+func encodeSegwitAddress(hrp string, version byte, program []byte) string {
+	data, _ := convertBits(program, 8, 5, true)
+	enc := bech32
+	if version > 0 {
+		enc = bech32m
+	}
+	return bech32Encode(hrp, append([]byte{version}, data...), enc)
+}
+
+// decodeSegwitAddress decodes a segwit address, checking the witness
+// version, program length and checksum variant.
+func decodeSegwitAddress(s string) (string, byte, []byte, error) {
+	hrp, data, enc, err := bech32Decode(s)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if len(data) < 1 || data[0] > 16 {
+		return "", 0, nil, errors.New("invalid witness version")
+	}
+	version := data[0]
+	program, err := convertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if len(program) < 2 || len(program) > 40 {
+		return "", 0, nil, errors.New("invalid witness program length")
+	}
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return "", 0, nil, errors.New("invalid witness program length")
+	}
+	if (version == 0) != (enc == bech32) {
+		return "", 0, nil, errors.New("invalid checksum variant for witness version")
+	}
+	return hrp, version, program, nil
+}
@@ -0,0 +1,161 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// PaymentURI is a BIP 21 bitcoin: URI.
+type PaymentURI struct {
+	// Address may only be empty if Lightning is set.
+	Address string
+
+	// Amount is zero if the URI does not request an amount.
+	Amount Amount
+
+	Label   string
+	Message string
+
+	// Lightning is a BOLT 11 invoice offered as an alternative way to pay.
+	Lightning string
+
+	// Params holds any other parameters. Parameters starting with "req-"
+	// must be understood by the payer, so ParsePaymentURI rejects them.
+	Params map[string]string
+}
+
+// ParsePaymentURI parses a BIP 21 URI and validates its address against
+// network n.
+func ParsePaymentURI(uri string, n Network) (*PaymentURI, error) {
+	const scheme = "bitcoin:"
+	if len(uri) < len(scheme) || !strings.EqualFold(uri[:len(scheme)], scheme) {
+		return nil, errors.New("payment URI does not start with bitcoin:")
+	}
+	rest := uri[len(scheme):]
+	query := ""
+	if i := strings.IndexByte(rest, '?'); i >= 0 {
+		rest, query = rest[:i], rest[i+1:]
+	}
+
+	p := &PaymentURI{Address: rest}
+	seen := map[string]bool{}
+	for _, param := range strings.Split(query, "&") {
+		if param == "" {
+			continue
+		}
+		key, value := param, ""
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			key, value = param[:i], param[i+1:]
+		}
+		key, err := url.PathUnescape(key)
+		if err == nil {
+			value, err = url.PathUnescape(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid payment URI parameter %q: %v",
+				param, err)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate payment URI parameter %s", key)
+		}
+		seen[key] = true
+
+		switch {
+		case key == "amount":
+			// Amounts are decimal BTC without a unit or sign.
+			if strings.ContainsAny(value, "+-") {
+				return nil, fmt.Errorf("invalid payment URI amount %q", value)
+			}
+			if p.Amount, err = parseDecimalAmount(value, BTC, 8); err != nil {
+				return nil, fmt.Errorf("invalid payment URI amount %q: %v",
+					value, err)
+			}
+		case key == "label":
+			p.Label = value
+		case key == "message":
+			p.Message = value
+		case key == "lightning":
+			p.Lightning = value
+		case strings.HasPrefix(key, "req-"):
+			return nil, fmt.Errorf("unsupported required payment URI "+
+				"parameter %s", key)
+		default:
+			if p.Params == nil {
+				p.Params = map[string]string{}
+			}
+			p.Params[key] = value
+		}
+	}
+
+	if p.Address == "" {
+		if p.Lightning == "" {
+			return nil, errors.New("payment URI has no address")
+		}
+		return p, nil
+	}
+	if err := ValidateAddress(p.Address, n); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ParsePaymentURI parses a BIP 21 URI and validates its address against the
+// network of c.
+func (c *Chain) ParsePaymentURI(uri string) (*PaymentURI, error) {
+	return ParsePaymentURI(uri, c.network)
+}
+
+// PaymentURI returns a BIP 21 URI requesting amount to address, for example
+// the address and amount of an Invoice. The address is validated against the
+// network of c and a zero amount is left out.
+func (c *Chain) PaymentURI(address string, amount Amount) (string, error) {
+	if err := c.ValidateAddress(address); err != nil {
+		return "", err
+	}
+	if amount < 0 {
+		return "", fmt.Errorf("invalid payment URI amount %s", amount)
+	}
+	p := &PaymentURI{Address: address, Amount: amount}
+	return p.String(), nil
+}
+
+// uriEscape percent encodes everything except unreserved characters, so
+// spaces become %20 rather than +.
+func uriEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// String encodes p with its parameters in a fixed order: amount, label,
+// message, lightning and then Params sorted by name.
+func (p *PaymentURI) String() string {
+	params := []string{}
+	if p.Amount != 0 {
+		params = append(params, "amount="+p.Amount.formatDecimal(BTC, 8))
+	}
+	for _, kv := range [][2]string{
+		{"label", p.Label},
+		{"message", p.Message},
+		{"lightning", p.Lightning},
+	} {
+		if kv[1] != "" {
+			params = append(params, kv[0]+"="+uriEscape(kv[1]))
+		}
+	}
+	keys := make([]string, 0, len(p.Params))
+	for k := range p.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		params = append(params, uriEscape(k)+"="+uriEscape(p.Params[k]))
+	}
+
+	uri := "bitcoin:" + p.Address
+	if len(params) > 0 {
+		uri += "?" + strings.Join(params, "&")
+	}
+	return uri
+}
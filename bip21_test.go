@@ -0,0 +1,71 @@
+package chain_test
+
+import (
+	"testing"
+
+	"github.com/qedus/chain"
+)
+
+func TestParsePaymentURI(t *testing.T) {
+	p, err := chain.ParsePaymentURI("BITCOIN:"+testAddress+
+		"?amount=20.3&label=Luke%20Jr&message=Donation+for%20project%20xyz"+
+		"&lightning=lnbc1&somethingyoudontunderstand=50",
+		chain.MainNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Address != testAddress || p.Amount != 2030000000 ||
+		p.Label != "Luke Jr" || p.Message != "Donation+for project xyz" ||
+		p.Lightning != "lnbc1" ||
+		p.Params["somethingyoudontunderstand"] != "50" {
+		t.Fatal("unexpected URI", p)
+	}
+
+	p, err = chain.ParsePaymentURI("bitcoin:?lightning=lnbc1", chain.MainNet)
+	if err != nil || p.Address != "" {
+		t.Fatal("expected lightning only URI", p, err)
+	}
+
+	for _, uri := range []string{
+		"bitcoin:" + testAddress + "?req-somethingyoudontunderstand=50",
+		"bitcoin:" + testAddress + "?amount=0.000000001",
+		"bitcoin:" + testAddress + "?amount=1e3",
+		"bitcoin:" + testAddress + "?amount=-1",
+		"bitcoin:" + testAddress + "?amount=1&amount=2",
+		"bitcoin:" + testAddress + "?label=%zz",
+		"bitcoin:mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn",
+		"bitcoin:",
+		"litecoin:" + testAddress,
+	} {
+		if _, err := chain.ParsePaymentURI(uri, chain.MainNet); err == nil {
+			t.Fatal("expected error", uri)
+		}
+	}
+}
+
+func TestPaymentURIString(t *testing.T) {
+	p := &chain.PaymentURI{
+		Address: testAddress,
+		Amount:  chain.BTC + 1,
+		Label:   "Order #12 & more",
+		Params:  map[string]string{"b": "2", "a": "1"},
+	}
+	expected := "bitcoin:" + testAddress +
+		"?amount=1.00000001&label=Order%20%2312%20%26%20more&a=1&b=2"
+	if p.String() != expected {
+		t.Fatal("unexpected URI", p.String())
+	}
+	parsed, err := chain.ParsePaymentURI(p.String(), chain.MainNet)
+	if err != nil || parsed.Label != p.Label || parsed.Amount != p.Amount {
+		t.Fatal("round trip failed", parsed, err)
+	}
+
+	c := chain.New(nil, chain.TestNet3, "", "")
+	if _, err := c.PaymentURI(testAddress, chain.BTC); err == nil {
+		t.Fatal("expected network error")
+	}
+	uri, err := c.PaymentURI("mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn", 0)
+	if err != nil || uri != "bitcoin:mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn" {
+		t.Fatal("unexpected URI", uri, err)
+	}
+}
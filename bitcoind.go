@@ -0,0 +1,462 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// rpcInvalidAddressOrKey is the Bitcoin Core RPC error code returned for
+// unknown blocks and transactions.
+const rpcInvalidAddressOrKey = -5
+
+// rpcVerifyAlreadyInChain is the Bitcoin Core RPC error code returned when a
+// sent transaction is already in the block chain.
+const rpcVerifyAlreadyInChain = -27
+
+// RPCError is an error returned by a Bitcoin Core JSON-RPC call.
+type RPCError struct {
+	Code    int
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// BitcoindBackend is a Backend for the JSON-RPC interface of a Bitcoin Core
+// node. The node must be run with -txindex to look up transactions that are
+// not in its mempool or wallet.
+//
+// Bitcoin Core does not index addresses. GetAddressUnspentOutputsMulti scans
+// the UTXO set, which takes a while, and GetAddressMulti reports only the
+// confirmed balance found this way with Received and Sent left zero.
+// GetAddressTransactionsMulti returns ErrNotSupported. Output.Spent is
+// always false.
+//
+// Bitcoin Core documentation can be found here
+// https://developer.bitcoin.org/reference/rpc/.
+type BitcoindBackend struct {
+	client   *http.Client
+	url      string
+	user     string
+	password string
+
+	// ctx is nil for requests that are not canceled.
+	ctx context.Context
+
+	// nextID is shared by copies made with BackendWithContext.
+	nextID *int64
+}
+
+// NewBitcoindBackend creates a BitcoindBackend for the node at url, for
+// example "http://127.0.0.1:8332", authenticating with the RPC user and
+// password.
+func NewBitcoindBackend(c *http.Client,
+	url, user, password string) *BitcoindBackend {
+	return &BitcoindBackend{client: c, url: url, user: user, password: password,
+		nextID: new(int64)}
+}
+
+// BackendWithContext implements ContextBackend. The copy's requests use ctx.
+func (b *BitcoindBackend) BackendWithContext(ctx context.Context) Backend {
+	copied := *b
+	copied.ctx = ctx
+	return &copied
+}
+
+// rpcCall is one call in a JSON-RPC batch. Result is decoded into result and
+// any error is stored in err.
+type rpcCall struct {
+	method string
+	params []interface{}
+	result interface{}
+	err    error
+}
+
+// batch makes calls in one HTTP request. The returned error is for the
+// request as a whole; errors from individual calls are set on them.
+func (b *BitcoindBackend) batch(calls []*rpcCall) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	type request struct {
+		JSONRPC string        `json:"jsonrpc"`
+		ID      int64         `json:"id"`
+		Method  string        `json:"method"`
+		Params  []interface{} `json:"params"`
+	}
+	requests, byID := []request{}, map[int64]*rpcCall{}
+	for _, call := range calls {
+		id := atomic.AddInt64(b.nextID, 1)
+		params := call.params
+		if params == nil {
+			params = []interface{}{}
+		}
+		requests = append(requests, request{"1.0", id, call.method, params})
+		byID[id] = call
+	}
+	body, err := json.Marshal(requests)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", b.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(b.user, b.password)
+	if b.ctx != nil {
+		req = req.WithContext(b.ctx)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if err := checkHTTPResponse(resp); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	responses := []struct {
+		ID     int64
+		Result json.RawMessage
+		Error  *RPCError
+	}{}
+	if err := decodeJSON(bytes.NewReader(data), &responses); err != nil {
+		return err
+	}
+	for _, r := range responses {
+		call, ok := byID[r.ID]
+		if !ok {
+			continue
+		}
+		delete(byID, r.ID)
+		switch {
+		case r.Error != nil:
+			call.err = r.Error
+		case call.result != nil:
+			call.err = json.Unmarshal(r.Result, call.result)
+		}
+	}
+	for _, call := range byID {
+		call.err = fmt.Errorf("no response to %s", call.method)
+	}
+	return nil
+}
+
+// call makes a single JSON-RPC call.
+func (b *BitcoindBackend) call(result interface{}, method string,
+	params ...interface{}) error {
+	c := &rpcCall{method: method, params: params, result: result}
+	if err := b.batch([]*rpcCall{c}); err != nil {
+		return err
+	}
+	return c.err
+}
+
+// rpcAmount converts a bitcoin value from JSON-RPC exactly.
+func rpcAmount(n json.Number) (Amount, error) {
+	return ParseAmount(n.String())
+}
+
+type bitcoindBlock struct {
+	Hash              string
+	Confirmations     int64
+	Height            int64
+	Version           int32
+	MerkleRoot        string `json:"merkleroot"`
+	Tx                []string
+	Time              int64
+	Nonce             uint32
+	Bits              string
+	Difficulty        float64
+	PreviousBlockHash string `json:"previousblockhash"`
+}
+
+// GetBlockByHash implements Backend.
+func (b *BitcoindBackend) GetBlockByHash(hash string) (Block, error) {
+	bb := bitcoindBlock{}
+	if err := b.call(&bb, "getblock", hash, 1); err != nil {
+		return Block{}, err
+	}
+	block := Block{
+		Hash:              bb.Hash,
+		PreviousHash:      bb.PreviousBlockHash,
+		Height:            bb.Height,
+		Version:           bb.Version,
+		MerkleRoot:        bb.MerkleRoot,
+		Time:              time.Unix(bb.Time, 0).UTC(),
+		Nonce:             bb.Nonce,
+		Difficulty:        bb.Difficulty,
+		Bits:              bb.Bits,
+		TransactionHashes: bb.Tx,
+	}
+	// Blocks that are not in the best chain have -1 confirmations.
+	if bb.Confirmations > 0 {
+		block.Confirmations = bb.Confirmations
+	}
+	return block, nil
+}
+
+// GetBlockByHeight implements Backend.
+func (b *BitcoindBackend) GetBlockByHeight(height uint64) (Block, error) {
+	hash := ""
+	if err := b.call(&hash, "getblockhash", height); err != nil {
+		return Block{}, err
+	}
+	return b.GetBlockByHash(hash)
+}
+
+// GetLatestBlock implements Backend.
+func (b *BitcoindBackend) GetLatestBlock() (Block, error) {
+	hash := ""
+	if err := b.call(&hash, "getbestblockhash"); err != nil {
+		return Block{}, err
+	}
+	return b.GetBlockByHash(hash)
+}
+
+type bitcoindOutput struct {
+	Value        json.Number
+	N            uint32
+	ScriptPubKey struct {
+		ASM       string
+		Hex       string
+		Type      string
+		Address   string
+		Addresses []string
+		ReqSigs   int64 `json:"reqSigs"`
+	} `json:"scriptPubKey"`
+}
+
+func (o bitcoindOutput) output(txid string) (Output, error) {
+	value, err := rpcAmount(o.Value)
+	if err != nil {
+		return Output{}, err
+	}
+	spk := o.ScriptPubKey
+	out := Output{
+		TransactionHash:    txid,
+		OutputIndex:        o.N,
+		Value:              value,
+		Addresses:          spk.Addresses,
+		Script:             spk.ASM,
+		ScriptHex:          spk.Hex,
+		ScriptType:         spk.Type,
+		RequiredSignatures: spk.ReqSigs,
+	}
+	// Bitcoin Core 22 replaced addresses and reqSigs with address.
+	if spk.Address != "" {
+		out.Addresses = []string{spk.Address}
+	}
+	if out.RequiredSignatures == 0 && singleKeyScript(out.ScriptType) {
+		out.RequiredSignatures = 1
+	}
+	return out, nil
+}
+
+type bitcoindTransaction struct {
+	TxID          string
+	BlockHash     string
+	Confirmations int64
+	BlockTime     int64
+	Vin           []struct {
+		TxID      string
+		Vout      uint32
+		Coinbase  string
+		ScriptSig struct {
+			ASM string
+		}
+		Sequence uint32
+	}
+	Vout []bitcoindOutput
+}
+
+// GetTransaction implements Backend. The outputs spent by the transaction are
+// fetched to find the input values and addresses.
+func (b *BitcoindBackend) GetTransaction(hash string) (Transaction, error) {
+	bt := bitcoindTransaction{}
+	if err := b.call(&bt, "getrawtransaction", hash, true); err != nil {
+		return Transaction{}, err
+	}
+
+	calls, prevouts := []*rpcCall{}, map[string]*bitcoindTransaction{}
+	header := struct{ Height int64 }{}
+	if bt.BlockHash != "" {
+		calls = append(calls, &rpcCall{method: "getblockheader",
+			params: []interface{}{bt.BlockHash}, result: &header})
+	}
+	for _, vin := range bt.Vin {
+		if vin.Coinbase != "" || prevouts[vin.TxID] != nil {
+			continue
+		}
+		prevouts[vin.TxID] = &bitcoindTransaction{}
+		calls = append(calls, &rpcCall{method: "getrawtransaction",
+			params: []interface{}{vin.TxID, true}, result: prevouts[vin.TxID]})
+	}
+	if err := b.batch(calls); err != nil {
+		return Transaction{}, err
+	}
+	for _, call := range calls {
+		if call.err != nil {
+			return Transaction{}, call.err
+		}
+	}
+
+	tx := Transaction{Hash: bt.TxID}
+	if bt.BlockHash != "" && bt.Confirmations > 0 {
+		tx.BlockHash = bt.BlockHash
+		tx.BlockHeight = header.Height
+		tx.BlockTime = time.Unix(bt.BlockTime, 0).UTC()
+		tx.Confirmations = bt.Confirmations
+	}
+	coinbase := false
+	for _, vin := range bt.Vin {
+		in := Input{TransactionHash: bt.TxID, Sequence: vin.Sequence}
+		if vin.Coinbase != "" {
+			in.Coinbase = vin.Coinbase
+			coinbase = true
+			tx.Inputs = append(tx.Inputs, in)
+			continue
+		}
+		in.OutputHash = vin.TxID
+		in.OutputIndex = vin.Vout
+		in.ScriptSignature = vin.ScriptSig.ASM
+
+		prev := prevouts[vin.TxID]
+		if int(vin.Vout) >= len(prev.Vout) {
+			return Transaction{}, fmt.Errorf("missing output %s:%d",
+				vin.TxID, vin.Vout)
+		}
+		out, err := prev.Vout[vin.Vout].output(vin.TxID)
+		if err != nil {
+			return Transaction{}, err
+		}
+		in.Value, in.Addresses = out.Value, out.Addresses
+		tx.Inputs = append(tx.Inputs, in)
+	}
+	for _, vout := range bt.Vout {
+		out, err := vout.output(bt.TxID)
+		if err != nil {
+			return Transaction{}, err
+		}
+		tx.Outputs = append(tx.Outputs, out)
+	}
+	tx.Amount = outputTotal(tx.Outputs)
+	if !coinbase {
+		tx.Fees = inputTotal(tx.Inputs) - tx.Amount
+	}
+	return tx, nil
+}
+
+// GetAddressMulti implements Backend. See BitcoindBackend for its
+// limitations.
+func (b *BitcoindBackend) GetAddressMulti(
+	addresses []string) ([]Address, error) {
+	outputs, err := b.GetAddressUnspentOutputsMulti(addresses)
+	if err != nil {
+		return nil, err
+	}
+	balances := map[string]Amount{}
+	for _, out := range outputs {
+		balances[out.Addresses[0]] += out.Value
+	}
+	result := make([]Address, len(addresses))
+	for i, a := range addresses {
+		result[i].Address = a
+		result[i].Confirmed.Balance = balances[a]
+		result[i].Total.Balance = balances[a]
+	}
+	return result, nil
+}
+
+// GetAddressTransactionsMulti implements Backend. It always returns
+// ErrNotSupported as Bitcoin Core does not index addresses.
+func (b *BitcoindBackend) GetAddressTransactionsMulti(addresses []string,
+	limit int) ([]Transaction, error) {
+	return nil, ErrNotSupported
+}
+
+// GetAddressUnspentOutputsMulti implements Backend using scantxoutset. Only
+// confirmed outputs are found.
+func (b *BitcoindBackend) GetAddressUnspentOutputsMulti(
+	addresses []string) ([]Output, error) {
+	// The scan reports scripts, so each address is mapped to its script.
+	type validation struct {
+		IsValid      bool   `json:"isvalid"`
+		ScriptPubKey string `json:"scriptPubKey"`
+	}
+	calls, results := []*rpcCall{}, make([]validation, len(addresses))
+	descriptors := []interface{}{}
+	for i, a := range addresses {
+		calls = append(calls, &rpcCall{method: "validateaddress",
+			params: []interface{}{a}, result: &results[i]})
+		descriptors = append(descriptors, "addr("+a+")")
+	}
+	if err := b.batch(calls); err != nil {
+		return nil, err
+	}
+	scripts := map[string]string{}
+	for i, call := range calls {
+		if call.err != nil {
+			return nil, call.err
+		}
+		if !results[i].IsValid {
+			return nil, fmt.Errorf("invalid address %s", addresses[i])
+		}
+		scripts[results[i].ScriptPubKey] = addresses[i]
+	}
+
+	scan := struct {
+		Success  bool
+		Height   int64
+		Unspents []struct {
+			TxID         string
+			Vout         uint32
+			ScriptPubKey string `json:"scriptPubKey"`
+			Amount       json.Number
+			Height       int64
+		}
+	}{}
+	if err := b.call(&scan, "scantxoutset", "start", descriptors); err != nil {
+		return nil, err
+	}
+	if !scan.Success {
+		return nil, errors.New("scantxoutset did not complete")
+	}
+
+	outputs := []Output{}
+	for _, u := range scan.Unspents {
+		value, err := rpcAmount(u.Amount)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, Output{
+			TransactionHash: u.TxID,
+			OutputIndex:     u.Vout,
+			Value:           value,
+			Addresses:       []string{scripts[u.ScriptPubKey]},
+			ScriptHex:       u.ScriptPubKey,
+			Confirmations:   scan.Height - u.Height + 1,
+		})
+	}
+	return outputs, nil
+}
+
+// SendTransaction implements Backend.
+func (b *BitcoindBackend) SendTransaction(hex string) (string, error) {
+	hash := ""
+	return hash, b.call(&hash, "sendrawtransaction", hex)
+}
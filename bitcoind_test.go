@@ -0,0 +1,159 @@
+package chain_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/qedus/chain"
+)
+
+// bitcoindNode is a stand-in for the JSON-RPC interface of a Bitcoin Core
+// node holding one block with one transaction.
+type bitcoindNode struct {
+	t       *testing.T
+	results map[string]interface{}
+}
+
+func newBitcoindNode(t *testing.T) *bitcoindNode {
+	blockHash, txHash := fakeHash("block", 100), fakeHash("tx", 1)
+	funding := fakeHash("funding")
+	return &bitcoindNode{t, map[string]interface{}{
+		"getbestblockhash": blockHash,
+		"getblockhash 100": blockHash,
+		"getblock " + blockHash: map[string]interface{}{
+			"hash": blockHash, "confirmations": 3, "height": 100,
+			"version": 2, "merkleroot": txHash, "tx": []string{txHash},
+			"time": 1400000000, "nonce": 7, "bits": "1d00ffff",
+			"difficulty": 1, "previousblockhash": fakeHash("block", 99),
+		},
+		"getblockheader " + blockHash: map[string]interface{}{"height": 100},
+		"getrawtransaction " + txHash: json.RawMessage(`{
+			"txid": "` + txHash + `", "blockhash": "` + blockHash + `",
+			"confirmations": 3, "blocktime": 1400000000,
+			"vin": [{"txid": "` + funding + `", "vout": 1,
+				"scriptSig": {"asm": "3045"}, "sequence": 4294967295}],
+			"vout": [{"value": 0.00005000, "n": 0, "scriptPubKey": {
+				"asm": "OP_0", "hex": "0014", "type": "witness_v0_keyhash",
+				"address": "` + otherAddress + `"}}]}`),
+		"getrawtransaction " + funding: json.RawMessage(`{
+			"txid": "` + funding + `",
+			"vin": [{"coinbase": "04ffff", "sequence": 4294967295}],
+			"vout": [{"value": 0, "n": 0, "scriptPubKey": {"type": "nulldata"}},
+				{"value": 0.00006000, "n": 1, "scriptPubKey": {
+				"hex": "76a914", "type": "pubkeyhash", "reqSigs": 1,
+				"addresses": ["` + testAddress + `"]}}]}`),
+		"validateaddress " + otherAddress: map[string]interface{}{
+			"isvalid": true, "scriptPubKey": "0014",
+		},
+		"scantxoutset start": json.RawMessage(`{"success": true,
+			"height": 102, "unspents": [{"txid": "` + txHash + `",
+			"vout": 0, "scriptPubKey": "0014", "amount": 0.00005000,
+			"height": 100}]}`),
+		"sendrawtransaction 0100": txHash,
+	}}
+}
+
+func (n *bitcoindNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, _ := r.BasicAuth(); user != "rpc" ||
+		password != "secret" {
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+	requests := []struct {
+		ID     int64
+		Method string
+		Params []interface{}
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+		n.t.Error(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	responses := []map[string]interface{}{}
+	for _, req := range requests {
+		// Results are keyed by method and first parameter.
+		key := req.Method
+		if len(req.Params) > 0 {
+			key += " " + fmt.Sprint(req.Params[0])
+		}
+		result, ok := n.results[key]
+		if !ok {
+			responses = append(responses, map[string]interface{}{
+				"id": req.ID, "result": nil, "error": map[string]interface{}{
+					"code": -5, "message": "No such transaction",
+				}})
+			continue
+		}
+		responses = append(responses, map[string]interface{}{
+			"id": req.ID, "result": result, "error": nil})
+	}
+	writeTestJSON(w, http.StatusOK, responses)
+}
+
+func TestBitcoindBackend(t *testing.T) {
+	s := httptest.NewServer(newBitcoindNode(t))
+	defer s.Close()
+	c := chain.NewWithBackend(chain.NewBitcoindBackend(s.Client(), s.URL,
+		"rpc", "secret"), chain.MainNet)
+
+	block, err := c.GetBlockByHeight(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Hash != fakeHash("block", 100) || block.Confirmations != 3 ||
+		block.Height != 100 || len(block.TransactionHashes) != 1 {
+		t.Fatal("unexpected block", block)
+	}
+	if latest, err := c.GetLatestBlock(); err != nil || latest.Hash != block.Hash {
+		t.Fatal("unexpected latest block", latest, err)
+	}
+
+	tx, err := c.GetTransaction(fakeHash("tx", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.BlockHeight != 100 || tx.Confirmations != 3 || tx.Amount != 5000 ||
+		tx.Fees != 1000 {
+		t.Fatal("unexpected transaction", tx)
+	}
+	in, out := tx.Inputs[0], tx.Outputs[0]
+	if in.Value != 6000 || in.Addresses[0] != testAddress ||
+		in.ScriptSignature != "3045" {
+		t.Fatal("unexpected input", in)
+	}
+	if out.Addresses[0] != otherAddress || out.RequiredSignatures != 1 {
+		t.Fatal("unexpected output", out)
+	}
+
+	unspents, err := c.GetAddressUnspentOutputs(otherAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unspents) != 1 || unspents[0].Value != 5000 ||
+		unspents[0].Confirmations != 3 ||
+		unspents[0].Addresses[0] != otherAddress {
+		t.Fatal("unexpected unspents", unspents)
+	}
+	addr, err := c.GetAddress(otherAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.Confirmed.Balance != 5000 || addr.Total.Balance != 5000 {
+		t.Fatal("unexpected address", addr)
+	}
+
+	if _, err := c.GetAddressTransactions(otherAddress, 0); err !=
+		chain.ErrNotSupported {
+		t.Fatal("expected not supported", err)
+	}
+	if hash, err := c.SendTransaction("0100"); err != nil || hash != tx.Hash {
+		t.Fatal("unexpected send", hash, err)
+	}
+	if _, err := c.GetTransaction(fakeHash("missing")); !chain.IsNotFound(err) {
+		t.Fatal("expected not found", err)
+	}
+}
@@ -2,6 +2,7 @@ package chain
 
 import (
 	"fmt"
+	"time"
 )
 
 // Block represents a Bitcoin block.
@@ -15,7 +16,7 @@ type Block struct {
 	Version           int32
 	Confirmations     int64
 	MerkleRoot        string `json:"merkle_root"`
-	Time              string
+	Time              time.Time
 	Nonce             uint32
 	Difficulty        float64
 	Bits              string
@@ -27,9 +28,11 @@ type Block struct {
 // Chain documentation can be found here
 // https://chain.com/docs#bitcoin-block.
 func (c *Chain) GetBlockByHash(hash string) (Block, error) {
+	if c.backend != nil {
+		return c.backend.GetBlockByHash(hash)
+	}
 	url := fmt.Sprintf("%s/%s/blocks/%s", baseURL, c.network, hash)
-	block := Block{}
-	return block, c.httpGetJSON(url, &block)
+	return c.getBlock(url)
 }
 
 // GetBlockByHeight returns a Bitcoin block at the specified height.
@@ -37,9 +40,13 @@ func (c *Chain) GetBlockByHash(hash string) (Block, error) {
 // Chain documentation can be found here
 // https://chain.com/docs#bitcoin-block.
 func (c *Chain) GetBlockByHeight(height uint64) (Block, error) {
-	url, block := fmt.Sprintf("%s/%s/blocks/%d",
-		baseURL, c.network, height), Block{}
-	return block, c.httpGetJSON(url, &block)
+	if c.backend != nil {
+		return c.backend.GetBlockByHeight(height)
+	}
+	// The block at a height changes on a reorg so is only cached briefly.
+	url, block := fmt.Sprintf("%s/%s/blocks/%d", baseURL, c.network,
+		height), Block{}
+	return block, c.cachedGetJSON(url, &block)
 }
 
 // GetLatestBlock returns the latest Bitcoin block.
@@ -47,7 +54,10 @@ func (c *Chain) GetBlockByHeight(height uint64) (Block, error) {
 // Chain documentation can be found here
 // https://chain.com/docs#bitcoin-block.
 func (c *Chain) GetLatestBlock() (Block, error) {
+	if c.backend != nil {
+		return c.backend.GetLatestBlock()
+	}
 	url, block := fmt.Sprintf("%s/%s/blocks/latest",
 		baseURL, c.network), Block{}
-	return block, c.httpGetJSON(url, &block)
+	return block, c.cachedGetJSON(url, &block)
 }
@@ -0,0 +1,94 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultPropagationPollInterval is the default time SendTransactionWait
+// waits between lookups of a sent transaction.
+const DefaultPropagationPollInterval = 2 * time.Second
+
+// ErrNotPropagated is returned by SendTransactionWait when a sent transaction
+// cannot be found before the timeout.
+var ErrNotPropagated = errors.New("transaction not found after sending")
+
+// alreadyKnownMessages are fragments of the errors nodes and APIs return
+// when sent a transaction they already have.
+var alreadyKnownMessages = []string{
+	"already in block chain",
+	"already in chain",
+	"already known",
+	"txn-already-in-mempool",
+	"transaction already exists",
+	"outputs already in utxo set",
+}
+
+// IsAlreadyKnown reports whether err is a SendTransaction error saying the
+// network already has the transaction.
+func IsAlreadyKnown(err error) bool {
+	if e, ok := err.(*RPCError); ok && e.Code == rpcVerifyAlreadyInChain {
+		return true
+	}
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, m := range alreadyKnownMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// TransactionHashMismatchError is returned by SendTransaction when the hash
+// returned by the network is not the hash of the transaction sent. The
+// transaction may have been sent.
+type TransactionHashMismatchError struct {
+	Expected string
+	Returned string
+}
+
+func (e *TransactionHashMismatchError) Error() string {
+	return fmt.Sprintf("sent transaction %s but got hash %s",
+		e.Expected, e.Returned)
+}
+
+// SendTransactionWait sends a transaction with SendTransaction and then
+// looks it up with GetTransaction, every DefaultPropagationPollInterval,
+// until the network returns it. If it is not found within timeout the hash
+// is returned with ErrNotPropagated.
+func (c *Chain) SendTransactionWait(hex string,
+	timeout time.Duration) (string, error) {
+	hash, err := c.SendTransaction(hex)
+	if err != nil {
+		return "", err
+	}
+
+	ctx := c.context()
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := c.GetTransaction(hash)
+		switch {
+		case err == nil:
+			return hash, nil
+		case !IsNotFound(err):
+			return hash, err
+		}
+
+		wait := DefaultPropagationPollInterval
+		if remaining := time.Until(deadline); remaining <= 0 {
+			return hash, ErrNotPropagated
+		} else if remaining < wait {
+			wait = remaining
+		}
+		select {
+		case <-ctx.Done():
+			return hash, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package chain_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/qedus/chain"
+)
+
+// sendHandler answers every send with status and v and passes other requests
+// to api.
+func sendHandler(api *fakeAPI, status int, v interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			writeTestJSON(w, status, v)
+			return
+		}
+		api.ServeHTTP(w, r)
+	})
+}
+
+func TestSendTransactionIdempotent(t *testing.T) {
+	tx := policyTransaction(t, 90000)
+	hex := rawHex(t, tx)
+	hash, _ := tx.Hash()
+
+	api := newFakeAPI(t, chain.MainNet, 1)
+	c := newTestChain(t, chain.MainNet, api)
+	if sent, err := c.SendTransaction(hex); err != nil || sent != hash {
+		t.Fatal("unexpected send", sent, err)
+	}
+
+	for _, message := range []string{
+		"Transaction already in block chain",
+		"txn-already-in-mempool",
+	} {
+		c = newTestChain(t, chain.MainNet, sendHandler(api,
+			http.StatusBadRequest, map[string]string{"message": message}))
+		if sent, err := c.SendTransaction(hex); err != nil || sent != hash {
+			t.Fatal("expected already known to succeed", message, sent, err)
+		}
+	}
+
+	// A failed send succeeds if the transaction went out anyway.
+	c = newTestChain(t, chain.MainNet, sendHandler(api,
+		http.StatusGatewayTimeout, map[string]string{"message": "timeout"}))
+	if sent, err := c.SendTransaction(hex); err != nil || sent != hash {
+		t.Fatal("expected known transaction to succeed", sent, err)
+	}
+	unknown := rawHex(t, policyTransaction(t, 80000))
+	if _, err := c.SendTransaction(unknown); err == nil {
+		t.Fatal("expected send error")
+	}
+
+	c = newTestChain(t, chain.MainNet, sendHandler(api, http.StatusOK,
+		map[string]string{"transaction_hash": fakeHash("other")}))
+	_, err := c.SendTransaction(hex)
+	if e, ok := err.(*chain.TransactionHashMismatchError); !ok ||
+		e.Expected != hash {
+		t.Fatal("expected hash mismatch", err)
+	}
+}
+
+func TestIsAlreadyKnown(t *testing.T) {
+	if !chain.IsAlreadyKnown(&chain.RPCError{Code: -27,
+		Message: "Transaction outputs already in utxo set"}) {
+		t.Fatal("expected already known")
+	}
+	if chain.IsAlreadyKnown(&chain.RPCError{Code: -26,
+		Message: "bad-txns-inputs-missingorspent"}) ||
+		chain.IsAlreadyKnown(nil) {
+		t.Fatal("unexpected already known")
+	}
+}
+
+func TestSendTransactionWait(t *testing.T) {
+	tx := policyTransaction(t, 90000)
+	hash, _ := tx.Hash()
+
+	api := newFakeAPI(t, chain.MainNet, 1)
+	c := newTestChain(t, chain.MainNet, api)
+	sent, err := c.SendTransactionWait(rawHex(t, tx), time.Second)
+	if err != nil || sent != hash {
+		t.Fatal("unexpected send", sent, err)
+	}
+
+	// The network accepts the transaction but never returns it.
+	other := policyTransaction(t, 80000)
+	otherHash, _ := other.Hash()
+	c = newTestChain(t, chain.MainNet, sendHandler(api, http.StatusOK,
+		map[string]string{"transaction_hash": otherHash}))
+	sent, err = c.SendTransactionWait(rawHex(t, other), 10*time.Millisecond)
+	if err != chain.ErrNotPropagated || sent != otherHash {
+		t.Fatal("expected not propagated", sent, err)
+	}
+}
@@ -0,0 +1,304 @@
+package chain
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCacheConfirmations is the default number of confirmations
+	// after which blocks read by hash and transactions are treated as
+	// immutable and cached indefinitely.
+	DefaultCacheConfirmations = 6
+
+	// DefaultCacheTTL is the default time mutable responses, such as the
+	// latest block and address balances, are cached for.
+	DefaultCacheTTL = 10 * time.Second
+)
+
+// Cache stores API responses. Implementations must be safe for concurrent
+// use.
+type Cache interface {
+	// Get returns the value stored for key if it exists and has not
+	// expired.
+	Get(key string) ([]byte, bool)
+
+	// Set stores value for key. A zero ttl means the value never expires.
+	Set(key string, value []byte, ttl time.Duration)
+}
+
+// CachePolicy controls what a Chain caches and for how long.
+type CachePolicy struct {
+	// Confirmations is the number of confirmations after which blocks and
+	// transactions are cached indefinitely. It defaults to
+	// DefaultCacheConfirmations.
+	Confirmations int64
+
+	// TTL is the time mutable responses are cached for. It defaults to
+	// DefaultCacheTTL.
+	TTL time.Duration
+}
+
+// SetCache makes c cache responses in cache according to policy. Blocks read
+// by hash and transactions with enough confirmations are stored indefinitely
+// and their Confirmations are recomputed from the latest block when read
+// back. The latest block, blocks read by height, which change on a reorg,
+// less confirmed blocks and transactions, and address data are stored for
+// policy.TTL. A nil cache disables caching. SetCache must be called before c
+// is used.
+func (c *Chain) SetCache(cache Cache, policy CachePolicy) {
+	if policy.Confirmations <= 0 {
+		policy.Confirmations = DefaultCacheConfirmations
+	}
+	if policy.TTL <= 0 {
+		policy.TTL = DefaultCacheTTL
+	}
+	c.cache, c.cachePolicy = cache, policy
+}
+
+// cachedGetJSON gets url through the cache with the mutable TTL.
+func (c *Chain) cachedGetJSON(url string, v interface{}) error {
+	if c.cache == nil {
+		return c.httpGetJSON(url, v)
+	}
+	if data, ok := c.cache.Get(url); ok {
+		if err := json.Unmarshal(data, v); err == nil {
+			return nil
+		}
+	}
+
+	raw := json.RawMessage{}
+	if err := c.httpGetJSON(url, &raw); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return err
+	}
+	c.cache.Set(url, raw, c.cachePolicy.TTL)
+	return nil
+}
+
+// cachedGetConfirmed gets url through the cache. The response is stored
+// indefinitely if confirmations reports it has enough confirmations.
+func (c *Chain) cachedGetConfirmed(url string, v interface{},
+	confirmations func() int64) (bool, error) {
+	if data, ok := c.cache.Get(url); ok {
+		if err := json.Unmarshal(data, v); err == nil {
+			return confirmations() >= c.cachePolicy.Confirmations, nil
+		}
+	}
+
+	raw := json.RawMessage{}
+	if err := c.httpGetJSON(url, &raw); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return false, err
+	}
+
+	if confirmations() >= c.cachePolicy.Confirmations {
+		c.cache.Set(url, raw, 0)
+	} else {
+		c.cache.Set(url, raw, c.cachePolicy.TTL)
+	}
+	return false, nil
+}
+
+// currentConfirmations recomputes the confirmations of something at height
+// from the latest block. If the latest block cannot be fetched the stored
+// confirmations, a lower bound, are kept.
+func (c *Chain) currentConfirmations(height, stored int64) int64 {
+	latest, err := c.GetLatestBlock()
+	if err != nil || latest.Height < height {
+		return stored
+	}
+	return latest.Height - height + 1
+}
+
+func (c *Chain) getBlock(url string) (Block, error) {
+	block := Block{}
+	if c.cache == nil {
+		return block, c.httpGetJSON(url, &block)
+	}
+	hit, err := c.cachedGetConfirmed(url, &block, func() int64 {
+		return block.Confirmations
+	})
+	if hit {
+		block.Confirmations = c.currentConfirmations(block.Height,
+			block.Confirmations)
+	}
+	return block, err
+}
+
+func (c *Chain) getTransaction(url string) (Transaction, error) {
+	tx := Transaction{}
+	if c.cache == nil {
+		return tx, c.httpGetJSON(url, &tx)
+	}
+	hit, err := c.cachedGetConfirmed(url, &tx, func() int64 {
+		return tx.Confirmations
+	})
+	if hit {
+		tx.Confirmations = c.currentConfirmations(tx.BlockHeight,
+			tx.Confirmations)
+	}
+	return tx, err
+}
+
+// cacheTransaction stores tx, fetched other than by GetTransaction, as
+// GetTransaction would have.
+func (c *Chain) cacheTransaction(tx Transaction) {
+	if c.cache == nil {
+		return
+	}
+	raw, err := json.Marshal(tx)
+	if err != nil {
+		return
+	}
+	if tx.Confirmations >= c.cachePolicy.Confirmations {
+		c.cache.Set(c.transactionURL(tx.Hash), raw, 0)
+	} else {
+		c.cache.Set(c.transactionURL(tx.Hash), raw, c.cachePolicy.TTL)
+	}
+}
+
+// LRUCache is an in-memory Cache that holds a bounded number of entries,
+// evicting the least recently used when full.
+type LRUCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRUCache creates an LRUCache holding up to maxEntries entries.
+func NewLRUCache(maxEntries int) *LRUCache {
+	return &LRUCache{
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+// Get implements Cache.
+func (lc *LRUCache) Get(key string) ([]byte, bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	el, ok := lc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*lruEntry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		lc.order.Remove(el)
+		delete(lc.entries, key)
+		return nil, false
+	}
+	lc.order.MoveToFront(el)
+	return e.value, true
+}
+
+// Set implements Cache.
+func (lc *LRUCache) Set(key string, value []byte, ttl time.Duration) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	e := &lruEntry{key: key, value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+
+	if el, ok := lc.entries[key]; ok {
+		el.Value = e
+		lc.order.MoveToFront(el)
+		return
+	}
+	lc.entries[key] = lc.order.PushFront(e)
+	for lc.maxEntries > 0 && lc.order.Len() > lc.maxEntries {
+		oldest := lc.order.Back()
+		lc.order.Remove(oldest)
+		delete(lc.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// Len returns the number of entries in the cache.
+func (lc *LRUCache) Len() int {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.order.Len()
+}
+
+// FileCache is a Cache that stores each entry in its own file in a
+// directory, so immutable chain data survives restarts. Entries are written
+// atomically.
+type FileCache struct {
+	Dir string
+}
+
+// NewFileCache creates a FileCache in dir, creating the directory if needed.
+func NewFileCache(dir string) (*FileCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileCache{dir}, nil
+}
+
+func (fc *FileCache) path(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return filepath.Join(fc.Dir, hex.EncodeToString(h[:]))
+}
+
+// Get implements Cache. Each file starts with the expiry time in Unix
+// nanoseconds as a big endian int64, zero for no expiry.
+func (fc *FileCache) Get(key string) ([]byte, bool) {
+	data, err := ioutil.ReadFile(fc.path(key))
+	if err != nil || len(data) < 8 {
+		return nil, false
+	}
+	expires := int64(binary.BigEndian.Uint64(data))
+	if expires != 0 && time.Now().UnixNano() > expires {
+		os.Remove(fc.path(key))
+		return nil, false
+	}
+	return data[8:], true
+}
+
+// Set implements Cache. Write errors are ignored as the entry can always be
+// fetched again.
+func (fc *FileCache) Set(key string, value []byte, ttl time.Duration) {
+	data := make([]byte, 8, 8+len(value))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(data, uint64(time.Now().Add(ttl).UnixNano()))
+	}
+	data = append(data, value...)
+
+	path := fc.path(key)
+	f, err := ioutil.TempFile(fc.Dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+	}
+}
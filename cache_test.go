@@ -0,0 +1,146 @@
+package chain_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/qedus/chain"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := chain.NewLRUCache(2)
+	c.Set("a", []byte("1"), 0)
+	c.Set("b", []byte("2"), 0)
+	if v, ok := c.Get("a"); !ok || string(v) != "1" {
+		t.Fatal("expected a cached")
+	}
+	c.Set("c", []byte("3"), 0)
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expected least recently used entry evicted")
+	}
+	if c.Len() != 2 {
+		t.Fatal("unexpected length", c.Len())
+	}
+
+	c.Set("d", []byte("4"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.Get("d"); ok {
+		t.Fatal("expected expired entry")
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := chain.NewFileCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set("https://api.chain.com/v2/bitcoin/blocks/1", []byte("block"), 0)
+	c.Set("expiring", []byte("x"), time.Millisecond)
+
+	reopened, err := chain.NewFileCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := reopened.Get("https://api.chain.com/v2/bitcoin/blocks/1"); !ok ||
+		string(v) != "block" {
+		t.Fatal("expected entry to persist")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := reopened.Get("expiring"); ok {
+		t.Fatal("expected expired entry")
+	}
+	if _, ok := reopened.Get("missing"); ok {
+		t.Fatal("expected miss")
+	}
+}
+
+func TestChainCache(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 10)
+	deep := payment("deep", otherAddress, testAddress, 1000)
+	api.mine(deep)
+	api.extend(6)
+	pending := payment("pending", otherAddress, testAddress, 2000)
+	api.addTransaction(pending)
+
+	c := newTestChain(t, chain.MainNet, api)
+	c.SetCache(chain.NewLRUCache(100), chain.CachePolicy{
+		TTL: 20 * time.Millisecond,
+	})
+
+	for i := 0; i < 3; i++ {
+		tx, err := c.GetTransaction(deep.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx.Confirmations != 7 {
+			t.Fatal("unexpected confirmations", tx.Confirmations)
+		}
+		if _, err := c.GetTransaction(pending.Hash); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.GetAddress(testAddress); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := api.requestCount("/v2/bitcoin/transactions/" + deep.Hash); n != 1 {
+		t.Fatal("expected one request for deep transaction", n)
+	}
+	if n := api.requestCount("/v2/bitcoin/transactions/" + pending.Hash); n != 1 {
+		t.Fatal("expected one request for pending transaction", n)
+	}
+	if n := api.requestCount("/v2/bitcoin/addresses/" + testAddress); n != 1 {
+		t.Fatal("expected one request for address", n)
+	}
+
+	// Once the mutable entries expire confirmations are recomputed from the
+	// new tip without refetching the deep transaction.
+	api.extend(3)
+	time.Sleep(30 * time.Millisecond)
+	tx, err := c.GetTransaction(deep.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Confirmations != 10 {
+		t.Fatal("expected recomputed confirmations", tx.Confirmations)
+	}
+	if _, err := c.GetTransaction(pending.Hash); err != nil {
+		t.Fatal(err)
+	}
+	if n := api.requestCount("/v2/bitcoin/transactions/" + deep.Hash); n != 1 {
+		t.Fatal("expected deep transaction served from cache", n)
+	}
+	if n := api.requestCount("/v2/bitcoin/transactions/" + pending.Hash); n != 2 {
+		t.Fatal("expected pending transaction refetched", n)
+	}
+
+	block, err := c.GetBlockByHash(tx.BlockHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, err = c.GetBlockByHash(tx.BlockHash); err != nil {
+		t.Fatal(err)
+	}
+	if n := api.requestCount("/v2/bitcoin/blocks/" + tx.BlockHash); n != 1 {
+		t.Fatal("expected one request for block", n)
+	}
+	if block.Confirmations != 10 {
+		t.Fatal("unexpected block confirmations", block.Confirmations)
+	}
+
+	// Blocks by height are only cached for the TTL as a reorg replaces
+	// them, however deep.
+	height := uint64(block.Height)
+	if _, err := c.GetBlockByHeight(height); err != nil {
+		t.Fatal(err)
+	}
+	api.reorg(10, 10)
+	time.Sleep(30 * time.Millisecond)
+	byHeight, err := c.GetBlockByHeight(height)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byHeight.Hash == block.Hash {
+		t.Fatal("expected the reorganized block", byHeight.Hash)
+	}
+}
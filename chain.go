@@ -1,11 +1,14 @@
 package chain
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
 )
 
 const baseURL = "https://api.chain.com/v2"
@@ -29,8 +32,24 @@ type Chain struct {
 	client  *http.Client
 	network Network
 
+	// backend is nil when the Chain.com API is used directly.
+	backend Backend
+
 	apiKeyID     string
 	apiKeySecret string
+
+	cache       Cache
+	cachePolicy CachePolicy
+
+	ctx     context.Context
+	flights *flightGroup
+
+	// sendPolicy is nil when SendTransaction does not check transactions.
+	sendPolicy *SendPolicy
+
+	// noBatch is set to 1, and shared by copies of the Chain, once the API
+	// is found not to support batched transaction requests.
+	noBatch *int32
 }
 
 // MultiError is returned by *Multi functions when there are errors with
@@ -61,7 +80,37 @@ func (m MultiError) Error() string {
 
 // New creates a new chain object.
 func New(c *http.Client, n Network, apiKeyID, apiKeySecret string) *Chain {
-	return &Chain{c, n, apiKeyID, apiKeySecret}
+	return &Chain{
+		client:       c,
+		network:      n,
+		apiKeyID:     apiKeyID,
+		apiKeySecret: apiKeySecret,
+		flights:      newFlightGroup(),
+		noBatch:      new(int32),
+	}
+}
+
+// APIError is returned when the Chain.com API responds with an error status.
+type APIError struct {
+	URL        string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s", e.URL, e.Message)
+}
+
+// IsNotFound reports whether err is an APIError with a 404 status or an
+// RPCError for an unknown block or transaction.
+func IsNotFound(err error) bool {
+	switch err := err.(type) {
+	case *APIError:
+		return err.StatusCode == http.StatusNotFound
+	case *RPCError:
+		return err.Code == rpcInvalidAddressOrKey
+	}
+	return false
 }
 
 func checkHTTPResponse(r *http.Response) error {
@@ -76,30 +125,73 @@ func checkHTTPResponse(r *http.Response) error {
 		return err
 	}
 
+	apiErr := &APIError{URL: r.Request.URL.String(), StatusCode: r.StatusCode}
 	jsonError := struct {
 		Message string
 		Error   string
 	}{}
 	if err := json.Unmarshal(errData, &jsonError); err == nil {
-		message := string(errData)
+		apiErr.Message = string(errData)
 		if jsonError.Message != "" {
-			message = jsonError.Message
+			apiErr.Message = jsonError.Message
 		} else if jsonError.Error != "" {
-			message = jsonError.Error
+			apiErr.Message = jsonError.Error
 		}
-		return fmt.Errorf("%s %s", r.Request.URL, message)
+		return apiErr
 	}
 
-	return fmt.Errorf("%s %s %s", r.Request.URL, r.Status, errData)
+	apiErr.Message = fmt.Sprintf("%s %s", r.Status, errData)
+	return apiErr
 }
 
 func (c *Chain) httpGetJSON(url string, v interface{}) error {
+	_, err := c.httpGetJSONPage(url, v)
+	return err
+}
+
+// httpGetJSONPage is like httpGetJSON for paginated endpoints. It returns
+// the URL of the next page from the response Link header or an empty string
+// if this is the last page.
+//
+// Identical GET requests made concurrently are coalesced into one HTTP
+// request whose response is decoded separately for each caller.
+func (c *Chain) httpGetJSONPage(url string, v interface{}) (string, error) {
+	body, header, err := c.flights.do(c.context(), url,
+		func(ctx context.Context) ([]byte, http.Header, error) {
+			return c.httpGet(ctx, url)
+		})
+	if err != nil {
+		return "", err
+	}
+	if err := decodeJSON(bytes.NewReader(body), v); err != nil {
+		return "", err
+	}
+	return nextPageURL(header), nil
+}
 
+// httpGet fetches url and returns the raw response body.
+func (c *Chain) httpGet(ctx context.Context, url string) ([]byte,
+	http.Header, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	if c.backend != nil {
+		return nil, nil, ErrNotSupported
 	}
-	return c.doRequest(req, v)
+	req.SetBasicAuth(c.apiKeyID, c.apiKeySecret)
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := checkHTTPResponse(resp); err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return body, resp.Header, err
 }
 
 func (c *Chain) httpDeleteJSON(url string, v interface{}) error {
@@ -108,22 +200,49 @@ func (c *Chain) httpDeleteJSON(url string, v interface{}) error {
 	if err != nil {
 		return err
 	}
-	return c.doRequest(req, v)
+	_, err = c.doRequest(req, v)
+	return err
 }
 
-func (c *Chain) doRequest(req *http.Request, v interface{}) error {
+func (c *Chain) doRequest(req *http.Request, v interface{}) (http.Header,
+	error) {
+	if c.backend != nil {
+		return nil, ErrNotSupported
+	}
 	req.SetBasicAuth(c.apiKeyID, c.apiKeySecret)
-	resp, err := c.client.Do(req)
+	resp, err := c.client.Do(req.WithContext(c.context()))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := checkHTTPResponse(resp); err != nil {
-		return err
+		return nil, err
 	}
 
 	defer resp.Body.Close()
-	return decodeJSON(resp.Body, v)
+	return resp.Header, decodeJSON(resp.Body, v)
+}
+
+// nextPageURL returns the target of the rel="next" link in an RFC 5988 Link
+// header.
+func nextPageURL(h http.Header) string {
+	for _, value := range h["Link"] {
+		for _, link := range strings.Split(value, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") ||
+				!strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range parts[1:] {
+				param = strings.Replace(strings.TrimSpace(param), " ", "", -1)
+				if param == `rel="next"` || param == "rel=next" {
+					return target[1 : len(target)-1]
+				}
+			}
+		}
+	}
+	return ""
 }
 
 func (c *Chain) httpPostJSON(url string,
@@ -144,9 +263,12 @@ func (c *Chain) httpPutJSON(url string, body io.Reader) (io.ReadCloser, error) {
 }
 
 func (c *Chain) doRequestWithBody(req *http.Request) (io.ReadCloser, error) {
+	if c.backend != nil {
+		return nil, ErrNotSupported
+	}
 	req.Header.Add("Content-Type", "application/json")
 	req.SetBasicAuth(c.apiKeyID, c.apiKeySecret)
-	resp, err := c.client.Do(req)
+	resp, err := c.client.Do(req.WithContext(c.context()))
 	if err != nil {
 		return nil, err
 	}
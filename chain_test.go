@@ -1,9 +1,18 @@
 package chain_test
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/qedus/chain"
@@ -22,3 +31,457 @@ func newChain(t *testing.T, net chain.Network) *chain.Chain {
 
 	return chain.New(http.DefaultClient, net, apiKeyID, apiKeySecret)
 }
+
+// redirectTransport sends every request to a test server regardless of the
+// request URL host.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response,
+	error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newTestChain returns a Chain whose Chain.com API requests are served by h
+// so tests can run without API keys or network access.
+func newTestChain(t *testing.T, net chain.Network,
+	h http.Handler) *chain.Chain {
+	s := httptest.NewServer(h)
+	t.Cleanup(s.Close)
+
+	target, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: redirectTransport{target}}
+	return chain.New(client, net, "test-key-id", "test-key-secret")
+}
+
+// fakeAPI is an in-memory stand in for the Chain.com block endpoints. The
+// best chain starts at height 0 and can be extended or reorganized.
+type fakeAPI struct {
+	mu     sync.Mutex
+	t      *testing.T
+	net    chain.Network
+	blocks map[string]chain.Block
+	best   []chain.Block
+	forks  int
+	txns   map[string]chain.Transaction
+	order  []string
+
+	notifications []*chain.NotificationResponse
+	nextID        int
+
+	// sent holds the hex of each transaction sent.
+	sent []string
+
+	// requests counts requests by path.
+	requests map[string]int
+
+	// noBatch makes the transaction endpoint treat comma joined hashes as
+	// one unknown hash, like an API without batch support.
+	noBatch bool
+}
+
+func newFakeAPI(t *testing.T, net chain.Network, height int) *fakeAPI {
+	api := &fakeAPI{
+		t:      t,
+		net:    net,
+		blocks: map[string]chain.Block{},
+		txns:   map[string]chain.Transaction{},
+
+		requests: map[string]int{},
+	}
+	api.extend(height + 1)
+	return api
+}
+
+func fakeHash(parts ...interface{}) string {
+	h := sha256.Sum256([]byte(fmt.Sprint(parts...)))
+	return hex.EncodeToString(h[:])
+}
+
+// extend adds n blocks to the best chain.
+func (api *fakeAPI) extend(n int) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	for i := 0; i < n; i++ {
+		b := chain.Block{Height: int64(len(api.best))}
+		if len(api.best) > 0 {
+			b.PreviousHash = api.best[len(api.best)-1].Hash
+		}
+		b.Hash = fakeHash("block", b.Height, api.forks)
+		api.blocks[b.Hash] = b
+		api.best = append(api.best, b)
+	}
+}
+
+// reorg removes depth blocks from the best chain and replaces them with n new
+// blocks.
+func (api *fakeAPI) reorg(depth, n int) {
+	api.mu.Lock()
+	api.best = api.best[:len(api.best)-depth]
+	api.forks++
+	api.mu.Unlock()
+	api.extend(n)
+}
+
+func (api *fakeAPI) tip() chain.Block {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.best[len(api.best)-1]
+}
+
+// addTransaction makes tx available from the transaction endpoint.
+func (api *fakeAPI) addTransaction(tx chain.Transaction) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if _, ok := api.txns[tx.Hash]; !ok {
+		api.order = append(api.order, tx.Hash)
+	}
+	api.txns[tx.Hash] = tx
+}
+
+// addressTransactions returns the transactions involving any of addresses,
+// newest first.
+func (api *fakeAPI) addressTransactions(
+	addresses []string) []chain.Transaction {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	wanted := map[string]bool{}
+	for _, a := range addresses {
+		wanted[a] = true
+	}
+
+	txns := []chain.Transaction{}
+	for i := len(api.order) - 1; i >= 0; i-- {
+		tx := api.confirm(api.txns[api.order[i]])
+		involved := false
+		for _, in := range tx.Inputs {
+			for _, a := range in.Addresses {
+				involved = involved || wanted[a]
+			}
+		}
+		for _, out := range tx.Outputs {
+			for _, a := range out.Addresses {
+				involved = involved || wanted[a]
+			}
+		}
+		if involved {
+			txns = append(txns, tx)
+		}
+	}
+	return txns
+}
+
+// addressBalance computes the balance of address from the known
+// transactions.
+func (api *fakeAPI) addressBalance(address string) chain.Address {
+	a := chain.Address{Address: address}
+	for _, tx := range api.addressTransactions([]string{address}) {
+		for _, in := range tx.Inputs {
+			if len(in.Addresses) == 1 && in.Addresses[0] == address {
+				a.Total.Sent += in.Value
+				if tx.Confirmations > 0 {
+					a.Confirmed.Sent += in.Value
+				}
+			}
+		}
+		for _, out := range tx.Outputs {
+			if len(out.Addresses) == 1 && out.Addresses[0] == address {
+				a.Total.Received += out.Value
+				if tx.Confirmations > 0 {
+					a.Confirmed.Received += out.Value
+				}
+			}
+		}
+	}
+	a.Total.Balance = a.Total.Received - a.Total.Sent
+	a.Confirmed.Balance = a.Confirmed.Received - a.Confirmed.Sent
+	return a
+}
+
+// addressUnspents returns the outputs paying to addresses that are not spent
+// by a known transaction.
+func (api *fakeAPI) addressUnspents(addresses []string) []chain.Output {
+	txns := api.addressTransactions(addresses)
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	spent := map[string]bool{}
+	for _, tx := range api.txns {
+		for _, in := range tx.Inputs {
+			spent[fmt.Sprint(in.OutputHash, in.OutputIndex)] = true
+		}
+	}
+	wanted := map[string]bool{}
+	for _, a := range addresses {
+		wanted[a] = true
+	}
+
+	outputs := []chain.Output{}
+	for _, tx := range txns {
+		for i, out := range tx.Outputs {
+			if len(out.Addresses) != 1 || !wanted[out.Addresses[0]] ||
+				spent[fmt.Sprint(tx.Hash, uint32(i))] {
+				continue
+			}
+			out.TransactionHash, out.OutputIndex = tx.Hash, uint32(i)
+			out.Confirmations = tx.Confirmations
+			outputs = append(outputs, out)
+		}
+	}
+	return outputs
+}
+
+func (api *fakeAPI) transaction(hash string) (chain.Transaction, bool) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	tx, ok := api.txns[hash]
+	return api.confirm(tx), ok
+}
+
+// confirm sets the confirmations of tx from the best chain. It must be
+// called with api.mu held.
+func (api *fakeAPI) confirm(tx chain.Transaction) chain.Transaction {
+	if tx.BlockHash == "" {
+		return tx
+	}
+	tx.Confirmations = 0
+	if b, ok := api.blocks[tx.BlockHash]; ok &&
+		int(b.Height) < len(api.best) && api.best[b.Height].Hash == b.Hash {
+		tx.Confirmations = int64(len(api.best)) - b.Height
+	}
+	return tx
+}
+
+// mine adds a block containing txns to the best chain.
+func (api *fakeAPI) mine(txns ...chain.Transaction) chain.Block {
+	api.extend(1)
+	api.mu.Lock()
+	b := &api.best[len(api.best)-1]
+	for _, tx := range txns {
+		b.TransactionHashes = append(b.TransactionHashes, tx.Hash)
+	}
+	api.blocks[b.Hash] = *b
+	block := *b
+	api.mu.Unlock()
+
+	for _, tx := range txns {
+		tx.BlockHash, tx.BlockHeight, tx.BlockTime =
+			block.Hash, block.Height, block.Time
+		api.addTransaction(tx)
+	}
+	return block
+}
+
+func (api *fakeAPI) block(id string) (b chain.Block, ok bool) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if id == "latest" {
+		b = api.best[len(api.best)-1]
+	} else if height, err := strconv.Atoi(id); err == nil && len(id) < 64 {
+		if height < 0 || height >= len(api.best) {
+			return chain.Block{}, false
+		}
+		b = api.best[height]
+	} else if b, ok = api.blocks[id]; !ok {
+		return chain.Block{}, false
+	}
+
+	if int(b.Height) < len(api.best) && api.best[b.Height].Hash == b.Hash {
+		b.Confirmations = int64(len(api.best)) - b.Height
+	}
+	return b, true
+}
+
+func (api *fakeAPI) serveNotifications(w http.ResponseWriter,
+	r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path,
+		"/v2/notifications"), "/")
+	switch {
+	case r.Method == "POST" && id == "":
+		n := &chain.NotificationResponse{}
+		if err := json.NewDecoder(r.Body).Decode(n); err != nil {
+			writeTestJSON(w, http.StatusBadRequest,
+				map[string]string{"message": err.Error()})
+			return
+		}
+		api.nextID++
+		n.ID, n.State = fmt.Sprintf("nt-%d", api.nextID), "enabled"
+		api.notifications = append(api.notifications, n)
+		writeTestJSON(w, http.StatusCreated, n)
+	case r.Method == "GET" && id == "":
+		// Pages hold two notifications so tests exercise pagination.
+		start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+		end := start + 2
+		if end < len(api.notifications) {
+			w.Header().Set("Link", fmt.Sprintf(
+				`<https://api.chain.com/v2/notifications?start=%d>; rel="next"`,
+				end))
+		} else {
+			end = len(api.notifications)
+		}
+		writeTestJSON(w, http.StatusOK, api.notifications[start:end])
+	case r.Method == "GET" || r.Method == "PUT":
+		for _, n := range api.notifications {
+			if n.ID != id {
+				continue
+			}
+			if r.Method == "PUT" {
+				update := chain.NotificationUpdate{}
+				json.NewDecoder(r.Body).Decode(&update)
+				if update.URL != "" {
+					n.URL = update.URL
+				}
+				if update.State != "" {
+					n.State = update.State
+				}
+			}
+			writeTestJSON(w, http.StatusOK, n)
+			return
+		}
+		writeTestJSON(w, http.StatusNotFound,
+			map[string]string{"message": "notification not found"})
+	case r.Method == "DELETE":
+		for i, n := range api.notifications {
+			if n.ID == id {
+				api.notifications = append(api.notifications[:i],
+					api.notifications[i+1:]...)
+				writeTestJSON(w, http.StatusOK, n)
+				return
+			}
+		}
+		writeTestJSON(w, http.StatusNotFound,
+			map[string]string{"message": "notification not found"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// requestCount returns the number of requests made for path.
+func (api *fakeAPI) requestCount(path string) int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.requests[path]
+}
+
+func (api *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api.mu.Lock()
+	api.requests[r.URL.Path]++
+	api.mu.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/v2/notifications") {
+		api.serveNotifications(w, r)
+		return
+	}
+
+	prefix := "/v2/" + string(api.net) + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, prefix), "/")
+
+	switch {
+	case len(parts) == 2 && parts[0] == "blocks":
+		b, ok := api.block(parts[1])
+		if !ok {
+			writeTestJSON(w, http.StatusNotFound,
+				map[string]string{"message": "block not found"})
+			return
+		}
+		writeTestJSON(w, http.StatusOK, b)
+	case len(parts) == 2 && parts[0] == "transactions" &&
+		strings.Contains(parts[1], ",") && !api.noBatch:
+		// Batches fail as a whole if any transaction is unknown.
+		txns := []chain.Transaction{}
+		for _, hash := range strings.Split(parts[1], ",") {
+			tx, ok := api.transaction(hash)
+			if !ok {
+				writeTestJSON(w, http.StatusNotFound,
+					map[string]string{"message": "transaction not found"})
+				return
+			}
+			txns = append(txns, tx)
+		}
+		writeTestJSON(w, http.StatusOK, txns)
+	case len(parts) == 2 && parts[0] == "transactions":
+		tx, ok := api.transaction(parts[1])
+		if !ok {
+			writeTestJSON(w, http.StatusNotFound,
+				map[string]string{"message": "transaction not found"})
+			return
+		}
+		writeTestJSON(w, http.StatusOK, tx)
+	case len(parts) == 1 && parts[0] == "transactions" && r.Method == "PUT":
+		api.sendTransaction(w, r)
+	case len(parts) >= 2 && parts[0] == "addresses":
+		addresses := strings.Split(parts[1], ",")
+		switch {
+		case len(parts) == 2:
+			balances := []chain.Address{}
+			for _, a := range addresses {
+				balances = append(balances, api.addressBalance(a))
+			}
+			writeTestJSON(w, http.StatusOK, balances)
+		case len(parts) == 3 && parts[2] == "transactions":
+			txns := api.addressTransactions(addresses)
+			start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+			if start > len(txns) {
+				start = len(txns)
+			}
+			txns = txns[start:]
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			if limit > 0 && limit < len(txns) {
+				txns = txns[:limit]
+				w.Header().Set("Link", fmt.Sprintf(
+					`<https://api.chain.com%s?start=%d&limit=%d>; rel="next"`,
+					r.URL.Path, start+limit, limit))
+			}
+			writeTestJSON(w, http.StatusOK, txns)
+		case len(parts) == 3 && parts[2] == "unspents":
+			writeTestJSON(w, http.StatusOK, api.addressUnspents(addresses))
+		default:
+			http.NotFound(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// sendTransaction records a sent transaction, makes it available from the
+// transaction endpoint and returns its hash.
+func (api *fakeAPI) sendTransaction(w http.ResponseWriter, r *http.Request) {
+	body := struct{ Hex string }{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeTestJSON(w, http.StatusBadRequest,
+			map[string]string{"message": err.Error()})
+		return
+	}
+	tx, err := chain.DecodeRawTransaction(body.Hex)
+	if err != nil {
+		writeTestJSON(w, http.StatusBadRequest,
+			map[string]string{"message": "TX decode failed"})
+		return
+	}
+	hash, _ := tx.Hash()
+	api.mu.Lock()
+	api.sent = append(api.sent, body.Hex)
+	api.mu.Unlock()
+	api.addTransaction(chain.Transaction{Hash: hash})
+	writeTestJSON(w, http.StatusOK, map[string]string{"transaction_hash": hash})
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,470 @@
+// Command chain queries the Chain.com API from the command line.
+//
+// Usage:
+//
+//	chain [flags] <command> [arguments]
+//
+// The commands are:
+//
+//	block [hash|height|latest]   show a block, the latest by default
+//	tx <hash>...                 show transactions
+//	address <address>...         show address balances
+//	unspents <address>...        show unspent outputs of addresses
+//	history [-limit n] <address>...
+//	                             show recent transactions of addresses
+//	send [hex]                   send a signed transaction read from the
+//	                             argument or standard input
+//	notify create -type t -url u [-address a] [-tx hash] [-confirmations n]
+//	notify list [-all]
+//	notify delete <id>...
+//
+// Credentials are read from the CHAIN_API_KEY_ID and CHAIN_API_KEY_SECRET
+// environment variables, falling back to a JSON config file, by default
+// ~/.chain.json, of the form:
+//
+//	{"api_key_id": "...", "api_key_secret": "...", "network": "bitcoin"}
+//
+// The network can also be set with CHAIN_NETWORK or -network. Output is a
+// table by default; -format json prints indented JSON and -format jsonl
+// prints one JSON value per line.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/qedus/chain"
+)
+
+// httpClient is used for all API requests. Tests replace it.
+var httpClient = http.DefaultClient
+
+// errUsage is returned for invalid command lines. The usage has already been
+// printed.
+var errUsage = errors.New("usage")
+
+const usage = `usage: chain [flags] <command> [arguments]
+
+commands:
+  block [hash|height|latest]
+  tx <hash>...
+  address <address>...
+  unspents <address>...
+  history [-limit n] <address>...
+  send [hex]
+  notify create -type type -url url [-address a] [-tx hash] [-confirmations n]
+  notify list [-all]
+  notify delete <id>...
+
+flags:
+`
+
+// config holds the credentials and network to use.
+type config struct {
+	APIKeyID     string        `json:"api_key_id"`
+	APIKeySecret string        `json:"api_key_secret"`
+	Network      chain.Network `json:"network"`
+}
+
+// loadConfig reads path, if it exists, and overrides it with the
+// environment.
+func loadConfig(path string, getenv func(string) string) (config, error) {
+	cfg := config{}
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return cfg, err
+	default:
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	if v := getenv("CHAIN_API_KEY_ID"); v != "" {
+		cfg.APIKeyID = v
+	}
+	if v := getenv("CHAIN_API_KEY_SECRET"); v != "" {
+		cfg.APIKeySecret = v
+	}
+	if v := getenv("CHAIN_NETWORK"); v != "" {
+		cfg.Network = chain.Network(v)
+	}
+	return cfg, nil
+}
+
+func defaultConfigPath(getenv func(string) string) string {
+	if path := getenv("CHAIN_CONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(getenv("HOME"), ".chain.json")
+}
+
+func main() {
+	err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.Getenv)
+	switch {
+	case err == errUsage:
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "chain:", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command line args.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer,
+	getenv func(string) string) error {
+	flags := flag.NewFlagSet("chain", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	configPath := flags.String("config", defaultConfigPath(getenv),
+		"config file")
+	network := flags.String("network", "",
+		`network, "bitcoin" or "testnet3" (default from config or bitcoin)`)
+	format := flags.String("format", "table",
+		`output format, "table", "json" or "jsonl"`)
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errUsage
+	}
+	out, err := newPrinter(stdout, *format)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(*configPath, getenv)
+	if err != nil {
+		return err
+	}
+	if *network != "" {
+		cfg.Network = chain.Network(*network)
+	}
+	switch cfg.Network {
+	case "":
+		cfg.Network = chain.MainNet
+	case chain.MainNet, chain.TestNet3:
+	default:
+		return fmt.Errorf("unknown network %q", cfg.Network)
+	}
+	if cfg.APIKeyID == "" || cfg.APIKeySecret == "" {
+		return errors.New("API key not set; set CHAIN_API_KEY_ID and " +
+			"CHAIN_API_KEY_SECRET or add them to " + *configPath)
+	}
+
+	cmd := &command{
+		chain:  chain.New(httpClient, cfg.Network, cfg.APIKeyID, cfg.APIKeySecret),
+		out:    out,
+		stdin:  stdin,
+		stderr: stderr,
+	}
+	return cmd.run(flags.Arg(0), flags.Args()[1:])
+}
+
+// command runs a subcommand against chain, printing results to out.
+type command struct {
+	chain  *chain.Chain
+	out    *printer
+	stdin  io.Reader
+	stderr io.Writer
+}
+
+func (cmd *command) usageError(format string, args ...interface{}) error {
+	fmt.Fprintf(cmd.stderr, "usage: chain "+format+"\n", args...)
+	return errUsage
+}
+
+func (cmd *command) run(name string, args []string) error {
+	switch name {
+	case "block":
+		return cmd.block(args)
+	case "tx":
+		return cmd.tx(args)
+	case "address":
+		return cmd.address(args)
+	case "unspents":
+		return cmd.unspents(args)
+	case "history":
+		return cmd.history(args)
+	case "send":
+		return cmd.send(args)
+	case "notify":
+		return cmd.notify(args)
+	}
+	return cmd.usageError("<command>; unknown command %q", name)
+}
+
+func (cmd *command) block(args []string) error {
+	if len(args) > 1 {
+		return cmd.usageError("block [hash|height|latest]")
+	}
+	id := "latest"
+	if len(args) == 1 {
+		id = args[0]
+	}
+
+	var block chain.Block
+	var err error
+	if height, parseErr := strconv.ParseUint(id, 10, 64); id == "latest" {
+		block, err = cmd.chain.GetLatestBlock()
+	} else if parseErr == nil {
+		block, err = cmd.chain.GetBlockByHeight(height)
+	} else {
+		block, err = cmd.chain.GetBlockByHash(id)
+	}
+	if err != nil {
+		return err
+	}
+	return cmd.out.print(block, []string{"HASH", "HEIGHT", "TIME",
+		"TRANSACTIONS", "CONFIRMATIONS"}, [][]string{{
+		block.Hash, fmt.Sprint(block.Height), formatTime(block.Time),
+		fmt.Sprint(len(block.TransactionHashes)),
+		fmt.Sprint(block.Confirmations)}})
+}
+
+func (cmd *command) tx(args []string) error {
+	if len(args) == 0 {
+		return cmd.usageError("tx <hash>...")
+	}
+	txns, err := cmd.chain.GetTransactionMulti(args)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, tx := range txns {
+		rows = append(rows, []string{tx.Hash, fmt.Sprint(tx.BlockHeight),
+			fmt.Sprint(tx.Confirmations), fmt.Sprint(len(tx.Inputs)),
+			fmt.Sprint(len(tx.Outputs)), tx.Amount.String(),
+			tx.Fees.String()})
+	}
+	return cmd.out.print(txns, []string{"HASH", "HEIGHT", "CONFIRMATIONS",
+		"INPUTS", "OUTPUTS", "AMOUNT", "FEES"}, rows)
+}
+
+// checkAddresses checks the number of address arguments.
+func (cmd *command) checkAddresses(name string, addresses []string) error {
+	if len(addresses) == 0 {
+		return cmd.usageError("%s <address>...", name)
+	}
+	if len(addresses) > chain.MaxAddresses {
+		return fmt.Errorf("at most %d addresses are allowed", chain.MaxAddresses)
+	}
+	return nil
+}
+
+func (cmd *command) address(args []string) error {
+	if err := cmd.checkAddresses("address", args); err != nil {
+		return err
+	}
+	addresses, err := cmd.chain.GetAddressMulti(args)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, a := range addresses {
+		rows = append(rows, []string{a.Address, a.Total.Balance.String(),
+			a.Confirmed.Balance.String(), a.Total.Received.String(),
+			a.Total.Sent.String()})
+	}
+	return cmd.out.print(addresses, []string{"ADDRESS", "BALANCE",
+		"CONFIRMED", "RECEIVED", "SENT"}, rows)
+}
+
+func (cmd *command) unspents(args []string) error {
+	if err := cmd.checkAddresses("unspents", args); err != nil {
+		return err
+	}
+	outputs, err := cmd.chain.GetAddressUnspentOutputsMulti(args)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, out := range outputs {
+		rows = append(rows, []string{out.TransactionHash,
+			fmt.Sprint(out.OutputIndex), strings.Join(out.Addresses, ","),
+			out.Value.String(), fmt.Sprint(out.Confirmations)})
+	}
+	return cmd.out.print(outputs, []string{"TRANSACTION", "INDEX", "ADDRESSES",
+		"VALUE", "CONFIRMATIONS"}, rows)
+}
+
+func (cmd *command) history(args []string) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	flags.SetOutput(cmd.stderr)
+	limit := flags.Int("limit", chain.DefaultAddressTransactionsLimit,
+		"number of transactions")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if err := cmd.checkAddresses("history [-limit n]", flags.Args()); err != nil {
+		return err
+	}
+
+	txns, err := cmd.chain.GetAddressTransactionsMulti(flags.Args(), *limit)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, tx := range txns {
+		rows = append(rows, []string{tx.Hash, fmt.Sprint(tx.BlockHeight),
+			formatTime(tx.BlockTime), fmt.Sprint(tx.Confirmations),
+			chain.NetAmount(tx, flags.Args()).String()})
+	}
+	return cmd.out.print(txns, []string{"HASH", "HEIGHT", "TIME",
+		"CONFIRMATIONS", "NET"}, rows)
+}
+
+func (cmd *command) send(args []string) error {
+	var hex string
+	switch len(args) {
+	case 0:
+		data, err := ioutil.ReadAll(cmd.stdin)
+		if err != nil {
+			return err
+		}
+		hex = strings.TrimSpace(string(data))
+	case 1:
+		hex = args[0]
+	default:
+		return cmd.usageError("send [hex]")
+	}
+	if hex == "" {
+		return errors.New("no transaction to send")
+	}
+
+	hash, err := cmd.chain.SendTransaction(hex)
+	if err != nil {
+		return err
+	}
+	return cmd.out.print(struct {
+		TransactionHash string `json:"transaction_hash"`
+	}{hash}, []string{"TRANSACTION"}, [][]string{{hash}})
+}
+
+func (cmd *command) notify(args []string) error {
+	if len(args) == 0 {
+		return cmd.usageError("notify create|list|delete")
+	}
+	switch args[0] {
+	case "create":
+		return cmd.notifyCreate(args[1:])
+	case "list":
+		return cmd.notifyList(args[1:])
+	case "delete":
+		return cmd.notifyDelete(args[1:])
+	}
+	return cmd.usageError("notify create|list|delete; unknown command %q",
+		args[0])
+}
+
+func (cmd *command) printNotifications(
+	notifications []*chain.NotificationResponse) error {
+	rows := [][]string{}
+	for _, n := range notifications {
+		target := n.Address
+		if n.Type == chain.TransactionNotification {
+			target = fmt.Sprintf("%s (%d)", n.TransactionHash, n.Confirmations)
+		}
+		rows = append(rows, []string{n.ID, string(n.Type), n.State,
+			n.BlockChain, n.URL, target})
+	}
+	return cmd.out.print(notifications, []string{"ID", "TYPE", "STATE",
+		"NETWORK", "URL", "TARGET"}, rows)
+}
+
+func (cmd *command) notifyCreate(args []string) error {
+	flags := flag.NewFlagSet("notify create", flag.ContinueOnError)
+	flags.SetOutput(cmd.stderr)
+	ty := flags.String("type", "", `"new-transaction", "new-block", `+
+		`"address" or "transaction"`)
+	url := flags.String("url", "", "URL to deliver notifications to")
+	address := flags.String("address", "", "address for address notifications")
+	txHash := flags.String("tx", "",
+		"transaction hash for transaction notifications")
+	confirmations := flags.Int64("confirmations", 1,
+		"confirmations for transaction notifications")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if *url == "" || flags.NArg() != 0 {
+		return cmd.usageError("notify create -type type -url url " +
+			"[-address a] [-tx hash] [-confirmations n]")
+	}
+
+	var n *chain.NotificationResponse
+	var err error
+	switch chain.NotificationType(*ty) {
+	case chain.NewTransactionNotification:
+		n, err = cmd.chain.CreateNewTxNotification(*url)
+	case chain.NewBlockNotification:
+		n, err = cmd.chain.CreateNewBlockNotification(*url)
+	case chain.AddressNotification:
+		if *address == "" {
+			return errors.New("address notifications need -address")
+		}
+		n, err = cmd.chain.CreateAddressNotification(*url, *address)
+	case chain.TransactionNotification:
+		if *txHash == "" {
+			return errors.New("transaction notifications need -tx")
+		}
+		n, err = cmd.chain.CreateTransactionNotification(*url, *txHash,
+			*confirmations)
+	default:
+		return fmt.Errorf("unknown notification type %q", *ty)
+	}
+	if err != nil {
+		return err
+	}
+	return cmd.printNotifications([]*chain.NotificationResponse{n})
+}
+
+func (cmd *command) notifyList(args []string) error {
+	flags := flag.NewFlagSet("notify list", flag.ContinueOnError)
+	flags.SetOutput(cmd.stderr)
+	all := flags.Bool("all", false, "include notifications for all networks")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != 0 {
+		return cmd.usageError("notify list [-all]")
+	}
+
+	list := cmd.chain.ListNotifications
+	if *all {
+		list = cmd.chain.ListAllNotifications
+	}
+	notifications, err := list()
+	if err != nil {
+		return err
+	}
+	return cmd.printNotifications(notifications)
+}
+
+func (cmd *command) notifyDelete(args []string) error {
+	if len(args) == 0 {
+		return cmd.usageError("notify delete <id>...")
+	}
+	deleted := []*chain.NotificationResponse{}
+	for _, id := range args {
+		n, err := cmd.chain.DeleteNotification(id)
+		if err != nil {
+			return err
+		}
+		deleted = append(deleted, n)
+	}
+	return cmd.printNotifications(deleted)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/qedus/chain"
+)
+
+const (
+	testHash    = "0000000000000000000000000000000000000000000000000000000000000001"
+	testAddress = "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"
+)
+
+// redirectTransport sends every request to a test server.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response,
+	error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// fakeAPI answers the requests the commands make with canned responses and
+// records the requests.
+type fakeAPI struct {
+	requests []string
+	bodies   []string
+}
+
+func (api *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if id, _, _ := r.BasicAuth(); id != "key-id" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	api.requests = append(api.requests, r.Method+" "+r.URL.Path)
+	api.bodies = append(api.bodies, string(body))
+
+	tx := chain.Transaction{
+		Hash:          testHash,
+		BlockHeight:   10,
+		Confirmations: 2,
+		Outputs: []chain.Output{
+			{Value: 5000, Addresses: []string{testAddress}},
+		},
+		Amount: 5000,
+	}
+	var v interface{}
+	switch r.Method + " " + r.URL.Path {
+	case "GET /v2/bitcoin/blocks/latest", "GET /v2/bitcoin/blocks/10":
+		v = chain.Block{Hash: testHash, Height: 10, Confirmations: 1}
+	case "GET /v2/bitcoin/transactions/" + testHash:
+		v = tx
+	case "GET /v2/bitcoin/addresses/" + testAddress + "/transactions":
+		v = []chain.Transaction{tx}
+	case "PUT /v2/bitcoin/transactions":
+		v = map[string]string{"transaction_hash": testHash}
+	case "POST /v2/notifications":
+		n := chain.NotificationResponse{}
+		json.Unmarshal(body, &n)
+		n.ID, n.State = "nt-1", "enabled"
+		v = n
+	case "GET /v2/notifications":
+		v = []chain.NotificationResponse{
+			{ID: "nt-1", Type: chain.NewBlockNotification, BlockChain: "bitcoin"},
+			{ID: "nt-2", Type: chain.NewBlockNotification, BlockChain: "testnet3"},
+		}
+	case "DELETE /v2/notifications/nt-1":
+		v = chain.NotificationResponse{ID: "nt-1"}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+func runTest(t *testing.T, stdin string, args ...string) (string, *fakeAPI) {
+	api := &fakeAPI{}
+	s := httptest.NewServer(api)
+	defer s.Close()
+	target, _ := url.Parse(s.URL)
+	httpClient = &http.Client{Transport: redirectTransport{target}}
+	defer func() { httpClient = http.DefaultClient }()
+
+	env := map[string]string{
+		"CHAIN_API_KEY_ID":     "key-id",
+		"CHAIN_API_KEY_SECRET": "key-secret",
+		"HOME":                 t.TempDir(),
+	}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := run(args, strings.NewReader(stdin), stdout, stderr,
+		func(k string) string { return env[k] }); err != nil {
+		t.Fatal(err, stderr.String())
+	}
+	return stdout.String(), api
+}
+
+func TestBlock(t *testing.T) {
+	out, api := runTest(t, "", "block")
+	if !strings.HasPrefix(out, "HASH") || !strings.Contains(out, testHash) {
+		t.Fatal("unexpected output", out)
+	}
+	out, api = runTest(t, "", "-format", "json", "block", "10")
+	block := chain.Block{}
+	if err := json.Unmarshal([]byte(out), &block); err != nil {
+		t.Fatal(err, out)
+	}
+	if block.Height != 10 || api.requests[0] != "GET /v2/bitcoin/blocks/10" {
+		t.Fatal("unexpected block", block, api.requests)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	out, _ := runTest(t, "", "history", "-limit", "5", testAddress)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[1], "0.00005 BTC") {
+		t.Fatal("unexpected output", out)
+	}
+}
+
+func TestSendStdin(t *testing.T) {
+	out, api := runTest(t, "0100abcd\n", "-format", "jsonl", "send")
+	if strings.TrimSpace(out) != `{"transaction_hash":"`+testHash+`"}` {
+		t.Fatal("unexpected output", out)
+	}
+	if !strings.Contains(api.bodies[0], `"0100abcd"`) {
+		t.Fatal("unexpected request body", api.bodies)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	out, api := runTest(t, "", "-format", "jsonl", "notify", "list")
+	if strings.Count(out, "\n") != 1 || !strings.Contains(out, "nt-1") {
+		t.Fatal("expected only mainnet notifications", out)
+	}
+
+	out, api = runTest(t, "", "notify", "create", "-type", "address",
+		"-url", "https://example.com/hook", "-address", testAddress)
+	if !strings.Contains(out, "nt-1") ||
+		!strings.Contains(api.bodies[0], testAddress) {
+		t.Fatal("unexpected create", out, api.bodies)
+	}
+
+	_, api = runTest(t, "", "notify", "delete", "nt-1")
+	if api.requests[0] != "DELETE /v2/notifications/nt-1" {
+		t.Fatal("unexpected requests", api.requests)
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "chain.json")
+	if err := ioutil.WriteFile(path, []byte(`{"api_key_id": "file-id",
+		"api_key_secret": "file-secret", "network": "testnet3"}`),
+		0600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"CHAIN_API_KEY_SECRET": "env-secret"}
+	cfg, err := loadConfig(path, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.APIKeyID != "file-id" || cfg.APIKeySecret != "env-secret" ||
+		cfg.Network != chain.TestNet3 {
+		t.Fatal("unexpected config", cfg)
+	}
+
+	if _, err := loadConfig(filepath.Join(dir, "missing.json"),
+		os.Getenv); err != nil {
+		t.Fatal("missing config file should be ignored", err)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	env := map[string]string{
+		"CHAIN_API_KEY_ID":     "key-id",
+		"CHAIN_API_KEY_SECRET": "key-secret",
+	}
+	getenv := func(k string) string { return env[k] }
+	for _, args := range [][]string{{}, {"unknown"}, {"tx"}, {"notify"}} {
+		err := run(args, strings.NewReader(""), ioutil.Discard, stderr, getenv)
+		if err != errUsage {
+			t.Fatal("expected usage error", args, err)
+		}
+	}
+	if err := run([]string{"-format", "xml", "block"}, strings.NewReader(""),
+		ioutil.Discard, stderr, getenv); err == nil {
+		t.Fatal("expected format error")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats.
+const (
+	formatTable     = "table"
+	formatJSON      = "json"
+	formatJSONLines = "jsonl"
+)
+
+// printer writes command results in one of the output formats.
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case formatTable, formatJSON, formatJSONLines:
+		return &printer{w, format}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// print writes v as JSON or, for the table format, header and rows. In the
+// JSON lines format each element of a slice is written on its own line.
+func (p *printer) print(v interface{}, header []string,
+	rows [][]string) error {
+	switch p.format {
+	case formatJSON:
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.w, "%s\n", data)
+		return err
+	case formatJSONLines:
+		enc := json.NewEncoder(p.w)
+		value := reflect.ValueOf(v)
+		if value.Kind() != reflect.Slice {
+			return enc.Encode(v)
+		}
+		for i := 0; i < value.Len(); i++ {
+			if err := enc.Encode(value.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// formatTime formats t for tables, with a dash for the zero time of
+// unconfirmed transactions.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
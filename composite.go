@@ -0,0 +1,536 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFailureThreshold is the default number of consecutive failures
+	// after which a CompositeBackend stops calling a backend.
+	DefaultFailureThreshold = 3
+
+	// DefaultCircuitCooldown is the default time a CompositeBackend waits
+	// before trying a failing backend again.
+	DefaultCircuitCooldown = 30 * time.Second
+
+	// DefaultConfirmationTolerance is the default number of confirmations
+	// by which quorum answers for a transaction may differ, allowing for
+	// backends a block apart.
+	DefaultConfirmationTolerance = 1
+)
+
+var (
+	// ErrCircuitOpen is returned by a CompositeBackend when every backend
+	// has failed too often recently to be called.
+	ErrCircuitOpen = errors.New("all backends unavailable")
+
+	// ErrBackendTimeout is returned by a CompositeBackend when a backend does
+	// not answer within its Timeout.
+	ErrBackendTimeout = errors.New("backend timed out")
+)
+
+// Disagreement describes backends giving different answers to a quorum read,
+// or fewer backends than the quorum answering.
+type Disagreement struct {
+	// Method is the Backend method called, such as "GetTransaction".
+	Method string
+
+	// Subject identifies what was read, such as a transaction hash.
+	Subject string
+
+	// Values maps the index of each backend that answered, in the order
+	// given to NewCompositeBackend, to a summary of its answer.
+	Values map[int]string
+
+	// Errors maps the index of each backend that failed to its error.
+	Errors map[int]error
+}
+
+func (d Disagreement) String() string {
+	if len(d.Errors) > 0 {
+		return fmt.Sprintf("%s %s: backends disagree %v, failed %v", d.Method,
+			d.Subject, d.Values, d.Errors)
+	}
+	return fmt.Sprintf("%s %s: backends disagree %v", d.Method, d.Subject,
+		d.Values)
+}
+
+// ContextBackend is a Backend whose calls can be canceled. Chain,
+// EsploraBackend and BitcoindBackend implement it.
+type ContextBackend interface {
+	Backend
+
+	// BackendWithContext returns a copy of the backend whose calls return
+	// early once ctx is done.
+	BackendWithContext(ctx context.Context) Backend
+}
+
+// BackendHealth is the health of one backend of a CompositeBackend.
+type BackendHealth struct {
+	// Failures is the number of consecutive failed calls.
+	Failures int
+
+	// LastError is the error of the last failed call.
+	LastError error
+
+	// LastSuccess is when the backend last answered.
+	LastSuccess time.Time
+
+	// Open reports whether the circuit is open, so the backend is not being
+	// called until the cooldown passes.
+	Open bool
+}
+
+// CompositeBackend is a Backend that combines several backends, for example
+// Chains using different API keys or an EsploraBackend, for resilience.
+//
+// Each call goes to the first backend that is healthy and fails over to the
+// next ones in order on errors and timeouts. A backend that fails
+// FailureThreshold times in a row has its circuit opened and is skipped
+// until Cooldown has passed, when a single trial call is let through.
+// Errors that are answers rather than failures, such as not found or an
+// invalid transaction, still fail over but do not count against a
+// backend's health.
+//
+// If Quorum is 2 or more, block, transaction and address balance reads are
+// sent to that many healthy backends at once, and to the next healthy
+// backends in place of any that fail. The answer given by the most backends
+// is returned, ties going to the earlier backend. OnDisagreement is called if
+// fewer than Quorum backends answer, or if the answers differ in block
+// hashes, transaction confirmations or address balances. Transaction
+// confirmations may differ by up to ConfirmationTolerance, but a transaction
+// confirmed by one backend and not another always disagrees.
+//
+// Calls to a ContextBackend are canceled when they time out. Calls to other
+// backends keep running in the background until they return, so they should
+// have their own timeout, such as http.Client.Timeout.
+type CompositeBackend struct {
+	// FailureThreshold defaults to DefaultFailureThreshold.
+	FailureThreshold int
+
+	// Cooldown defaults to DefaultCircuitCooldown.
+	Cooldown time.Duration
+
+	// Timeout is how long each backend call may take. Zero means no
+	// timeout. A ContextBackend call that times out is canceled.
+	Timeout time.Duration
+
+	// Quorum is the number of backends queried for reads.
+	Quorum int
+
+	// ConfirmationTolerance is how many confirmations quorum answers for a
+	// transaction may differ by before they disagree. Zero means
+	// DefaultConfirmationTolerance and a negative value means none.
+	ConfirmationTolerance int
+
+	// OnDisagreement, if set, is called for each quorum read on which the
+	// backends disagree or fewer than Quorum backends answer.
+	OnDisagreement func(Disagreement)
+
+	upstreams []*upstream
+}
+
+var _ HistoryBackend = (*CompositeBackend)(nil)
+
+type upstream struct {
+	backend Backend
+
+	mu          sync.Mutex
+	failures    int
+	lastErr     error
+	lastSuccess time.Time
+	openedAt    time.Time
+	trial       bool
+}
+
+// NewCompositeBackend creates a CompositeBackend over backends in order of
+// preference; the first is the primary.
+func NewCompositeBackend(backends ...Backend) *CompositeBackend {
+	cb := &CompositeBackend{}
+	for _, b := range backends {
+		cb.upstreams = append(cb.upstreams, &upstream{backend: b})
+	}
+	return cb
+}
+
+func (cb *CompositeBackend) threshold() int {
+	if cb.FailureThreshold > 0 {
+		return cb.FailureThreshold
+	}
+	return DefaultFailureThreshold
+}
+
+func (cb *CompositeBackend) confirmationTolerance() int {
+	switch {
+	case cb.ConfirmationTolerance > 0:
+		return cb.ConfirmationTolerance
+	case cb.ConfirmationTolerance < 0:
+		return 0
+	}
+	return DefaultConfirmationTolerance
+}
+
+func (cb *CompositeBackend) cooldown() time.Duration {
+	if cb.Cooldown > 0 {
+		return cb.Cooldown
+	}
+	return DefaultCircuitCooldown
+}
+
+// Health returns the health of each backend in the order given to
+// NewCompositeBackend.
+func (cb *CompositeBackend) Health() []BackendHealth {
+	health := make([]BackendHealth, len(cb.upstreams))
+	for i, u := range cb.upstreams {
+		u.mu.Lock()
+		health[i] = BackendHealth{
+			Failures:    u.failures,
+			LastError:   u.lastErr,
+			LastSuccess: u.lastSuccess,
+			Open:        u.failures >= cb.threshold(),
+		}
+		u.mu.Unlock()
+	}
+	return health
+}
+
+// acquire reports whether u may be called. When the circuit is open and the
+// cooldown has passed it lets one trial call through.
+func (cb *CompositeBackend) acquire(u *upstream) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.failures < cb.threshold() {
+		return true
+	}
+	if !u.trial && time.Since(u.openedAt) >= cb.cooldown() {
+		u.trial = true
+		return true
+	}
+	return false
+}
+
+// isFailure reports whether err means a backend is unhealthy rather than
+// that it answered with an error.
+func isFailure(err error) bool {
+	switch err := err.(type) {
+	case *APIError:
+		return err.StatusCode >= http.StatusInternalServerError ||
+			err.StatusCode == http.StatusTooManyRequests ||
+			err.StatusCode == http.StatusUnauthorized ||
+			err.StatusCode == http.StatusForbidden
+	case *RPCError, *HistoryTruncatedError:
+		return false
+	}
+	return err != ErrNotSupported
+}
+
+func (cb *CompositeBackend) record(u *upstream, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.trial = false
+	if err == nil || !isFailure(err) {
+		u.failures = 0
+		u.lastSuccess = time.Now()
+		return
+	}
+	u.failures++
+	u.lastErr = err
+	if u.failures >= cb.threshold() {
+		u.openedAt = time.Now()
+	}
+}
+
+// invoke calls fn on u within the timeout and records the outcome. A
+// ContextBackend call is canceled when invoke returns.
+func (cb *CompositeBackend) invoke(u *upstream,
+	fn func(Backend) (interface{}, error)) (interface{}, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if cb.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), cb.Timeout)
+	}
+	defer cancel()
+	b := u.backend
+	if c, ok := b.(ContextBackend); ok {
+		b = c.BackendWithContext(ctx)
+	}
+
+	type result struct {
+		v   interface{}
+		err error
+	}
+	results := make(chan result, 1)
+	go func() {
+		v, err := fn(b)
+		results <- result{v, err}
+	}()
+
+	select {
+	case r := <-results:
+		if r.err != nil && ctx.Err() == context.DeadlineExceeded {
+			r.err = ErrBackendTimeout
+		}
+		cb.record(u, r.err)
+		return r.v, r.err
+	case <-ctx.Done():
+		cb.record(u, ErrBackendTimeout)
+		return nil, ErrBackendTimeout
+	}
+}
+
+// call calls fn on the first healthy backend, failing over to the others on
+// errors. It returns the first error if no backend succeeds.
+func (cb *CompositeBackend) call(
+	fn func(Backend) (interface{}, error)) (interface{}, error) {
+	var firstErr error
+	for _, u := range cb.upstreams {
+		if !cb.acquire(u) {
+			continue
+		}
+		v, err := cb.invoke(u, fn)
+		if err == nil {
+			return v, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		return nil, ErrCircuitOpen
+	}
+	return nil, firstErr
+}
+
+// read is call with a quorum. summary describes the parts of an answer that
+// backends must agree on, and agree, if not nil, reports whether two answers
+// agree when their summaries may differ. Backends that fail are replaced by
+// the next healthy ones until Quorum backends have answered or none are
+// left.
+func (cb *CompositeBackend) read(method, subject string,
+	fn func(Backend) (interface{}, error), summary func(interface{}) string,
+	agree func(a, b interface{}) bool) (interface{}, error) {
+	if cb.Quorum < 2 {
+		return cb.call(fn)
+	}
+
+	type answer struct {
+		index int
+		v     interface{}
+		err   error
+	}
+	answers, answered, next := []answer{}, 0, 0
+	for answered < cb.Quorum {
+		chosen := []int{}
+		for ; next < len(cb.upstreams) &&
+			answered+len(chosen) < cb.Quorum; next++ {
+			if cb.acquire(cb.upstreams[next]) {
+				chosen = append(chosen, next)
+			}
+		}
+		if len(chosen) == 0 {
+			break
+		}
+
+		round := make([]answer, len(chosen))
+		var wg sync.WaitGroup
+		wg.Add(len(chosen))
+		for i, index := range chosen {
+			go func(i, index int) {
+				defer wg.Done()
+				v, err := cb.invoke(cb.upstreams[index], fn)
+				round[i] = answer{index, v, err}
+			}(i, index)
+		}
+		wg.Wait()
+		for _, a := range round {
+			if a.err == nil {
+				answered++
+			}
+		}
+		answers = append(answers, round...)
+	}
+	if len(answers) == 0 {
+		return nil, ErrCircuitOpen
+	}
+
+	// Answers are grouped with the first answer they agree with, which is
+	// the one returned for the group.
+	if agree == nil {
+		agree = func(a, b interface{}) bool { return summary(a) == summary(b) }
+	}
+	summaries, errs := map[int]string{}, map[int]error{}
+	groups, counts := []int{}, map[int]int{}
+	best := -1
+	for i, a := range answers {
+		if a.err != nil {
+			errs[a.index] = a.err
+			continue
+		}
+		summaries[a.index] = summary(a.v)
+		group := i
+		for _, g := range groups {
+			if agree(answers[g].v, a.v) {
+				group = g
+				break
+			}
+		}
+		if group == i {
+			groups = append(groups, i)
+		}
+		counts[group]++
+		if best < 0 || counts[group] > counts[best] {
+			best = group
+		}
+	}
+	if best < 0 {
+		return nil, answers[0].err
+	}
+	if (len(groups) > 1 || answered < cb.Quorum) && cb.OnDisagreement != nil {
+		cb.OnDisagreement(Disagreement{method, subject, summaries, errs})
+	}
+	return answers[best].v, nil
+}
+
+func blockSummary(v interface{}) string {
+	return v.(Block).Hash
+}
+
+// GetBlockByHash implements Backend.
+func (cb *CompositeBackend) GetBlockByHash(hash string) (Block, error) {
+	v, err := cb.read("GetBlockByHash", hash,
+		func(b Backend) (interface{}, error) {
+			return b.GetBlockByHash(hash)
+		}, blockSummary, nil)
+	if err != nil {
+		return Block{}, err
+	}
+	return v.(Block), nil
+}
+
+// GetBlockByHeight implements Backend.
+func (cb *CompositeBackend) GetBlockByHeight(height uint64) (Block, error) {
+	v, err := cb.read("GetBlockByHeight", fmt.Sprint(height),
+		func(b Backend) (interface{}, error) {
+			return b.GetBlockByHeight(height)
+		}, blockSummary, nil)
+	if err != nil {
+		return Block{}, err
+	}
+	return v.(Block), nil
+}
+
+// GetLatestBlock implements Backend.
+func (cb *CompositeBackend) GetLatestBlock() (Block, error) {
+	v, err := cb.read("GetLatestBlock", "latest",
+		func(b Backend) (interface{}, error) {
+			return b.GetLatestBlock()
+		}, blockSummary, nil)
+	if err != nil {
+		return Block{}, err
+	}
+	return v.(Block), nil
+}
+
+// GetTransaction implements Backend.
+func (cb *CompositeBackend) GetTransaction(hash string) (Transaction, error) {
+	v, err := cb.read("GetTransaction", hash,
+		func(b Backend) (interface{}, error) {
+			return b.GetTransaction(hash)
+		}, func(v interface{}) string {
+			tx := v.(Transaction)
+			return fmt.Sprintf("%d confirmations in %s", tx.Confirmations,
+				tx.BlockHash)
+		}, func(a, b interface{}) bool {
+			x, y := a.(Transaction), b.(Transaction)
+			d := x.Confirmations - y.Confirmations
+			if d < 0 {
+				d = -d
+			}
+			return x.BlockHash == y.BlockHash &&
+				d <= int64(cb.confirmationTolerance())
+		})
+	if err != nil {
+		return Transaction{}, err
+	}
+	return v.(Transaction), nil
+}
+
+// GetAddressMulti implements Backend.
+func (cb *CompositeBackend) GetAddressMulti(
+	addresses []string) ([]Address, error) {
+	v, err := cb.read("GetAddressMulti", strings.Join(addresses, ","),
+		func(b Backend) (interface{}, error) {
+			return b.GetAddressMulti(addresses)
+		}, func(v interface{}) string {
+			balances := []string{}
+			for _, a := range v.([]Address) {
+				balances = append(balances, fmt.Sprintf("%d/%d",
+					a.Confirmed.Balance, a.Total.Balance))
+			}
+			return strings.Join(balances, ",")
+		}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return v.([]Address), nil
+}
+
+// GetAddressTransactionsMulti implements Backend. It is never a quorum read.
+func (cb *CompositeBackend) GetAddressTransactionsMulti(addresses []string,
+	limit int) ([]Transaction, error) {
+	v, err := cb.call(func(b Backend) (interface{}, error) {
+		return b.GetAddressTransactionsMulti(addresses, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]Transaction), nil
+}
+
+// GetAddressHistory implements HistoryBackend. It is never a quorum read and
+// only calls backends that are HistoryBackends; if none are ErrNotSupported
+// is returned.
+func (cb *CompositeBackend) GetAddressHistory(
+	addresses []string) ([]Transaction, error) {
+	v, err := cb.call(func(b Backend) (interface{}, error) {
+		hb, ok := b.(HistoryBackend)
+		if !ok {
+			return nil, ErrNotSupported
+		}
+		return hb.GetAddressHistory(addresses)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]Transaction), nil
+}
+
+// GetAddressUnspentOutputsMulti implements Backend. It is never a quorum
+// read.
+func (cb *CompositeBackend) GetAddressUnspentOutputsMulti(
+	addresses []string) ([]Output, error) {
+	v, err := cb.call(func(b Backend) (interface{}, error) {
+		return b.GetAddressUnspentOutputsMulti(addresses)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]Output), nil
+}
+
+// SendTransaction implements Backend. The transaction is sent through the
+// first backend that accepts it.
+func (cb *CompositeBackend) SendTransaction(hex string) (string, error) {
+	v, err := cb.call(func(b Backend) (interface{}, error) {
+		return b.SendTransaction(hex)
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
@@ -0,0 +1,267 @@
+package chain_test
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qedus/chain"
+)
+
+// failingAPI answers every request with a server error and counts them.
+type failingAPI struct {
+	requests int32
+}
+
+func (f *failingAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&f.requests, 1)
+	writeTestJSON(w, http.StatusInternalServerError,
+		map[string]string{"message": "unavailable"})
+}
+
+func TestCompositeBackendFailover(t *testing.T) {
+	failing := &failingAPI{}
+	api := newFakeAPI(t, chain.MainNet, 5)
+	cb := chain.NewCompositeBackend(
+		newTestChain(t, chain.MainNet, failing),
+		newTestChain(t, chain.MainNet, api))
+	cb.FailureThreshold, cb.Cooldown = 2, 50*time.Millisecond
+	c := chain.NewWithBackend(cb, chain.MainNet)
+
+	for i := 0; i < 3; i++ {
+		block, err := c.GetLatestBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Height != 5 {
+			t.Fatal("unexpected block", block)
+		}
+	}
+	// The primary is skipped once its circuit opens.
+	if n := atomic.LoadInt32(&failing.requests); n != 2 {
+		t.Fatal("expected two requests to the failing backend", n)
+	}
+	health := cb.Health()
+	if !health[0].Open || health[0].Failures != 2 || health[0].LastError == nil ||
+		health[1].Open || health[1].LastSuccess.IsZero() {
+		t.Fatal("unexpected health", health)
+	}
+
+	// After the cooldown one trial request is let through.
+	time.Sleep(60 * time.Millisecond)
+	if _, err := c.GetLatestBlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetLatestBlock(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&failing.requests); n != 3 {
+		t.Fatal("expected one trial request", n)
+	}
+
+	// Not found is an answer so fails over without hurting health.
+	if _, err := c.GetTransaction(fakeHash("missing")); !chain.IsNotFound(err) {
+		t.Fatal("expected not found", err)
+	}
+	if cb.Health()[1].Failures != 0 {
+		t.Fatal("not found counted as a failure", cb.Health()[1])
+	}
+}
+
+func TestCompositeBackendTimeout(t *testing.T) {
+	slow := newSlowHandler()
+	defer close(slow.release)
+	api := newFakeAPI(t, chain.MainNet, 5)
+	cb := chain.NewCompositeBackend(
+		newTestChain(t, chain.MainNet, slow),
+		newTestChain(t, chain.MainNet, api))
+	cb.Timeout = 20 * time.Millisecond
+
+	block, err := cb.GetLatestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Height != 5 {
+		t.Fatal("unexpected block", block)
+	}
+	if cb.Health()[0].LastError != chain.ErrBackendTimeout {
+		t.Fatal("expected timeout", cb.Health()[0])
+	}
+
+	// The timed out request is canceled rather than left running.
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&slow.canceled) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out request not canceled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCompositeBackendCircuitOpen(t *testing.T) {
+	cb := chain.NewCompositeBackend(
+		newTestChain(t, chain.MainNet, &failingAPI{}))
+	cb.FailureThreshold = 1
+	if _, err := cb.GetLatestBlock(); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := cb.GetLatestBlock(); err != chain.ErrCircuitOpen {
+		t.Fatal("expected open circuit", err)
+	}
+}
+
+func TestCompositeBackendQuorum(t *testing.T) {
+	apis := []*fakeAPI{
+		newFakeAPI(t, chain.MainNet, 5),
+		newFakeAPI(t, chain.MainNet, 5),
+		newFakeAPI(t, chain.MainNet, 5),
+	}
+	// The primary is on a fork of the others.
+	apis[0].reorg(1, 1)
+	backends := []chain.Backend{}
+	for _, api := range apis {
+		backends = append(backends, newTestChain(t, chain.MainNet, api))
+	}
+	cb := chain.NewCompositeBackend(backends...)
+	cb.Quorum = 3
+	disagreements := []chain.Disagreement{}
+	cb.OnDisagreement = func(d chain.Disagreement) {
+		disagreements = append(disagreements, d)
+	}
+
+	block, err := cb.GetBlockByHeight(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Hash != apis[1].tip().Hash {
+		t.Fatal("expected the majority block", block.Hash)
+	}
+	if len(disagreements) != 1 || len(disagreements[0].Values) != 3 ||
+		disagreements[0].Method != "GetBlockByHeight" ||
+		disagreements[0].Subject != "5" {
+		t.Fatal("unexpected disagreements", disagreements)
+	}
+
+	disagreements = nil
+	if _, err := cb.GetBlockByHeight(4); err != nil {
+		t.Fatal(err)
+	}
+	if len(disagreements) != 0 {
+		t.Fatal("unexpected disagreements", disagreements)
+	}
+}
+
+func TestCompositeBackendQuorumConfirmations(t *testing.T) {
+	apis := []*fakeAPI{
+		newFakeAPI(t, chain.MainNet, 5),
+		newFakeAPI(t, chain.MainNet, 5),
+	}
+	tx := payment("tx", otherAddress, testAddress, 1000)
+	backends := []chain.Backend{}
+	for _, api := range apis {
+		api.mine(tx)
+		backends = append(backends, newTestChain(t, chain.MainNet, api))
+	}
+	cb := chain.NewCompositeBackend(backends...)
+	cb.Quorum = 2
+	disagreements := []chain.Disagreement{}
+	cb.OnDisagreement = func(d chain.Disagreement) {
+		disagreements = append(disagreements, d)
+	}
+
+	// Backends a block apart are within the default tolerance.
+	apis[1].extend(1)
+	got, err := cb.GetTransaction(tx.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Confirmations != 1 || len(disagreements) != 0 {
+		t.Fatal("expected the primary transaction", got, disagreements)
+	}
+
+	// Two blocks apart they are not.
+	apis[1].extend(1)
+	if _, err := cb.GetTransaction(tx.Hash); err != nil {
+		t.Fatal(err)
+	}
+	if len(disagreements) != 1 {
+		t.Fatal("expected a confirmations disagreement", disagreements)
+	}
+
+	// Nor are they with no tolerance.
+	disagreements = nil
+	cb.ConfirmationTolerance = -1
+	apis[0].extend(2)
+	apis[1].extend(1)
+	if _, err := cb.GetTransaction(tx.Hash); err != nil {
+		t.Fatal(err)
+	}
+	if len(disagreements) != 1 {
+		t.Fatal("expected a confirmations disagreement", disagreements)
+	}
+
+	// Confirmed and unconfirmed always disagree.
+	disagreements = nil
+	cb.ConfirmationTolerance = 10
+	pending := payment("pending", otherAddress, testAddress, 1000)
+	apis[0].addTransaction(pending)
+	apis[1].mine(pending)
+	if _, err := cb.GetTransaction(pending.Hash); err != nil {
+		t.Fatal(err)
+	}
+	if len(disagreements) != 1 {
+		t.Fatal("expected a confirmed disagreement", disagreements)
+	}
+}
+
+func TestCompositeBackendQuorumFailover(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 5)
+	for _, tc := range []struct {
+		backends []chain.Backend
+		failed   []int
+	}{
+		// One error and one answer is a short quorum.
+		{[]chain.Backend{newTestChain(t, chain.MainNet, &failingAPI{}),
+			newTestChain(t, chain.MainNet, api)}, []int{0}},
+		// Both chosen backends fail so the third answers alone.
+		{[]chain.Backend{newTestChain(t, chain.MainNet, &failingAPI{}),
+			newTestChain(t, chain.MainNet, &failingAPI{}),
+			newTestChain(t, chain.MainNet, api)}, []int{0, 1}},
+		// The third backend replaces the failed one to make the quorum.
+		{[]chain.Backend{newTestChain(t, chain.MainNet, &failingAPI{}),
+			newTestChain(t, chain.MainNet, api),
+			newTestChain(t, chain.MainNet, api)}, nil},
+	} {
+		cb := chain.NewCompositeBackend(tc.backends...)
+		cb.Quorum = 2
+		disagreements := []chain.Disagreement{}
+		cb.OnDisagreement = func(d chain.Disagreement) {
+			disagreements = append(disagreements, d)
+		}
+
+		block, err := cb.GetLatestBlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Height != 5 {
+			t.Fatal("unexpected block", block)
+		}
+		if tc.failed == nil {
+			if len(disagreements) != 0 {
+				t.Fatal("unexpected disagreements", disagreements)
+			}
+			continue
+		}
+		if len(disagreements) != 1 ||
+			len(disagreements[0].Errors) != len(tc.failed) ||
+			len(disagreements[0].Values) != 1 {
+			t.Fatal("expected a short quorum", disagreements)
+		}
+		for _, i := range tc.failed {
+			if disagreements[0].Errors[i] == nil {
+				t.Fatal("expected an error from backend", i, disagreements)
+			}
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// esploraPageSize is the number of confirmed transactions Esplora returns per
+// page of address history.
+const esploraPageSize = 25
+
+// EsploraBackend is a Backend for an Esplora compatible REST API, such as
+// those run by Blockstream and mempool.space or a self-hosted electrs server.
+//
+// Esplora does not report whether outputs are spent so Output.Spent is
+// always false, and unspent outputs do not include their script.
+//
+// Esplora documentation can be found here
+// https://github.com/Blockstream/esplora/blob/master/API.md.
+type EsploraBackend struct {
+	client  *http.Client
+	baseURL string
+
+	// ctx is nil for requests that are not canceled.
+	ctx context.Context
+}
+
+var _ HistoryBackend = (*EsploraBackend)(nil)
+
+// NewEsploraBackend creates an EsploraBackend for the API at baseURL, for
+// example "https://blockstream.info/api".
+func NewEsploraBackend(c *http.Client, baseURL string) *EsploraBackend {
+	return &EsploraBackend{client: c, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// BackendWithContext implements ContextBackend. The copy's requests use ctx.
+func (e *EsploraBackend) BackendWithContext(ctx context.Context) Backend {
+	copied := *e
+	copied.ctx = ctx
+	return &copied
+}
+
+type esploraBlock struct {
+	ID                string
+	Height            int64
+	Version           int32
+	Timestamp         int64
+	MerkleRoot        string `json:"merkle_root"`
+	PreviousBlockHash string `json:"previousblockhash"`
+	Nonce             uint32
+	Bits              uint32
+	Difficulty        float64
+}
+
+type esploraStatus struct {
+	Confirmed   bool
+	BlockHeight int64  `json:"block_height"`
+	BlockHash   string `json:"block_hash"`
+	BlockTime   int64  `json:"block_time"`
+}
+
+type esploraOutput struct {
+	ScriptPubKey        string `json:"scriptpubkey"`
+	ScriptPubKeyASM     string `json:"scriptpubkey_asm"`
+	ScriptPubKeyType    string `json:"scriptpubkey_type"`
+	ScriptPubKeyAddress string `json:"scriptpubkey_address"`
+	Value               Amount
+}
+
+type esploraTransaction struct {
+	TxID string
+	Vin  []struct {
+		TxID         string
+		Vout         uint32
+		IsCoinbase   bool   `json:"is_coinbase"`
+		ScriptSig    string `json:"scriptsig"`
+		ScriptSigASM string `json:"scriptsig_asm"`
+		Sequence     uint32
+		Prevout      *esploraOutput
+	}
+	Vout   []esploraOutput
+	Fee    Amount
+	Status esploraStatus
+}
+
+type esploraStats struct {
+	FundedTxoSum Amount `json:"funded_txo_sum"`
+	SpentTxoSum  Amount `json:"spent_txo_sum"`
+}
+
+// esploraScriptTypes maps Esplora output script types to the names used by
+// Chain.com and Bitcoin Core.
+var esploraScriptTypes = map[string]string{
+	"p2pk":      "pubkey",
+	"p2pkh":     "pubkeyhash",
+	"p2sh":      "scripthash",
+	"v0_p2wpkh": "witness_v0_keyhash",
+	"v0_p2wsh":  "witness_v0_scripthash",
+	"v1_p2tr":   "witness_v1_taproot",
+	"multisig":  "multisig",
+	"op_return": "nulldata",
+}
+
+func (e *EsploraBackend) do(req *http.Request) ([]byte, error) {
+	if e.ctx != nil {
+		req = req.WithContext(e.ctx)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkHTTPResponse(resp); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (e *EsploraBackend) getText(path string) (string, error) {
+	req, err := http.NewRequest("GET", e.baseURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	data, err := e.do(req)
+	return strings.TrimSpace(string(data)), err
+}
+
+func (e *EsploraBackend) getJSON(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", e.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	data, err := e.do(req)
+	if err != nil {
+		return err
+	}
+	return decodeJSON(bytes.NewReader(data), v)
+}
+
+func (e *EsploraBackend) tipHeight() (int64, error) {
+	text, err := e.getText("/blocks/tip/height")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(text, 10, 64)
+}
+
+// GetBlockByHash implements Backend.
+func (e *EsploraBackend) GetBlockByHash(hash string) (Block, error) {
+	eb, status := esploraBlock{}, struct {
+		InBestChain bool `json:"in_best_chain"`
+	}{}
+	if err := e.getJSON("/block/"+hash, &eb); err != nil {
+		return Block{}, err
+	}
+	if err := e.getJSON("/block/"+hash+"/status", &status); err != nil {
+		return Block{}, err
+	}
+	txids := []string{}
+	if err := e.getJSON("/block/"+hash+"/txids", &txids); err != nil {
+		return Block{}, err
+	}
+	tip, err := e.tipHeight()
+	if err != nil {
+		return Block{}, err
+	}
+
+	b := Block{
+		Hash:              eb.ID,
+		PreviousHash:      eb.PreviousBlockHash,
+		Height:            eb.Height,
+		Version:           eb.Version,
+		MerkleRoot:        eb.MerkleRoot,
+		Time:              time.Unix(eb.Timestamp, 0).UTC(),
+		Nonce:             eb.Nonce,
+		Difficulty:        eb.Difficulty,
+		Bits:              fmt.Sprintf("%08x", eb.Bits),
+		TransactionHashes: txids,
+	}
+	if status.InBestChain && tip >= b.Height {
+		b.Confirmations = tip - b.Height + 1
+	}
+	return b, nil
+}
+
+// GetBlockByHeight implements Backend.
+func (e *EsploraBackend) GetBlockByHeight(height uint64) (Block, error) {
+	hash, err := e.getText(fmt.Sprintf("/block-height/%d", height))
+	if err != nil {
+		return Block{}, err
+	}
+	return e.GetBlockByHash(hash)
+}
+
+// GetLatestBlock implements Backend.
+func (e *EsploraBackend) GetLatestBlock() (Block, error) {
+	hash, err := e.getText("/blocks/tip/hash")
+	if err != nil {
+		return Block{}, err
+	}
+	return e.GetBlockByHash(hash)
+}
+
+func (o esploraOutput) output(txid string, index uint32) Output {
+	out := Output{
+		TransactionHash: txid,
+		OutputIndex:     index,
+		Value:           o.Value,
+		Script:          o.ScriptPubKeyASM,
+		ScriptHex:       o.ScriptPubKey,
+		ScriptType:      esploraScriptTypes[o.ScriptPubKeyType],
+	}
+	if out.ScriptType == "" {
+		out.ScriptType = "nonstandard"
+	}
+	if o.ScriptPubKeyAddress != "" {
+		out.Addresses = []string{o.ScriptPubKeyAddress}
+	}
+	if singleKeyScript(out.ScriptType) {
+		out.RequiredSignatures = 1
+	}
+	return out
+}
+
+func (et esploraTransaction) transaction(tip int64) Transaction {
+	tx := Transaction{Hash: et.TxID, Fees: et.Fee}
+	if et.Status.Confirmed {
+		tx.BlockHash = et.Status.BlockHash
+		tx.BlockHeight = et.Status.BlockHeight
+		tx.BlockTime = time.Unix(et.Status.BlockTime, 0).UTC()
+		if tip >= tx.BlockHeight {
+			tx.Confirmations = tip - tx.BlockHeight + 1
+		}
+	}
+	for _, vin := range et.Vin {
+		in := Input{
+			TransactionHash: et.TxID,
+			Sequence:        vin.Sequence,
+		}
+		if vin.IsCoinbase {
+			in.Coinbase = vin.ScriptSig
+		} else {
+			in.OutputHash = vin.TxID
+			in.OutputIndex = vin.Vout
+			in.ScriptSignature = vin.ScriptSigASM
+		}
+		if vin.Prevout != nil {
+			in.Value = vin.Prevout.Value
+			if vin.Prevout.ScriptPubKeyAddress != "" {
+				in.Addresses = []string{vin.Prevout.ScriptPubKeyAddress}
+			}
+		}
+		tx.Inputs = append(tx.Inputs, in)
+	}
+	for i, vout := range et.Vout {
+		tx.Outputs = append(tx.Outputs, vout.output(et.TxID, uint32(i)))
+	}
+	tx.Amount = outputTotal(tx.Outputs)
+	return tx
+}
+
+// GetTransaction implements Backend.
+func (e *EsploraBackend) GetTransaction(hash string) (Transaction, error) {
+	et := esploraTransaction{}
+	if err := e.getJSON("/tx/"+hash, &et); err != nil {
+		return Transaction{}, err
+	}
+	tip, err := e.tipHeight()
+	if err != nil {
+		return Transaction{}, err
+	}
+	return et.transaction(tip), nil
+}
+
+// GetAddressMulti implements Backend. It makes one request per address.
+func (e *EsploraBackend) GetAddressMulti(
+	addresses []string) ([]Address, error) {
+	result := make([]Address, len(addresses))
+	for i, a := range addresses {
+		ea := struct {
+			ChainStats   esploraStats `json:"chain_stats"`
+			MempoolStats esploraStats `json:"mempool_stats"`
+		}{}
+		if err := e.getJSON("/address/"+a, &ea); err != nil {
+			return nil, err
+		}
+		result[i].Address = a
+		result[i].Confirmed.Received = ea.ChainStats.FundedTxoSum
+		result[i].Confirmed.Sent = ea.ChainStats.SpentTxoSum
+		result[i].Confirmed.Balance = ea.ChainStats.FundedTxoSum -
+			ea.ChainStats.SpentTxoSum
+		result[i].Total.Received = result[i].Confirmed.Received +
+			ea.MempoolStats.FundedTxoSum
+		result[i].Total.Sent = result[i].Confirmed.Sent +
+			ea.MempoolStats.SpentTxoSum
+		result[i].Total.Balance = result[i].Total.Received -
+			result[i].Total.Sent
+	}
+	return result, nil
+}
+
+// GetAddressTransactionsMulti implements Backend. It pages through the
+// history of each address until limit transactions are found.
+func (e *EsploraBackend) GetAddressTransactionsMulti(addresses []string,
+	limit int) ([]Transaction, error) {
+	tip, err := e.tipHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	lists := [][]Transaction{}
+	for _, a := range addresses {
+		txns, err := e.addressTransactions(a, tip, limit)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, txns)
+	}
+	return mergeTransactions(lists, limit), nil
+}
+
+// GetAddressHistory implements HistoryBackend. It pages through the whole
+// history of each address.
+func (e *EsploraBackend) GetAddressHistory(
+	addresses []string) ([]Transaction, error) {
+	tip, err := e.tipHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	lists, total := [][]Transaction{}, 0
+	for _, a := range addresses {
+		txns, err := e.addressTransactions(a, tip, 0)
+		if err != nil {
+			return nil, err
+		}
+		lists, total = append(lists, txns), total+len(txns)
+	}
+	return mergeTransactions(lists, total), nil
+}
+
+// addressTransactions pages through the history of address, newest first,
+// until at least limit transactions are found or, if limit is zero, to the
+// end.
+func (e *EsploraBackend) addressTransactions(address string, tip int64,
+	limit int) ([]Transaction, error) {
+	txns, path := []Transaction{}, "/address/"+address+"/txs"
+	for limit == 0 || len(txns) < limit {
+		page := []esploraTransaction{}
+		if err := e.getJSON(path, &page); err != nil {
+			return nil, err
+		}
+		confirmed := 0
+		for _, et := range page {
+			txns = append(txns, et.transaction(tip))
+			if et.Status.Confirmed {
+				confirmed++
+			}
+		}
+		if confirmed < esploraPageSize {
+			break
+		}
+		path = "/address/" + address + "/txs/chain/" + page[len(page)-1].TxID
+	}
+	return txns, nil
+}
+
+// GetAddressUnspentOutputsMulti implements Backend. It makes one request per
+// address.
+func (e *EsploraBackend) GetAddressUnspentOutputsMulti(
+	addresses []string) ([]Output, error) {
+	tip, err := e.tipHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	outputs := []Output{}
+	for _, a := range addresses {
+		utxos := []struct {
+			TxID   string
+			Vout   uint32
+			Value  Amount
+			Status esploraStatus
+		}{}
+		if err := e.getJSON("/address/"+a+"/utxo", &utxos); err != nil {
+			return nil, err
+		}
+		for _, u := range utxos {
+			out := Output{
+				TransactionHash: u.TxID,
+				OutputIndex:     u.Vout,
+				Value:           u.Value,
+				Addresses:       []string{a},
+			}
+			if u.Status.Confirmed && tip >= u.Status.BlockHeight {
+				out.Confirmations = tip - u.Status.BlockHeight + 1
+			}
+			outputs = append(outputs, out)
+		}
+	}
+	return outputs, nil
+}
+
+// SendTransaction implements Backend.
+func (e *EsploraBackend) SendTransaction(hex string) (string, error) {
+	req, err := http.NewRequest("POST", e.baseURL+"/tx",
+		strings.NewReader(hex))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	data, err := e.do(req)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,189 @@
+package chain_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qedus/chain"
+)
+
+// esploraTx is an Esplora transaction spending a testAddress output to
+// otherAddress, confirmed at height 100.
+const esploraTx = `{
+	"txid": "%s",
+	"vin": [{
+		"txid": "%s", "vout": 1, "is_coinbase": false,
+		"scriptsig": "", "scriptsig_asm": "", "sequence": 4294967295,
+		"prevout": {"scriptpubkey": "76a914", "scriptpubkey_asm": "OP_DUP",
+			"scriptpubkey_type": "p2pkh",
+			"scriptpubkey_address": "` + testAddress + `", "value": 6000}
+	}],
+	"vout": [{"scriptpubkey": "0014", "scriptpubkey_asm": "OP_0",
+		"scriptpubkey_type": "v0_p2wpkh",
+		"scriptpubkey_address": "` + otherAddress + `", "value": 5000}],
+	"fee": 1000,
+	"status": {"confirmed": true, "block_height": 100,
+		"block_hash": "%s", "block_time": 1400000000}
+}`
+
+func newEsploraServer(t *testing.T) *httptest.Server {
+	blockHash, txHash := fakeHash("block", 100), fakeHash("tx", 1)
+	tx := fmt.Sprintf(esploraTx, txHash, fakeHash("funding"), blockHash)
+	routes := map[string]string{
+		"/blocks/tip/height": "102",
+		"/blocks/tip/hash":   blockHash,
+		"/block-height/100":  blockHash,
+		"/block/" + blockHash: `{"id": "` + blockHash + `", "height": 100,
+			"version": 2, "timestamp": 1400000000, "merkle_root": "` + txHash +
+			`", "previousblockhash": "` + fakeHash("block", 99) + `",
+			"nonce": 7, "bits": 486604799, "difficulty": 1}`,
+		"/block/" + blockHash + "/status": `{"in_best_chain": true}`,
+		"/block/" + blockHash + "/txids":  `["` + txHash + `"]`,
+		"/tx/" + txHash:                   tx,
+		"/address/" + testAddress: `{"address": "` + testAddress + `",
+			"chain_stats": {"funded_txo_sum": 6000, "spent_txo_sum": 6000},
+			"mempool_stats": {"funded_txo_sum": 300, "spent_txo_sum": 0}}`,
+		"/address/" + testAddress + "/txs": "[" + tx + "]",
+		"/address/" + otherAddress + "/utxo": `[{"txid": "` + txHash +
+			`", "vout": 0, "value": 5000, "status": {"confirmed": true,
+			"block_height": 100}}]`,
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		if r.Method == "POST" && r.URL.Path == "/tx" {
+			fmt.Fprint(w, txHash)
+			return
+		}
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestEsploraBackend(t *testing.T) {
+	s := newEsploraServer(t)
+	c := chain.NewWithBackend(chain.NewEsploraBackend(s.Client(), s.URL+"/"),
+		chain.MainNet)
+
+	block, err := c.GetBlockByHeight(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.Hash != fakeHash("block", 100) || block.Confirmations != 3 ||
+		block.Bits != "1d00ffff" || block.PreviousHash != fakeHash("block", 99) ||
+		!block.Time.Equal(time.Unix(1400000000, 0)) ||
+		len(block.TransactionHashes) != 1 {
+		t.Fatal("unexpected block", block)
+	}
+
+	tx, err := c.GetTransaction(fakeHash("tx", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Confirmations != 3 || tx.BlockHeight != 100 || tx.Fees != 1000 ||
+		tx.Amount != 5000 {
+		t.Fatal("unexpected transaction", tx)
+	}
+	in, out := tx.Inputs[0], tx.Outputs[0]
+	if in.OutputHash != fakeHash("funding") || in.OutputIndex != 1 ||
+		in.Value != 6000 || in.Addresses[0] != testAddress {
+		t.Fatal("unexpected input", in)
+	}
+	if out.ScriptType != "witness_v0_keyhash" || out.Addresses[0] != otherAddress ||
+		out.RequiredSignatures != 1 || out.ScriptHex != "0014" {
+		t.Fatal("unexpected output", out)
+	}
+
+	addr, err := c.GetAddress(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.Confirmed.Balance != 0 || addr.Total.Balance != 300 ||
+		addr.Total.Received != 6300 {
+		t.Fatal("unexpected address", addr)
+	}
+
+	txns, err := c.GetAddressTransactions(testAddress, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txns) != 1 || txns[0].Hash != tx.Hash {
+		t.Fatal("unexpected address transactions", txns)
+	}
+
+	unspents, err := c.GetAddressUnspentOutputs(otherAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unspents) != 1 || unspents[0].Value != 5000 ||
+		unspents[0].Confirmations != 3 {
+		t.Fatal("unexpected unspents", unspents)
+	}
+
+	hash, err := c.SendTransaction("0100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != tx.Hash {
+		t.Fatal("unexpected send hash", hash)
+	}
+
+	if _, err := c.GetTransaction(fakeHash("missing")); !chain.IsNotFound(err) {
+		t.Fatal("expected not found", err)
+	}
+}
+
+func TestEsploraAddressHistory(t *testing.T) {
+	// More confirmed transactions than one call returns, in pages of 25.
+	const n = chain.MaxAddressTransactionsLimit + 10
+	blockHash, txns := fakeHash("block", 100), []string{}
+	for i := 0; i < n; i++ {
+		txns = append(txns, fmt.Sprintf(esploraTx, fakeHash("tx", i),
+			fakeHash("funding", i), blockHash))
+	}
+	pages := map[string]string{}
+	path := "/address/" + testAddress + "/txs"
+	for start := 0; start < n; start += 25 {
+		end := start + 25
+		if end > n {
+			end = n
+		}
+		pages[path] = "[" + strings.Join(txns[start:end], ",") + "]"
+		path = "/address/" + testAddress + "/txs/chain/" +
+			fakeHash("tx", end-1)
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		if r.URL.Path == "/blocks/tip/height" {
+			fmt.Fprint(w, "102")
+			return
+		}
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer s.Close()
+
+	c := chain.NewWithBackend(chain.NewEsploraBackend(s.Client(), s.URL),
+		chain.MainNet)
+	entries, err := c.AddressLedger([]string{testAddress})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != n || entries[0].Debit != 6000 {
+		t.Fatal("unexpected entries", len(entries))
+	}
+}
@@ -0,0 +1,136 @@
+package chain
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// RequestStats counts the GET requests made by a Chain.
+type RequestStats struct {
+	// Calls is the number of GET calls made by Chain methods.
+	Calls int64
+
+	// Requests is the number of HTTP requests sent for those calls.
+	Requests int64
+
+	// Coalesced is the number of calls that shared the response of an
+	// identical call already in flight instead of sending a request.
+	Coalesced int64
+}
+
+// flightCall is a GET request in flight that identical calls wait on.
+type flightCall struct {
+	done    chan struct{}
+	body    []byte
+	header  http.Header
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flightGroup coalesces identical concurrent GET requests so that only one
+// HTTP request is in flight per URL.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+
+	numCalls, numRequests, numCoalesced int64
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: map[string]*flightCall{}}
+}
+
+// do returns the result of fetch for key, sharing the result with any
+// concurrent calls for the same key. The shared fetch is not tied to any
+// one caller's context: a caller whose ctx is done stops waiting and
+// returns ctx.Err(), and the fetch itself is only canceled once every
+// waiting caller has given up.
+func (g *flightGroup) do(ctx context.Context, key string,
+	fetch func(context.Context) ([]byte, http.Header, error)) ([]byte,
+	http.Header, error) {
+	atomic.AddInt64(&g.numCalls, 1)
+
+	g.mu.Lock()
+	call, ok := g.calls[key]
+	if ok {
+		call.waiters++
+		atomic.AddInt64(&g.numCoalesced, 1)
+	} else {
+		fetchCtx, cancel := context.WithCancel(context.Background())
+		call = &flightCall{done: make(chan struct{}), waiters: 1,
+			cancel: cancel}
+		g.calls[key] = call
+		atomic.AddInt64(&g.numRequests, 1)
+
+		go func() {
+			call.body, call.header, call.err = fetch(fetchCtx)
+			g.mu.Lock()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			cancel()
+			close(call.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.body, call.header, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// Nobody is waiting so the request is abandoned and later
+			// calls start a new one.
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			call.cancel()
+		}
+		g.mu.Unlock()
+		return nil, nil, ctx.Err()
+	}
+}
+
+func (g *flightGroup) stats() RequestStats {
+	return RequestStats{
+		Calls:     atomic.LoadInt64(&g.numCalls),
+		Requests:  atomic.LoadInt64(&g.numRequests),
+		Coalesced: atomic.LoadInt64(&g.numCoalesced),
+	}
+}
+
+// Stats returns counts of the GET calls made by c and any Chain derived from
+// it with WithContext, including how many were coalesced.
+func (c *Chain) Stats() RequestStats {
+	return c.flights.stats()
+}
+
+// WithContext returns a shallow copy of c whose requests use ctx. Canceling
+// ctx makes calls through the copy return early. Copies share the cache,
+// request coalescing and statistics of c.
+func (c *Chain) WithContext(ctx context.Context) *Chain {
+	if ctx == nil {
+		panic("nil context")
+	}
+	copied := *c
+	copied.ctx = ctx
+	return &copied
+}
+
+// BackendWithContext implements ContextBackend.
+func (c *Chain) BackendWithContext(ctx context.Context) Backend {
+	return c.WithContext(ctx)
+}
+
+func (c *Chain) context() context.Context {
+	if c.ctx != nil {
+		return c.ctx
+	}
+	return context.Background()
+}
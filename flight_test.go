@@ -0,0 +1,125 @@
+package chain_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/qedus/chain"
+)
+
+// slowHandler serves a block after release is closed, counting requests and
+// those canceled before then.
+type slowHandler struct {
+	requests int32
+	canceled int32
+	started  chan struct{}
+	release  chan struct{}
+}
+
+func (h *slowHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.AddInt32(&h.requests, 1) == 1 {
+		close(h.started)
+	}
+	select {
+	case <-h.release:
+	case <-r.Context().Done():
+		atomic.AddInt32(&h.canceled, 1)
+		return
+	}
+	writeTestJSON(w, http.StatusOK,
+		chain.Block{Hash: fakeHash("block", 1), Height: 1})
+}
+
+func newSlowHandler() *slowHandler {
+	return &slowHandler{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+}
+
+func TestCoalescedRequests(t *testing.T) {
+	h := newSlowHandler()
+	c := newTestChain(t, chain.MainNet, h)
+
+	const callers = 5
+	var wg sync.WaitGroup
+	blocks := make([]chain.Block, callers)
+	errs := make([]error, callers)
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			blocks[i], errs[i] = c.GetBlockByHeight(1)
+		}(i)
+	}
+
+	<-h.started
+	for c.Stats().Calls < callers {
+		time.Sleep(time.Millisecond)
+	}
+	close(h.release)
+	wg.Wait()
+
+	for i := range blocks {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if blocks[i].Height != 1 {
+			t.Fatal("unexpected block", blocks[i])
+		}
+	}
+	if n := atomic.LoadInt32(&h.requests); n != 1 {
+		t.Fatal("expected one request", n)
+	}
+	stats := c.Stats()
+	if stats.Requests != 1 || stats.Coalesced != callers-1 {
+		t.Fatal("unexpected stats", stats)
+	}
+
+	// Calls after the first completes are not coalesced with it.
+	if _, err := c.GetBlockByHeight(1); err != nil {
+		t.Fatal(err)
+	}
+	if stats := c.Stats(); stats.Requests != 2 {
+		t.Fatal("expected a new request", stats)
+	}
+}
+
+func TestCoalescedRequestCancel(t *testing.T) {
+	h := newSlowHandler()
+	c := newTestChain(t, chain.MainNet, h)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		_, err := c.WithContext(ctx).GetBlockByHeight(1)
+		canceled <- err
+	}()
+	<-h.started
+
+	waiting := make(chan error)
+	go func() {
+		_, err := c.GetBlockByHeight(1)
+		waiting <- err
+	}()
+	for c.Stats().Coalesced < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Canceling the first caller must not cancel the shared request.
+	cancel()
+	if err := <-canceled; err != context.Canceled {
+		t.Fatal("expected context canceled", err)
+	}
+	close(h.release)
+	if err := <-waiting; err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&h.requests); n != 1 {
+		t.Fatal("expected one request", n)
+	}
+}
@@ -0,0 +1,408 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxReorgDepth is the default number of recent blocks a Follower
+	// remembers and therefore the deepest reorganization it can handle.
+	DefaultMaxReorgDepth = 100
+
+	// DefaultFollowerPollInterval is the default time a Follower waits
+	// between polls of the latest block.
+	DefaultFollowerPollInterval = 30 * time.Second
+)
+
+// ErrReorgTooDeep is returned by a Follower when a chain reorganization forks
+// off before the oldest block it remembers.
+var ErrReorgTooDeep = errors.New("chain reorganization deeper than max reorg depth")
+
+// FollowerEventType is the type of a FollowerEvent.
+type FollowerEventType int
+
+const (
+	// BlockConnected is emitted when a block is added to the tip of the best
+	// chain.
+	BlockConnected FollowerEventType = iota + 1
+
+	// BlockDisconnected is emitted when the tip block is removed from the
+	// best chain because of a reorganization.
+	BlockDisconnected
+)
+
+func (t FollowerEventType) String() string {
+	switch t {
+	case BlockConnected:
+		return "connected"
+	case BlockDisconnected:
+		return "disconnected"
+	}
+	return fmt.Sprintf("FollowerEventType(%d)", int(t))
+}
+
+// FollowerEvent is emitted by a Follower as the best chain changes. Events
+// are always emitted in order: during a reorganization every block above the
+// fork point is disconnected, tip first, before the blocks of the new branch
+// are connected, lowest first.
+type FollowerEvent struct {
+	Type  FollowerEventType
+	Block Block
+}
+
+// BlockRef identifies a block and its position in the chain.
+type BlockRef struct {
+	Hash         string
+	PreviousHash string `json:"previous_block_hash"`
+	Height       int64
+}
+
+func blockRef(b Block) BlockRef {
+	return BlockRef{b.Hash, b.PreviousHash, b.Height}
+}
+
+// Checkpoint is the persisted state of a Follower. Blocks holds the most
+// recent blocks of the followed chain, oldest first, with the tip last.
+type Checkpoint struct {
+	Blocks []BlockRef
+}
+
+// CheckpointStore persists the state of a Follower so that it can resume
+// following after a restart.
+type CheckpointStore interface {
+	// LoadCheckpoint returns the last saved checkpoint or nil if there is
+	// none.
+	LoadCheckpoint() (*Checkpoint, error)
+
+	// SaveCheckpoint replaces the saved checkpoint.
+	SaveCheckpoint(*Checkpoint) error
+}
+
+// MemoryCheckpointStore is a CheckpointStore that keeps the checkpoint in
+// memory. It is useful for tests and for followers that do not need to
+// resume.
+type MemoryCheckpointStore struct {
+	mu         sync.Mutex
+	checkpoint *Checkpoint
+}
+
+// LoadCheckpoint implements CheckpointStore.
+func (s *MemoryCheckpointStore) LoadCheckpoint() (*Checkpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkpoint == nil {
+		return nil, nil
+	}
+	cp := &Checkpoint{append([]BlockRef(nil), s.checkpoint.Blocks...)}
+	return cp, nil
+}
+
+// SaveCheckpoint implements CheckpointStore.
+func (s *MemoryCheckpointStore) SaveCheckpoint(cp *Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoint = &Checkpoint{append([]BlockRef(nil), cp.Blocks...)}
+	return nil
+}
+
+// FileCheckpointStore is a CheckpointStore that keeps the checkpoint as JSON
+// in a file. Saves are atomic so a crash never leaves a partial checkpoint.
+type FileCheckpointStore struct {
+	Path string
+}
+
+// LoadCheckpoint implements CheckpointStore.
+func (s FileCheckpointStore) LoadCheckpoint() (*Checkpoint, error) {
+	cp := &Checkpoint{}
+	if err := readJSONFile(s.Path, cp); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// SaveCheckpoint implements CheckpointStore.
+func (s FileCheckpointStore) SaveCheckpoint(cp *Checkpoint) error {
+	return writeJSONFile(s.Path, cp)
+}
+
+func readJSONFile(path string, v interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return decodeJSON(f, v)
+}
+
+// writeJSONFile atomically replaces the file at path with the JSON encoding
+// of v by writing to a temporary file and renaming it into place.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Follower tracks the best chain by polling GetLatestBlock and walking
+// forward with GetBlockByHeight. Blocks are linked by Hash and PreviousHash
+// so reorganizations are detected and reported as BlockDisconnected and
+// BlockConnected events. The tip is persisted to a CheckpointStore after
+// every event.
+//
+// A Follower is not safe for concurrent use.
+type Follower struct {
+	// MaxReorgDepth is the number of recent blocks remembered. It defaults
+	// to DefaultMaxReorgDepth.
+	MaxReorgDepth int
+
+	// StartHeight is the height following starts from when the store has no
+	// checkpoint. If zero, following starts at the latest block.
+	StartHeight int64
+
+	// PollInterval is the time Run waits between polls. It defaults to
+	// DefaultFollowerPollInterval.
+	PollInterval time.Duration
+
+	chain   *Chain
+	store   CheckpointStore
+	handler func(FollowerEvent) error
+
+	loaded bool
+	recent []Block
+}
+
+// NewFollower creates a Follower that reads blocks from c, persists its
+// state in store and passes every event to handler. If handler returns an
+// error the event is not recorded and will be emitted again on the next
+// poll.
+func NewFollower(c *Chain, store CheckpointStore,
+	handler func(FollowerEvent) error) *Follower {
+	return &Follower{
+		chain:   c,
+		store:   store,
+		handler: handler,
+	}
+}
+
+// Tip returns the current tip of the followed chain. The second return value
+// is false if following has not started.
+func (f *Follower) Tip() (Block, bool) {
+	if len(f.recent) == 0 {
+		return Block{}, false
+	}
+	return f.recent[len(f.recent)-1], true
+}
+
+func (f *Follower) maxReorgDepth() int {
+	if f.MaxReorgDepth > 0 {
+		return f.MaxReorgDepth
+	}
+	return DefaultMaxReorgDepth
+}
+
+func (f *Follower) load() error {
+	if f.loaded {
+		return nil
+	}
+	cp, err := f.store.LoadCheckpoint()
+	if err != nil {
+		return err
+	}
+	if cp != nil {
+		f.recent = make([]Block, len(cp.Blocks))
+		for i, ref := range cp.Blocks {
+			f.recent[i] = Block{
+				Hash:         ref.Hash,
+				PreviousHash: ref.PreviousHash,
+				Height:       ref.Height,
+			}
+		}
+	}
+	f.loaded = true
+	return nil
+}
+
+func (f *Follower) save() error {
+	cp := &Checkpoint{make([]BlockRef, len(f.recent))}
+	for i, b := range f.recent {
+		cp.Blocks[i] = blockRef(b)
+	}
+	return f.store.SaveCheckpoint(cp)
+}
+
+func (f *Follower) connect(b Block) error {
+	if err := f.handler(FollowerEvent{BlockConnected, b}); err != nil {
+		return err
+	}
+	f.recent = append(f.recent, b)
+	if n := len(f.recent) - f.maxReorgDepth(); n > 0 {
+		f.recent = append(f.recent[:0], f.recent[n:]...)
+	}
+	return f.save()
+}
+
+// disconnect removes the tip. Blocks restored from a checkpoint only have
+// their Hash, PreviousHash and Height set.
+func (f *Follower) disconnect() error {
+	tip := f.recent[len(f.recent)-1]
+	if err := f.handler(FollowerEvent{BlockDisconnected, tip}); err != nil {
+		return err
+	}
+	f.recent = f.recent[:len(f.recent)-1]
+	return f.save()
+}
+
+// indexOf returns the position of the block with the given hash in the
+// recent blocks or -1.
+func (f *Follower) indexOf(hash string) int {
+	for i := len(f.recent) - 1; i >= 0; i-- {
+		if f.recent[i].Hash == hash {
+			return i
+		}
+	}
+	return -1
+}
+
+// reorganize switches the followed chain to the branch ending in b by
+// walking back from b to a remembered block, the fork point.
+func (f *Follower) reorganize(b Block) error {
+	branch := []Block{b}
+	fork := f.indexOf(b.PreviousHash)
+	for fork < 0 {
+		last := branch[len(branch)-1]
+		if last.Height <= f.recent[0].Height {
+			// The new branch forks below the oldest remembered block. That
+			// is only recoverable by replacing every remembered block if no
+			// older block was ever connected.
+			if last.Height < f.recent[0].Height ||
+				len(f.recent) >= f.maxReorgDepth() {
+				return ErrReorgTooDeep
+			}
+			break
+		}
+		prev, err := f.chain.GetBlockByHash(branch[len(branch)-1].PreviousHash)
+		if err != nil {
+			return err
+		}
+		branch = append(branch, prev)
+		fork = f.indexOf(prev.PreviousHash)
+	}
+
+	for len(f.recent)-1 > fork {
+		if err := f.disconnect(); err != nil {
+			return err
+		}
+	}
+	for i := len(branch) - 1; i >= 0; i-- {
+		if err := f.connect(branch[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Poll brings the follower up to date with the latest block, emitting any
+// events along the way.
+func (f *Follower) Poll() error {
+	if err := f.load(); err != nil {
+		return err
+	}
+
+	latest, err := f.chain.GetLatestBlock()
+	if err != nil {
+		return err
+	}
+
+	if len(f.recent) == 0 {
+		if f.StartHeight <= 0 || f.StartHeight >= latest.Height {
+			return f.connect(latest)
+		}
+		b, err := f.chain.GetBlockByHeight(uint64(f.StartHeight))
+		if err != nil {
+			return err
+		}
+		if err := f.connect(b); err != nil {
+			return err
+		}
+	}
+
+	tip, _ := f.Tip()
+	if latest.Height <= tip.Height {
+		if f.indexOf(latest.Hash) >= 0 {
+			// Either nothing has changed or the API is lagging behind a
+			// block we have already seen.
+			return nil
+		}
+		return f.reorganize(latest)
+	}
+
+	for height := tip.Height + 1; height <= latest.Height; height++ {
+		var b Block
+		if height == latest.Height {
+			b = latest
+		} else if b, err = f.chain.GetBlockByHeight(uint64(height)); err != nil {
+			return err
+		}
+
+		tip, _ := f.Tip()
+		if b.PreviousHash == tip.Hash {
+			err = f.connect(b)
+		} else {
+			err = f.reorganize(b)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run polls until ctx is done, waiting PollInterval between polls. It
+// returns the first error from Poll or ctx.Err().
+func (f *Follower) Run(ctx context.Context) error {
+	interval := f.PollInterval
+	if interval <= 0 {
+		interval = DefaultFollowerPollInterval
+	}
+
+	for {
+		if err := f.Poll(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package chain_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/qedus/chain"
+)
+
+type eventRecorder struct {
+	events []string
+}
+
+func (r *eventRecorder) handle(e chain.FollowerEvent) error {
+	r.events = append(r.events, fmt.Sprintf("%s %d %.8s",
+		e.Type, e.Block.Height, e.Block.Hash))
+	return nil
+}
+
+func (r *eventRecorder) take() []string {
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func TestFollowerReorg(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 10)
+	c := newTestChain(t, chain.MainNet, api)
+
+	rec := &eventRecorder{}
+	f := chain.NewFollower(c, &chain.MemoryCheckpointStore{}, rec.handle)
+	f.StartHeight = 8
+	if err := f.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if events := rec.take(); len(events) != 3 {
+		t.Fatal("expected 3 connected blocks", events)
+	}
+
+	api.extend(2)
+	if err := f.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if events := rec.take(); len(events) != 2 {
+		t.Fatal("expected 2 connected blocks", events)
+	}
+
+	old := api.tip()
+	api.reorg(3, 4)
+	if err := f.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	events := rec.take()
+	if len(events) != 7 {
+		t.Fatal("expected 3 disconnected and 4 connected blocks", events)
+	}
+	if events[0] != fmt.Sprintf("disconnected 12 %.8s", old.Hash) {
+		t.Fatal("expected old tip disconnected first", events)
+	}
+	if events[3] != fmt.Sprintf("connected 10 %.8s",
+		mustBlock(t, api, "10").Hash) {
+		t.Fatal("expected fork block connected", events)
+	}
+
+	tip, ok := f.Tip()
+	if !ok || tip.Hash != api.tip().Hash {
+		t.Fatal("follower tip does not match best chain")
+	}
+}
+
+func TestFollowerSameHeightReorg(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 5)
+	c := newTestChain(t, chain.MainNet, api)
+
+	rec := &eventRecorder{}
+	f := chain.NewFollower(c, &chain.MemoryCheckpointStore{}, rec.handle)
+	f.StartHeight = 2
+	if err := f.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	rec.take()
+
+	api.reorg(2, 2)
+	if err := f.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if events := rec.take(); len(events) != 4 {
+		t.Fatal("expected 2 disconnected and 2 connected blocks", events)
+	}
+}
+
+func TestFollowerResume(t *testing.T) {
+	api := newFakeAPI(t, chain.TestNet3, 20)
+	c := newTestChain(t, chain.TestNet3, api)
+	store := chain.FileCheckpointStore{
+		Path: filepath.Join(t.TempDir(), "checkpoint.json"),
+	}
+
+	rec := &eventRecorder{}
+	f := chain.NewFollower(c, store, rec.handle)
+	f.StartHeight = 15
+	if err := f.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	rec.take()
+
+	// Reorganize and extend while the follower is not running.
+	api.reorg(2, 5)
+
+	f = chain.NewFollower(c, store, rec.handle)
+	if err := f.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	events := rec.take()
+	if len(events) != 7 {
+		t.Fatal("expected 2 disconnected and 5 connected blocks", events)
+	}
+	if events[0] != fmt.Sprintf("disconnected 20 %.8s",
+		fakeHash("block", 20, 0)) {
+		t.Fatal("expected restored tip disconnected", events)
+	}
+
+	cp, err := store.LoadCheckpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Blocks[len(cp.Blocks)-1].Hash != api.tip().Hash {
+		t.Fatal("checkpoint tip does not match best chain")
+	}
+}
+
+func TestFollowerReorgTooDeep(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 10)
+	c := newTestChain(t, chain.MainNet, api)
+
+	rec := &eventRecorder{}
+	f := chain.NewFollower(c, &chain.MemoryCheckpointStore{}, rec.handle)
+	f.MaxReorgDepth = 3
+	f.StartHeight = 5
+	if err := f.Poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	api.reorg(5, 6)
+	if err := f.Poll(); err != chain.ErrReorgTooDeep {
+		t.Fatal("expected reorg too deep error", err)
+	}
+}
+
+func TestFollowerHandlerError(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 3)
+	c := newTestChain(t, chain.MainNet, api)
+
+	fail := true
+	rec := &eventRecorder{}
+	f := chain.NewFollower(c, &chain.MemoryCheckpointStore{},
+		func(e chain.FollowerEvent) error {
+			if fail && e.Block.Height == 3 {
+				return fmt.Errorf("handler failed")
+			}
+			return rec.handle(e)
+		})
+	f.StartHeight = 1
+
+	if err := f.Poll(); err == nil {
+		t.Fatal("expected handler error")
+	}
+	fail = false
+	if err := f.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if events := rec.take(); len(events) != 3 {
+		t.Fatal("expected block 3 emitted again", events)
+	}
+}
+
+func mustBlock(t *testing.T, api *fakeAPI, id string) chain.Block {
+	b, ok := api.block(id)
+	if !ok {
+		t.Fatal("missing block", id)
+	}
+	return b
+}
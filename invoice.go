@@ -0,0 +1,436 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultInvoiceConfirmations is the default number of confirmations a
+// payment needs before an invoice is settled.
+const DefaultInvoiceConfirmations = 6
+
+// ErrAddressUsed is returned by InvoiceMonitor.Issue when the address of a
+// new invoice has already received funds.
+var ErrAddressUsed = errors.New("address has already received funds")
+
+// InvoiceState is the state of an Invoice.
+type InvoiceState string
+
+const (
+	// InvoicePending is the state of an invoice with no payments.
+	InvoicePending InvoiceState = "pending"
+
+	// InvoiceSeen is the state of an invoice with an unconfirmed payment.
+	InvoiceSeen InvoiceState = "seen"
+
+	// InvoiceConfirmed is the state of an invoice whose payments are all
+	// confirmed but not yet by the required number of blocks.
+	InvoiceConfirmed InvoiceState = "confirmed"
+
+	// InvoicePaid is the state of an invoice paid exactly.
+	InvoicePaid InvoiceState = "paid"
+
+	// InvoiceUnderpaid is the state of an invoice paid less than its amount.
+	// It may still move to another state until it expires.
+	InvoiceUnderpaid InvoiceState = "underpaid"
+
+	// InvoiceOverpaid is the state of an invoice paid more than its amount.
+	InvoiceOverpaid InvoiceState = "overpaid"
+
+	// InvoiceExpired is the state of an invoice that expired with no
+	// payments.
+	InvoiceExpired InvoiceState = "expired"
+)
+
+// InvoicePayment is a transaction paying to the address of an Invoice.
+type InvoicePayment struct {
+	TransactionHash string
+	Amount          Amount
+	Confirmations   int64
+
+	// Seen is when the payment was first seen by the InvoiceMonitor.
+	Seen time.Time
+}
+
+// Invoice is a request for a payment of Amount to Address.
+type Invoice struct {
+	ID      string
+	Address string
+	Amount  Amount
+	Created time.Time
+
+	// Expires is the time after which new payments are ignored. Payments
+	// seen before it are still followed until they confirm. The zero time
+	// means the invoice never expires.
+	Expires time.Time
+
+	// RequiredConfirmations is the number of confirmations every payment
+	// needs before the invoice is paid, underpaid or overpaid.
+	RequiredConfirmations int64
+
+	State InvoiceState
+
+	// Confirmations is the fewest confirmations of any payment, the N of the
+	// confirmed state.
+	Confirmations int64
+
+	// Received is the total of Payments.
+	Received Amount
+	Payments []InvoicePayment
+
+	// Settled is set once the state is final and the invoice is no longer
+	// monitored.
+	Settled bool
+}
+
+func (inv Invoice) expired(now time.Time) bool {
+	return !inv.Expires.IsZero() && !now.Before(inv.Expires)
+}
+
+// update replaces the payments of inv with those in txns, which must be
+// deduplicated, and moves it to its new state.
+func (inv Invoice) update(txns []Transaction, now time.Time) Invoice {
+	seen := make(map[string]time.Time, len(inv.Payments))
+	for _, p := range inv.Payments {
+		seen[p.TransactionHash] = p.Seen
+	}
+
+	// Unconfirmed payments that are no longer returned, for example
+	// because they were double spent, are dropped.
+	payments := []InvoicePayment{}
+	for _, tx := range txns {
+		p := InvoicePayment{
+			TransactionHash: tx.Hash,
+			Confirmations:   tx.Confirmations,
+		}
+		for _, out := range tx.Outputs {
+			if len(out.Addresses) == 1 && out.Addresses[0] == inv.Address {
+				p.Amount += out.Value
+			}
+		}
+		if p.Amount == 0 {
+			continue
+		}
+		var ok bool
+		if p.Seen, ok = seen[tx.Hash]; !ok {
+			if inv.expired(now) {
+				continue
+			}
+			p.Seen = now
+		}
+		payments = append(payments, p)
+	}
+	sort.Slice(payments, func(i, j int) bool {
+		a, b := payments[i], payments[j]
+		if !a.Seen.Equal(b.Seen) {
+			return a.Seen.Before(b.Seen)
+		}
+		return a.TransactionHash < b.TransactionHash
+	})
+
+	inv.Payments, inv.Received, inv.Confirmations = payments, 0, 0
+	for i, p := range payments {
+		inv.Received += p.Amount
+		if i == 0 || p.Confirmations < inv.Confirmations {
+			inv.Confirmations = p.Confirmations
+		}
+	}
+
+	switch {
+	case len(payments) == 0 && inv.expired(now):
+		inv.State, inv.Settled = InvoiceExpired, true
+	case len(payments) == 0:
+		inv.State = InvoicePending
+	case inv.Confirmations == 0:
+		inv.State = InvoiceSeen
+	case inv.Confirmations < inv.RequiredConfirmations:
+		inv.State = InvoiceConfirmed
+	case inv.Received < inv.Amount:
+		inv.State, inv.Settled = InvoiceUnderpaid, inv.expired(now)
+	case inv.Received > inv.Amount:
+		inv.State, inv.Settled = InvoiceOverpaid, true
+	default:
+		inv.State, inv.Settled = InvoicePaid, true
+	}
+	return inv
+}
+
+// InvoiceEvent is delivered when an invoice changes state, and when the
+// confirmations of a confirmed invoice change.
+type InvoiceEvent struct {
+	Invoice  Invoice
+	Previous InvoiceState
+}
+
+// InvoiceStore persists the invoices of an InvoiceMonitor.
+type InvoiceStore interface {
+	// LoadInvoices returns the saved invoices or nil if there are none.
+	LoadInvoices() ([]Invoice, error)
+
+	// SaveInvoices replaces the saved invoices.
+	SaveInvoices([]Invoice) error
+}
+
+// MemoryInvoiceStore is an InvoiceStore that keeps the invoices in memory.
+type MemoryInvoiceStore struct {
+	mu       sync.Mutex
+	invoices []Invoice
+}
+
+// LoadInvoices implements InvoiceStore.
+func (s *MemoryInvoiceStore) LoadInvoices() ([]Invoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Invoice(nil), s.invoices...), nil
+}
+
+// SaveInvoices implements InvoiceStore.
+func (s *MemoryInvoiceStore) SaveInvoices(invoices []Invoice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.invoices = append([]Invoice(nil), invoices...)
+	return nil
+}
+
+// FileInvoiceStore is an InvoiceStore that keeps the invoices as JSON in a
+// file. Saves are atomic.
+type FileInvoiceStore struct {
+	Path string
+}
+
+// LoadInvoices implements InvoiceStore.
+func (s FileInvoiceStore) LoadInvoices() ([]Invoice, error) {
+	invoices := []Invoice{}
+	if err := readJSONFile(s.Path, &invoices); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+// SaveInvoices implements InvoiceStore.
+func (s FileInvoiceStore) SaveInvoices(invoices []Invoice) error {
+	return writeJSONFile(s.Path, invoices)
+}
+
+// InvoiceMonitor issues invoices bound to fresh addresses and follows their
+// payments with GetAddressTransactionsMulti. Each poll moves open invoices
+// through the states pending, seen, confirmed and finally paid, underpaid,
+// overpaid or expired, calling OnEvent for every transition.
+//
+// Events are delivered at least once: if OnEvent returns an error the poll
+// stops without saving and the same events are delivered on the next poll.
+//
+// An InvoiceMonitor is safe for concurrent use.
+type InvoiceMonitor struct {
+	// OnEvent is called for each InvoiceEvent.
+	OnEvent func(InvoiceEvent) error
+
+	// Confirmations is the RequiredConfirmations of new invoices. It
+	// defaults to DefaultInvoiceConfirmations.
+	Confirmations int64
+
+	// Interval is the time Run waits between polls. It defaults to
+	// DefaultPollInterval.
+	Interval time.Duration
+
+	chain *Chain
+	store InvoiceStore
+
+	// polling serializes polls so events are delivered in order.
+	polling sync.Mutex
+
+	mu       sync.Mutex
+	invoices []Invoice
+}
+
+// NewInvoiceMonitor creates an InvoiceMonitor that reads from c and persists
+// its invoices in store. Any previously saved invoices are loaded.
+func NewInvoiceMonitor(c *Chain, store InvoiceStore) (*InvoiceMonitor,
+	error) {
+	invoices, err := store.LoadInvoices()
+	if err != nil {
+		return nil, err
+	}
+	return &InvoiceMonitor{chain: c, store: store, invoices: invoices}, nil
+}
+
+// Issue creates a pending invoice for a payment of amount to address that
+// expires at expires, or never if it is the zero time. The address must not
+// have received funds before, otherwise ErrAddressUsed is returned, and must
+// not belong to an open invoice.
+func (m *InvoiceMonitor) Issue(id, address string, amount Amount,
+	expires time.Time) (Invoice, error) {
+	if amount <= 0 {
+		return Invoice{}, fmt.Errorf("invalid invoice amount %s", amount)
+	}
+	a, err := m.chain.GetAddress(address)
+	if err != nil {
+		return Invoice{}, err
+	}
+	if a.Total.Received > 0 {
+		return Invoice{}, ErrAddressUsed
+	}
+
+	confirmations := m.Confirmations
+	if confirmations <= 0 {
+		confirmations = DefaultInvoiceConfirmations
+	}
+	inv := Invoice{
+		ID:                    id,
+		Address:               address,
+		Amount:                amount,
+		Created:               time.Now().UTC(),
+		Expires:               expires,
+		RequiredConfirmations: confirmations,
+		State:                 InvoicePending,
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, other := range m.invoices {
+		if other.ID == id {
+			return Invoice{}, fmt.Errorf("invoice %s already exists", id)
+		}
+		if other.Address == address && !other.Settled {
+			return Invoice{}, fmt.Errorf("address %s belongs to open invoice %s",
+				address, other.ID)
+		}
+	}
+	invoices := append(append([]Invoice(nil), m.invoices...), inv)
+	if err := m.store.SaveInvoices(invoices); err != nil {
+		return Invoice{}, err
+	}
+	m.invoices = invoices
+	return inv, nil
+}
+
+// Invoice returns the invoice with id.
+func (m *InvoiceMonitor) Invoice(id string) (Invoice, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, inv := range m.invoices {
+		if inv.ID == id {
+			return inv, true
+		}
+	}
+	return Invoice{}, false
+}
+
+// Invoices returns all invoices in the order they were issued.
+func (m *InvoiceMonitor) Invoices() []Invoice {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Invoice(nil), m.invoices...)
+}
+
+// Poll updates every open invoice once, delivering any events.
+func (m *InvoiceMonitor) Poll() error {
+	return m.poll(nil)
+}
+
+// AddressEvent updates the open invoice for the address of e, if any. It can
+// be registered as EventHandlers.Address so that payments are noticed as
+// soon as a notification arrives. Later confirmations are still only
+// followed by Poll.
+func (m *InvoiceMonitor) AddressEvent(e *AddressEvent) error {
+	return m.poll(map[string]bool{e.Address: true})
+}
+
+// poll updates the open invoices, or only those for addresses if it is not
+// nil.
+func (m *InvoiceMonitor) poll(addresses map[string]bool) error {
+	m.polling.Lock()
+	defer m.polling.Unlock()
+
+	m.mu.Lock()
+	open, watched := []Invoice{}, []string{}
+	for _, inv := range m.invoices {
+		if inv.Settled || (addresses != nil && !addresses[inv.Address]) {
+			continue
+		}
+		open = append(open, inv)
+		watched = append(watched, inv.Address)
+	}
+	m.mu.Unlock()
+	if len(open) == 0 {
+		return nil
+	}
+
+	txns, err := m.chain.addressHistory(watched)
+	if err != nil {
+		return err
+	}
+	byAddress, seen := map[string][]Transaction{}, map[string]bool{}
+	for _, tx := range txns {
+		if seen[tx.Hash] {
+			continue
+		}
+		seen[tx.Hash] = true
+		// A transaction may pay an address in several outputs.
+		paid := map[string]bool{}
+		for _, out := range tx.Outputs {
+			if len(out.Addresses) == 1 && !paid[out.Addresses[0]] {
+				a := out.Addresses[0]
+				paid[a] = true
+				byAddress[a] = append(byAddress[a], tx)
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	updated := make(map[string]Invoice, len(open))
+	for _, inv := range open {
+		next := inv.update(byAddress[inv.Address], now)
+		updated[inv.ID] = next
+		if next.State == inv.State && (next.State != InvoiceConfirmed ||
+			next.Confirmations == inv.Confirmations) {
+			continue
+		}
+		if m.OnEvent != nil {
+			if err := m.OnEvent(InvoiceEvent{next, inv.State}); err != nil {
+				return err
+			}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	invoices := append([]Invoice(nil), m.invoices...)
+	for i, inv := range invoices {
+		if next, ok := updated[inv.ID]; ok {
+			invoices[i] = next
+		}
+	}
+	if err := m.store.SaveInvoices(invoices); err != nil {
+		return err
+	}
+	m.invoices = invoices
+	return nil
+}
+
+// Run polls until ctx is done, waiting Interval between polls. It returns
+// the first error from Poll or ctx.Err().
+func (m *InvoiceMonitor) Run(ctx context.Context) error {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	for {
+		if err := m.Poll(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
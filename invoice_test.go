@@ -0,0 +1,153 @@
+package chain_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/qedus/chain"
+)
+
+func newTestInvoiceMonitor(t *testing.T, c *chain.Chain,
+	store chain.InvoiceStore) (*chain.InvoiceMonitor, *[]string) {
+	m, err := chain.NewInvoiceMonitor(c, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Confirmations = 3
+	events := []string{}
+	m.OnEvent = func(e chain.InvoiceEvent) error {
+		events = append(events, fmt.Sprintf("%s %s->%s(%d) %d", e.Invoice.ID,
+			e.Previous, e.Invoice.State, e.Invoice.Confirmations,
+			e.Invoice.Received))
+		return nil
+	}
+	return m, &events
+}
+
+func pollEvents(t *testing.T, m *chain.InvoiceMonitor, events *[]string,
+	expected ...string) {
+	*events = (*events)[:0]
+	if err := m.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(*events) != fmt.Sprint(expected) {
+		t.Fatalf("unexpected events %q, expected %q", *events, expected)
+	}
+}
+
+func TestInvoiceMonitor(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 10)
+	c := newTestChain(t, chain.MainNet, api)
+	path := filepath.Join(t.TempDir(), "invoices.json")
+	m, events := newTestInvoiceMonitor(t, c,
+		chain.FileInvoiceStore{Path: path})
+
+	if _, err := m.Issue("inv-1", testAddress, 5000, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	pollEvents(t, m, events)
+
+	tx := payment("invoice", otherAddress, testAddress, 5000)
+	api.addTransaction(tx)
+	pollEvents(t, m, events, "inv-1 pending->seen(0) 5000")
+	pollEvents(t, m, events)
+
+	api.mine(tx)
+	pollEvents(t, m, events, "inv-1 seen->confirmed(1) 5000")
+	api.extend(1)
+	pollEvents(t, m, events, "inv-1 confirmed->confirmed(2) 5000")
+
+	// The state survives a restart.
+	m, events = newTestInvoiceMonitor(t, c, chain.FileInvoiceStore{Path: path})
+	api.extend(1)
+	pollEvents(t, m, events, "inv-1 confirmed->paid(3) 5000")
+
+	// Settled invoices are no longer polled.
+	before := api.requestCount("/v2/bitcoin/addresses/" + testAddress +
+		"/transactions")
+	pollEvents(t, m, events)
+	if api.requestCount("/v2/bitcoin/addresses/"+testAddress+
+		"/transactions") != before {
+		t.Fatal("settled invoice polled")
+	}
+	inv, ok := m.Invoice("inv-1")
+	if !ok || !inv.Settled || len(inv.Payments) != 1 ||
+		inv.Payments[0].TransactionHash != tx.Hash {
+		t.Fatal("unexpected invoice", inv)
+	}
+}
+
+func TestInvoiceUnderpaid(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 10)
+	c := newTestChain(t, chain.MainNet, api)
+	m, events := newTestInvoiceMonitor(t, c, &chain.MemoryInvoiceStore{})
+	m.Confirmations = 1
+
+	if _, err := m.Issue("inv-1", testAddress, 5000,
+		time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	api.mine(payment("part1", otherAddress, testAddress, 3000))
+	pollEvents(t, m, events, "inv-1 pending->underpaid(1) 3000")
+
+	// A double spent payment disappears.
+	spent := payment("part2", otherAddress, testAddress, 3000)
+	api.addTransaction(spent)
+	pollEvents(t, m, events, "inv-1 underpaid->seen(0) 6000")
+	api.mu.Lock()
+	delete(api.txns, spent.Hash)
+	api.order = api.order[:len(api.order)-1]
+	api.mu.Unlock()
+	pollEvents(t, m, events, "inv-1 seen->underpaid(1) 3000")
+
+	api.mine(payment("part3", otherAddress, testAddress, 3000))
+	pollEvents(t, m, events, "inv-1 underpaid->overpaid(1) 6000")
+}
+
+func TestInvoiceExpired(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 10)
+	c := newTestChain(t, chain.MainNet, api)
+	m, events := newTestInvoiceMonitor(t, c, &chain.MemoryInvoiceStore{})
+
+	if _, err := m.Issue("inv-1", testAddress, 5000,
+		time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	api.mine(payment("late", otherAddress, testAddress, 5000))
+	pollEvents(t, m, events, "inv-1 pending->expired(0) 0")
+
+	// The address is free again once the invoice is settled, but it has
+	// now been used.
+	if _, err := m.Issue("inv-2", testAddress, 5000,
+		time.Time{}); err != chain.ErrAddressUsed {
+		t.Fatal("expected address used", err)
+	}
+	if _, err := m.Issue("inv-1", otherAddress, 5000,
+		time.Time{}); err == nil {
+		t.Fatal("expected duplicate invoice error")
+	}
+}
+
+func TestInvoiceMultipleOutputs(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 10)
+	c := newTestChain(t, chain.MainNet, api)
+	m, events := newTestInvoiceMonitor(t, c, &chain.MemoryInvoiceStore{})
+	m.Confirmations = 1
+
+	if _, err := m.Issue("inv-1", testAddress, 3000, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	// One transaction pays the invoice in two outputs.
+	tx := payment("split", otherAddress, testAddress, 1000)
+	tx.Outputs = append(tx.Outputs, chain.Output{Value: 2000,
+		Addresses: []string{testAddress}})
+	api.mine(tx)
+	pollEvents(t, m, events, "inv-1 pending->paid(1) 3000")
+
+	inv, _ := m.Invoice("inv-1")
+	if len(inv.Payments) != 1 || inv.Payments[0].Amount != 3000 {
+		t.Fatal("unexpected payments", inv.Payments)
+	}
+}
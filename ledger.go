@@ -0,0 +1,312 @@
+package chain
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistoryTruncatedError is returned by AddressLedger on a Chain using a
+// Backend that is not a HistoryBackend when an address fills a whole call
+// with transactions. Such backends cannot page through transactions so its
+// full history may not have been read.
+type HistoryTruncatedError struct {
+	Address string
+}
+
+func (e *HistoryTruncatedError) Error() string {
+	return fmt.Sprintf("address %s has at least %d transactions",
+		e.Address, MaxAddressTransactionsLimit)
+}
+
+// LedgerEntry is the movement of funds on a set of addresses caused by one
+// transaction.
+type LedgerEntry struct {
+	TransactionHash string
+	BlockHash       string
+	BlockHeight     int64
+
+	// BlockTime is the zero time for unconfirmed transactions.
+	BlockTime     time.Time
+	Confirmations int64
+
+	// Credit is the value of outputs paying to the addresses.
+	Credit Amount
+
+	// Debit is the value of inputs spending from the addresses.
+	Debit Amount
+
+	// Fee is the part of the transaction fee paid by the addresses, in
+	// proportion to their share of the input value. It is included in
+	// Debit.
+	Fee Amount
+
+	// Addresses are the addresses of the set involved in the transaction.
+	Addresses []string
+}
+
+// Net returns Credit minus Debit.
+func (e LedgerEntry) Net() Amount {
+	return e.Credit - e.Debit
+}
+
+// LedgerColumns are the columns written by WriteLedgerCSV and the keys
+// written by WriteLedgerJSONLines, in order. Amounts are in satoshis and
+// times in RFC 3339 format, empty for unconfirmed transactions.
+var LedgerColumns = []string{
+	"transaction_hash",
+	"block_height",
+	"block_hash",
+	"block_time",
+	"confirmations",
+	"credit",
+	"debit",
+	"fee",
+	"net",
+	"addresses",
+}
+
+// NewLedgerEntries computes the ledger of addresses from txns, oldest first
+// with unconfirmed transactions last. Transactions not involving the
+// addresses are skipped and duplicates are removed.
+func NewLedgerEntries(txns []Transaction, addresses []string) []LedgerEntry {
+	set := make(map[string]bool, len(addresses))
+	for _, a := range addresses {
+		set[a] = true
+	}
+
+	entries, seen := []LedgerEntry{}, map[string]bool{}
+	for _, tx := range txns {
+		if seen[tx.Hash] {
+			continue
+		}
+		seen[tx.Hash] = true
+
+		e := LedgerEntry{
+			TransactionHash: tx.Hash,
+			BlockHash:       tx.BlockHash,
+			BlockHeight:     tx.BlockHeight,
+			BlockTime:       tx.BlockTime,
+			Confirmations:   tx.Confirmations,
+		}
+		involved := map[string]bool{}
+		totalIn := Amount(0)
+		for _, in := range tx.Inputs {
+			totalIn += in.Value
+			if a, ok := firstIn(in.Addresses, set); ok {
+				e.Debit += in.Value
+				involved[a] = true
+			}
+		}
+		for _, out := range tx.Outputs {
+			if a, ok := firstIn(out.Addresses, set); ok {
+				e.Credit += out.Value
+				involved[a] = true
+			}
+		}
+		if len(involved) == 0 {
+			continue
+		}
+		for _, a := range addresses {
+			if involved[a] {
+				e.Addresses = append(e.Addresses, a)
+				involved[a] = false
+			}
+		}
+		if totalIn > 0 && e.Debit > 0 {
+			// The product can overflow an int64.
+			fee := new(big.Int).Mul(big.NewInt(int64(tx.Fees)),
+				big.NewInt(int64(e.Debit)))
+			e.Fee = Amount(fee.Quo(fee, big.NewInt(int64(totalIn))).Int64())
+		}
+		entries = append(entries, e)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if (a.Confirmations == 0) != (b.Confirmations == 0) {
+			return b.Confirmations == 0
+		}
+		return a.BlockHeight < b.BlockHeight
+	})
+	return entries
+}
+
+// firstIn returns the first of addresses in set.
+func firstIn(addresses []string, set map[string]bool) (string, bool) {
+	for _, a := range addresses {
+		if set[a] {
+			return a, true
+		}
+	}
+	return "", false
+}
+
+// AddressLedger reads the full transaction history of addresses and returns
+// their ledger. On a Chain using a Backend that cannot page, a
+// *HistoryTruncatedError is returned if a single address has
+// MaxAddressTransactionsLimit transactions or more.
+func (c *Chain) AddressLedger(addresses []string) ([]LedgerEntry, error) {
+	txns, err := c.addressHistory(addresses)
+	if err != nil {
+		return nil, err
+	}
+	return NewLedgerEntries(txns, addresses), nil
+}
+
+// GetAddressHistory returns every transaction involving addresses, without
+// duplicates, newest first with unconfirmed transactions first. It implements
+// HistoryBackend.
+func (c *Chain) GetAddressHistory(addresses []string) ([]Transaction, error) {
+	txns, err := c.addressHistory(addresses)
+	if err != nil {
+		return nil, err
+	}
+	return mergeTransactions([][]Transaction{txns}, len(txns)), nil
+}
+
+// addressHistory reads the full transaction history of addresses, following
+// the pages of the address transactions endpoint. Addresses are queried in
+// groups and transactions involving several groups are returned once for
+// each.
+func (c *Chain) addressHistory(addresses []string) ([]Transaction, error) {
+	if c.backend != nil {
+		if hb, ok := c.backend.(HistoryBackend); ok {
+			txns, err := hb.GetAddressHistory(addresses)
+			if err != ErrNotSupported {
+				return txns, err
+			}
+		}
+		return c.backendAddressHistory(addresses)
+	}
+	txns := []Transaction{}
+	for _, chunk := range addressChunks(addresses) {
+		url := c.addressTransactionsURL(chunk, MaxAddressTransactionsLimit)
+		for url != "" {
+			page := []Transaction{}
+			next, err := c.httpGetJSONPage(url, &page)
+			if err != nil {
+				return nil, err
+			}
+			txns = append(txns, page...)
+			url = next
+		}
+	}
+	return txns, nil
+}
+
+// backendAddressHistory is addressHistory for backends that cannot page and
+// so return at most one call of transactions. Groups of addresses are split
+// further when they fill a call.
+func (c *Chain) backendAddressHistory(addresses []string) ([]Transaction,
+	error) {
+	txns := []Transaction{}
+	pending := addressChunks(addresses)
+	for len(pending) > 0 {
+		chunk := pending[0]
+		pending = pending[1:]
+
+		t, err := c.GetAddressTransactionsMulti(chunk,
+			MaxAddressTransactionsLimit)
+		if err != nil {
+			return nil, err
+		}
+		if len(t) < MaxAddressTransactionsLimit {
+			txns = append(txns, t...)
+			continue
+		}
+		if len(chunk) == 1 {
+			return nil, &HistoryTruncatedError{chunk[0]}
+		}
+		half := len(chunk) / 2
+		pending = append(pending, chunk[:half], chunk[half:])
+	}
+	return txns, nil
+}
+
+func (e LedgerEntry) blockTime() string {
+	if e.BlockTime.IsZero() {
+		return ""
+	}
+	return e.BlockTime.UTC().Format(time.RFC3339)
+}
+
+// record returns the values of e in LedgerColumns order.
+func (e LedgerEntry) record() []string {
+	return []string{
+		e.TransactionHash,
+		strconv.FormatInt(e.BlockHeight, 10),
+		e.BlockHash,
+		e.blockTime(),
+		strconv.FormatInt(e.Confirmations, 10),
+		strconv.FormatInt(int64(e.Credit), 10),
+		strconv.FormatInt(int64(e.Debit), 10),
+		strconv.FormatInt(int64(e.Fee), 10),
+		strconv.FormatInt(int64(e.Net()), 10),
+		strings.Join(e.Addresses, " "),
+	}
+}
+
+// WriteLedgerCSV writes entries as CSV with a header row of LedgerColumns.
+// Multiple addresses are separated by spaces.
+func WriteLedgerCSV(w io.Writer, entries []LedgerEntry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(LedgerColumns); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := cw.Write(e.record()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ledgerJSON is a LedgerEntry as written by WriteLedgerJSONLines. Its fields
+// are in LedgerColumns order.
+type ledgerJSON struct {
+	TransactionHash string   `json:"transaction_hash"`
+	BlockHeight     int64    `json:"block_height"`
+	BlockHash       string   `json:"block_hash"`
+	BlockTime       string   `json:"block_time"`
+	Confirmations   int64    `json:"confirmations"`
+	Credit          Amount   `json:"credit"`
+	Debit           Amount   `json:"debit"`
+	Fee             Amount   `json:"fee"`
+	Net             Amount   `json:"net"`
+	Addresses       []string `json:"addresses"`
+}
+
+// WriteLedgerJSONLines writes each entry as a JSON object on its own line
+// with the keys in LedgerColumns.
+func WriteLedgerJSONLines(w io.Writer, entries []LedgerEntry) error {
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		addresses := e.Addresses
+		if addresses == nil {
+			addresses = []string{}
+		}
+		if err := enc.Encode(ledgerJSON{
+			TransactionHash: e.TransactionHash,
+			BlockHeight:     e.BlockHeight,
+			BlockHash:       e.BlockHash,
+			BlockTime:       e.blockTime(),
+			Confirmations:   e.Confirmations,
+			Credit:          e.Credit,
+			Debit:           e.Debit,
+			Fee:             e.Fee,
+			Net:             e.Net(),
+			Addresses:       addresses,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
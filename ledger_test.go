@@ -0,0 +1,193 @@
+package chain_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qedus/chain"
+)
+
+func TestNewLedgerEntries(t *testing.T) {
+	received := payment("in", otherAddress, testAddress, 5000)
+	received.BlockHeight, received.Confirmations = 10, 3
+	received.BlockTime = time.Date(2014, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// A payment out funded half by testAddress.
+	sent := chain.Transaction{
+		Hash: fakeHash("tx", "out"),
+		Inputs: []chain.Input{
+			{Value: 3000, Addresses: []string{testAddress}},
+			{Value: 3000, Addresses: []string{"1other"}},
+		},
+		Outputs: []chain.Output{
+			{Value: 4999, Addresses: []string{otherAddress}},
+			{Value: 1000, Addresses: []string{testAddress}},
+		},
+		Fees: 1,
+	}
+	unrelated := payment("unrelated", otherAddress, "1other", 1)
+
+	entries := chain.NewLedgerEntries([]chain.Transaction{sent, unrelated,
+		received, received}, []string{testAddress})
+	if len(entries) != 2 {
+		t.Fatal("unexpected entries", entries)
+	}
+	if e := entries[0]; e.TransactionHash != received.Hash ||
+		e.Credit != 5000 || e.Debit != 0 || e.Fee != 0 || e.Net() != 5000 {
+		t.Fatal("unexpected receive entry", e)
+	}
+	// The unconfirmed payment sorts last and pays half of the fee, rounded
+	// down.
+	if e := entries[1]; e.TransactionHash != sent.Hash || e.Credit != 1000 ||
+		e.Debit != 3000 || e.Fee != 0 || e.Net() != -2000 {
+		t.Fatal("unexpected send entry", e)
+	}
+
+	sent.Fees = 1000
+	entries = chain.NewLedgerEntries([]chain.Transaction{sent},
+		[]string{testAddress})
+	if entries[0].Fee != 500 {
+		t.Fatal("expected half the fee", entries[0].Fee)
+	}
+}
+
+func TestWriteLedger(t *testing.T) {
+	entries := []chain.LedgerEntry{{
+		TransactionHash: "aa",
+		BlockHash:       "bb",
+		BlockHeight:     10,
+		BlockTime:       time.Date(2014, 6, 1, 12, 0, 0, 0, time.UTC),
+		Confirmations:   3,
+		Credit:          5000,
+		Debit:           2000,
+		Fee:             100,
+		Addresses:       []string{testAddress, otherAddress},
+	}, {
+		TransactionHash: "cc",
+		Credit:          1,
+		Addresses:       []string{testAddress},
+	}}
+
+	buf := &bytes.Buffer{}
+	if err := chain.WriteLedgerCSV(buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join(chain.LedgerColumns, ",") + "\n" +
+		"aa,10,bb,2014-06-01T12:00:00Z,3,5000,2000,100,3000," +
+		testAddress + " " + otherAddress + "\n" +
+		"cc,0,,,0,1,0,0,1," + testAddress + "\n"
+	if buf.String() != expected {
+		t.Fatal("unexpected CSV", buf.String())
+	}
+
+	buf.Reset()
+	if err := chain.WriteLedgerJSONLines(buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal("unexpected JSON lines", buf.String())
+	}
+	record := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if len(record) != len(chain.LedgerColumns) {
+		t.Fatal("unexpected keys", record)
+	}
+	for _, column := range chain.LedgerColumns {
+		if _, ok := record[column]; !ok {
+			t.Fatal("missing key", column)
+		}
+	}
+	if record["net"] != 3000.0 || record["block_time"] != "2014-06-01T12:00:00Z" {
+		t.Fatal("unexpected record", record)
+	}
+}
+
+func TestAddressLedger(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 1)
+	c := newTestChain(t, chain.MainNet, api)
+	for i := 0; i < 3; i++ {
+		api.mine(payment(fmt.Sprint(i), otherAddress, testAddress, 1000))
+	}
+
+	entries, err := c.AddressLedger([]string{testAddress})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].BlockHeight != 2 ||
+		entries[2].BlockHeight != 4 {
+		t.Fatal("unexpected entries", entries)
+	}
+	if n := api.requestCount("/v2/bitcoin/addresses/" + testAddress +
+		"/transactions"); n != 1 {
+		t.Fatal("unexpected requests", n)
+	}
+}
+
+func TestAddressLedgerPages(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 1)
+	c := newTestChain(t, chain.MainNet, api)
+	for i := 0; i <= chain.MaxAddressTransactionsLimit; i++ {
+		api.addTransaction(payment(fmt.Sprint(i), otherAddress, testAddress, 1))
+	}
+
+	entries, err := c.AddressLedger([]string{testAddress})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != chain.MaxAddressTransactionsLimit+1 {
+		t.Fatal("unexpected entries", len(entries))
+	}
+	if n := api.requestCount("/v2/bitcoin/addresses/" + testAddress +
+		"/transactions"); n != 2 {
+		t.Fatal("expected two pages", n)
+	}
+}
+
+// pagelessBackend hides the HistoryBackend method of a Backend.
+type pagelessBackend struct {
+	chain.Backend
+}
+
+func TestAddressLedgerBackendPages(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 1)
+	for i := 0; i < chain.MaxAddressTransactionsLimit; i++ {
+		api.addTransaction(payment(fmt.Sprint(i), otherAddress, testAddress, 1))
+	}
+	backend := newTestChain(t, chain.MainNet, api)
+	for _, b := range []chain.Backend{backend,
+		chain.NewCompositeBackend(pagelessBackend{backend}, backend)} {
+		c := chain.NewWithBackend(b, chain.MainNet)
+		entries, err := c.AddressLedger([]string{otherAddress, testAddress})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != chain.MaxAddressTransactionsLimit {
+			t.Fatal("unexpected entries", len(entries))
+		}
+	}
+}
+
+func TestAddressLedgerTruncated(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 1)
+	c := chain.NewWithBackend(pagelessBackend{newTestChain(t, chain.MainNet,
+		api)}, chain.MainNet)
+	for i := 0; i < chain.MaxAddressTransactionsLimit; i++ {
+		api.addTransaction(payment(fmt.Sprint(i), otherAddress, testAddress, 1))
+	}
+
+	_, err := c.AddressLedger([]string{otherAddress, testAddress})
+	truncated, ok := err.(*chain.HistoryTruncatedError)
+	if !ok {
+		t.Fatal("expected truncated history", err)
+	}
+	if truncated.Address != otherAddress {
+		t.Fatal("unexpected address", truncated.Address)
+	}
+}
@@ -0,0 +1,402 @@
+package chain
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FiatAmount is an amount of a fiat currency in hundredths of its unit, for
+// example cents.
+type FiatAmount int64
+
+func (f FiatAmount) String() string {
+	sign, v := "", int64(f)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+// ParseFiatAmount parses a decimal such as "43251.5" into a FiatAmount,
+// rounding to the nearest hundredth.
+func ParseFiatAmount(s string) (FiatAmount, error) {
+	if strings.ContainsAny(s, "/eE") {
+		return 0, fmt.Errorf("invalid fiat amount %q", s)
+	}
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return 0, fmt.Errorf("invalid fiat amount %q", s)
+	}
+	r.Mul(r, big.NewRat(100, 1))
+	rounded := roundRat(r)
+	if !rounded.IsInt64() {
+		return 0, fmt.Errorf("fiat amount %q out of range", s)
+	}
+	return FiatAmount(rounded.Int64()), nil
+}
+
+// roundRat rounds r to the nearest integer, halves away from zero.
+func roundRat(r *big.Rat) *big.Int {
+	num, denom := new(big.Int).Set(r.Num()), r.Denom()
+	half := new(big.Int).Quo(denom, big.NewInt(2))
+	if num.Sign() < 0 {
+		num.Sub(num, half)
+	} else {
+		num.Add(num, half)
+	}
+	return num.Quo(num, denom)
+}
+
+// proportion returns f * part / whole, rounded.
+func proportion(f FiatAmount, part, whole Amount) FiatAmount {
+	r := new(big.Rat).SetFrac(
+		new(big.Int).Mul(big.NewInt(int64(f)), big.NewInt(int64(part))),
+		big.NewInt(int64(whole)))
+	return FiatAmount(roundRat(r).Int64())
+}
+
+// fiatValue returns the value of a at price per bitcoin.
+func fiatValue(price FiatAmount, a Amount) FiatAmount {
+	return proportion(price, a, BTC)
+}
+
+// ErrNoPrice is returned when a price is needed for a time before the first
+// price in a PriceSeries.
+var ErrNoPrice = errors.New("no price for time")
+
+// PricePoint is the price of one bitcoin from a time onwards.
+type PricePoint struct {
+	Time  time.Time
+	Price FiatAmount
+}
+
+// PriceSeries is a series of bitcoin prices in time order. The price at a
+// time is the last price at or before it.
+type PriceSeries []PricePoint
+
+// ReadPriceSeries reads a price series from CSV with a time column and a
+// price column, the price of one bitcoin. Times are in RFC 3339 format or
+// dates in the form 2006-01-02. A header row is skipped if its price column
+// is not a number. Rows may be in any order.
+func ReadPriceSeries(r io.Reader) (PriceSeries, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	series := PriceSeries{}
+	for i, record := range records {
+		price, err := ParseFiatAmount(record[1])
+		if err != nil {
+			if i == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		t, err := time.Parse(time.RFC3339, record[0])
+		if err != nil {
+			t, err = time.Parse("2006-01-02", record[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid time %q", i+1, record[0])
+		}
+		series = append(series, PricePoint{t, price})
+	}
+	sort.SliceStable(series, func(i, j int) bool {
+		return series[i].Time.Before(series[j].Time)
+	})
+	return series, nil
+}
+
+// PriceAt returns the price at t.
+func (s PriceSeries) PriceAt(t time.Time) (FiatAmount, error) {
+	i := sort.Search(len(s), func(i int) bool {
+		return s[i].Time.After(t)
+	})
+	if i == 0 {
+		return 0, ErrNoPrice
+	}
+	return s[i-1].Price, nil
+}
+
+// LotMethod selects which lots a disposal is taken from.
+type LotMethod int
+
+const (
+	// FIFO disposes of the earliest acquired lots first.
+	FIFO LotMethod = iota
+
+	// LIFO disposes of the latest acquired lots first.
+	LIFO
+
+	// HIFO disposes of the lots with the highest price first.
+	HIFO
+)
+
+func (m LotMethod) String() string {
+	switch m {
+	case FIFO:
+		return "FIFO"
+	case LIFO:
+		return "LIFO"
+	case HIFO:
+		return "HIFO"
+	}
+	return fmt.Sprintf("LotMethod(%d)", int(m))
+}
+
+// Lot is bitcoin acquired by one transaction.
+type Lot struct {
+	TransactionHash string
+	Acquired        time.Time
+	Amount          Amount
+
+	// Price is the price of one bitcoin when the lot was acquired.
+	Price FiatAmount
+
+	// CostBasis is the value of Amount when the lot was acquired.
+	CostBasis FiatAmount
+
+	// Remaining is the part of Amount not yet disposed of, with a cost
+	// basis of RemainingCostBasis.
+	Remaining          Amount
+	RemainingCostBasis FiatAmount
+
+	// RealizedGain is the total gain of the disposals from this lot.
+	RealizedGain FiatAmount
+
+	// UnrealizedGain is the value of Remaining at the report price less
+	// RemainingCostBasis.
+	UnrealizedGain FiatAmount
+}
+
+// Disposal is the part of a disposing transaction taken from one lot.
+type Disposal struct {
+	TransactionHash string
+	Disposed        time.Time
+
+	// LotTransactionHash identifies the lot disposed of.
+	LotTransactionHash string
+	Acquired           time.Time
+
+	Amount    Amount
+	Proceeds  FiatAmount
+	CostBasis FiatAmount
+	Gain      FiatAmount
+}
+
+// LotReport is the result of TrackLots.
+type LotReport struct {
+	Method LotMethod
+
+	// AsOf is the time of the price used for unrealized gains, Price.
+	AsOf  time.Time
+	Price FiatAmount
+
+	// Lots are all lots in order of acquisition, including those fully
+	// disposed of.
+	Lots      []Lot
+	Disposals []Disposal
+
+	RealizedGain   FiatAmount
+	UnrealizedGain FiatAmount
+}
+
+// InsufficientLotsError is returned by TrackLots when a transaction disposes
+// of more bitcoin than is held.
+type InsufficientLotsError struct {
+	TransactionHash string
+	Missing         Amount
+}
+
+func (e *InsufficientLotsError) Error() string {
+	return fmt.Sprintf("transaction %s disposes of %s more than is held",
+		e.TransactionHash, e.Missing)
+}
+
+// orderBlock orders the transactions of one block, sorted by hash, so that
+// each comes after those whose outputs it spends, as coins can be received
+// and spent in the same block. Otherwise acquisitions come before disposals.
+func orderBlock(block []WalletTransaction) {
+	pending := append([]WalletTransaction(nil), block...)
+	unprocessed := map[string]bool{}
+	for _, wt := range pending {
+		unprocessed[wt.Transaction.Hash] = true
+	}
+	for i := range block {
+		next := -1
+		for j, wt := range pending {
+			if spendsAny(wt.Transaction, unprocessed) {
+				continue
+			}
+			if next < 0 || (wt.Net > 0 && pending[next].Net < 0) {
+				next = j
+			}
+		}
+		// Transactions in a block cannot spend each other in a cycle, but
+		// keep going if the history says they do.
+		if next < 0 {
+			next = 0
+		}
+		block[i] = pending[next]
+		delete(unprocessed, pending[next].Transaction.Hash)
+		pending = append(pending[:next], pending[next+1:]...)
+	}
+}
+
+// spendsAny reports whether tx spends an output of a transaction in hashes.
+func spendsAny(tx Transaction, hashes map[string]bool) bool {
+	for _, in := range tx.Inputs {
+		if in.OutputHash != tx.Hash && hashes[in.OutputHash] {
+			return true
+		}
+	}
+	return false
+}
+
+// TrackLots assigns the transactions in history, as returned by
+// Wallet.History, to lots. Transactions with a positive Net acquire a lot
+// and those with a negative Net, including fees, dispose of bitcoin from
+// lots chosen by method. Transactions in the same block are taken in the
+// order they spend each other. Unconfirmed transactions are ignored. Values
+// are taken from prices at each transaction's block time, and unrealized
+// gains use the price at asOf.
+func TrackLots(history []WalletTransaction, prices PriceSeries,
+	method LotMethod, asOf time.Time) (*LotReport, error) {
+	txns := []WalletTransaction{}
+	for _, wt := range history {
+		if wt.Transaction.Confirmations > 0 && wt.Net != 0 {
+			txns = append(txns, wt)
+		}
+	}
+	sort.SliceStable(txns, func(i, j int) bool {
+		a, b := txns[i].Transaction, txns[j].Transaction
+		if a.BlockHeight != b.BlockHeight {
+			return a.BlockHeight < b.BlockHeight
+		}
+		return a.Hash < b.Hash
+	})
+	for start := 0; start < len(txns); {
+		end := start + 1
+		for end < len(txns) && txns[end].Transaction.BlockHeight ==
+			txns[start].Transaction.BlockHeight {
+			end++
+		}
+		orderBlock(txns[start:end])
+		start = end
+	}
+
+	report := &LotReport{Method: method, AsOf: asOf}
+	for _, wt := range txns {
+		tx := wt.Transaction
+		price, err := prices.PriceAt(tx.BlockTime)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %s: %v", tx.Hash, err)
+		}
+		if wt.Net > 0 {
+			cost := fiatValue(price, wt.Net)
+			report.Lots = append(report.Lots, Lot{
+				TransactionHash:    tx.Hash,
+				Acquired:           tx.BlockTime,
+				Amount:             wt.Net,
+				Price:              price,
+				CostBasis:          cost,
+				Remaining:          wt.Net,
+				RemainingCostBasis: cost,
+			})
+			continue
+		}
+		if err := report.dispose(tx, -wt.Net, price); err != nil {
+			return nil, err
+		}
+	}
+
+	price, err := prices.PriceAt(asOf)
+	if err != nil {
+		return nil, err
+	}
+	report.Price = price
+	for i := range report.Lots {
+		lot := &report.Lots[i]
+		lot.UnrealizedGain = fiatValue(price, lot.Remaining) -
+			lot.RemainingCostBasis
+		report.UnrealizedGain += lot.UnrealizedGain
+	}
+	return report, nil
+}
+
+// dispose takes amount from the open lots in the order of the method.
+func (r *LotReport) dispose(tx Transaction, amount Amount,
+	price FiatAmount) error {
+	open := []int{}
+	for i, lot := range r.Lots {
+		if lot.Remaining > 0 {
+			open = append(open, i)
+		}
+	}
+	sort.SliceStable(open, func(i, j int) bool {
+		a, b := r.Lots[open[i]], r.Lots[open[j]]
+		switch r.Method {
+		case LIFO:
+			return open[i] > open[j]
+		case HIFO:
+			return a.Price > b.Price
+		}
+		return open[i] < open[j]
+	})
+
+	// Proceeds are split between lots in proportion to the amounts taken,
+	// with the last lot taking any rounding difference.
+	proceeds := fiatValue(price, amount)
+	remainingProceeds, left := proceeds, amount
+	for _, i := range open {
+		if left == 0 {
+			break
+		}
+		lot := &r.Lots[i]
+		taken := lot.Remaining
+		if taken > left {
+			taken = left
+		}
+
+		cost := lot.RemainingCostBasis
+		if taken < lot.Remaining {
+			cost = proportion(lot.RemainingCostBasis, taken, lot.Remaining)
+		}
+		share := remainingProceeds
+		if taken < left {
+			share = proportion(proceeds, taken, amount)
+		}
+
+		d := Disposal{
+			TransactionHash:    tx.Hash,
+			Disposed:           tx.BlockTime,
+			LotTransactionHash: lot.TransactionHash,
+			Acquired:           lot.Acquired,
+			Amount:             taken,
+			Proceeds:           share,
+			CostBasis:          cost,
+			Gain:               share - cost,
+		}
+		r.Disposals = append(r.Disposals, d)
+		lot.Remaining -= taken
+		lot.RemainingCostBasis -= cost
+		lot.RealizedGain += d.Gain
+		r.RealizedGain += d.Gain
+		remainingProceeds -= share
+		left -= taken
+	}
+	if left > 0 {
+		return &InsufficientLotsError{tx.Hash, left}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package chain_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/qedus/chain"
+)
+
+const testPrices = `time,price
+2014-01-03,300
+2014-01-01,100
+2014-01-02,200.005
+2014-01-04T00:00:00Z,400
+`
+
+func day(d int) time.Time {
+	return time.Date(2014, 1, d, 12, 0, 0, 0, time.UTC)
+}
+
+// lotHistory buys 1 BTC on days 1 and 2 then sells 1.5 BTC on day 3, newest
+// first as Wallet.History returns it.
+func lotHistory() []chain.WalletTransaction {
+	tx := func(name string, d int, net chain.Amount) chain.WalletTransaction {
+		return chain.WalletTransaction{
+			Transaction: chain.Transaction{
+				Hash:          name,
+				BlockHeight:   int64(d),
+				BlockTime:     day(d),
+				Confirmations: 1,
+			},
+			Net: net,
+		}
+	}
+	unconfirmed := tx("pending", 0, 5*chain.BTC)
+	unconfirmed.Transaction.Confirmations = 0
+	return []chain.WalletTransaction{
+		unconfirmed,
+		tx("sell", 3, -3*chain.BTC/2),
+		tx("buy2", 2, chain.BTC),
+		tx("buy1", 1, chain.BTC),
+	}
+}
+
+func TestReadPriceSeries(t *testing.T) {
+	prices, err := chain.ReadPriceSeries(strings.NewReader(testPrices))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prices) != 4 {
+		t.Fatal("unexpected prices", prices)
+	}
+	for _, c := range []struct {
+		t     time.Time
+		price string
+	}{
+		{day(1), "100.00"},
+		{day(2), "200.01"},
+		{time.Date(2014, 1, 3, 0, 0, 0, 0, time.UTC), "300.00"},
+		{day(30), "400.00"},
+	} {
+		price, err := prices.PriceAt(c.t)
+		if err != nil || price.String() != c.price {
+			t.Fatal("unexpected price", c.t, price, err)
+		}
+	}
+	if _, err := prices.PriceAt(time.Date(2013, 1, 1, 0, 0, 0, 0,
+		time.UTC)); err != chain.ErrNoPrice {
+		t.Fatal("expected no price", err)
+	}
+
+	if _, err := chain.ReadPriceSeries(strings.NewReader(
+		"2014-01-01,1\nyesterday,2\n")); err == nil {
+		t.Fatal("expected invalid time error")
+	}
+}
+
+func TestTrackLots(t *testing.T) {
+	prices, err := chain.ReadPriceSeries(strings.NewReader(testPrices))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		method     chain.LotMethod
+		realized   string
+		unrealized string
+		remaining  []chain.Amount
+	}{
+		// Sells all of buy1 at a gain of 200 and half of buy2 at 49.99.
+		{chain.FIFO, "249.99", "100.00", []chain.Amount{0, chain.BTC / 2}},
+		// Sells all of buy2 and half of buy1.
+		{chain.LIFO, "199.99", "150.00", []chain.Amount{chain.BTC / 2, 0}},
+		{chain.HIFO, "199.99", "150.00", []chain.Amount{chain.BTC / 2, 0}},
+	} {
+		report, err := chain.TrackLots(lotHistory(), prices, c.method, day(4))
+		if err != nil {
+			t.Fatal(c.method, err)
+		}
+		if report.RealizedGain.String() != c.realized ||
+			report.UnrealizedGain.String() != c.unrealized {
+			t.Fatal(c.method, "unexpected gains", report.RealizedGain,
+				report.UnrealizedGain)
+		}
+		if len(report.Lots) != 2 || report.Lots[0].TransactionHash != "buy1" {
+			t.Fatal(c.method, "unexpected lots", report.Lots)
+		}
+		realized := chain.FiatAmount(0)
+		for i, lot := range report.Lots {
+			if lot.Remaining != c.remaining[i] {
+				t.Fatal(c.method, "unexpected remaining", i, lot.Remaining)
+			}
+			realized += lot.RealizedGain
+		}
+		if realized != report.RealizedGain {
+			t.Fatal(c.method, "lot gains do not add up", realized)
+		}
+		proceeds := chain.FiatAmount(0)
+		for _, d := range report.Disposals {
+			proceeds += d.Proceeds
+		}
+		if proceeds.String() != "450.00" {
+			t.Fatal(c.method, "unexpected proceeds", proceeds)
+		}
+	}
+}
+
+func TestTrackLotsInsufficient(t *testing.T) {
+	prices, err := chain.ReadPriceSeries(strings.NewReader(testPrices))
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := lotHistory()[1:2]
+	_, err = chain.TrackLots(history, prices, chain.FIFO, day(4))
+	insufficient, ok := err.(*chain.InsufficientLotsError)
+	if !ok || insufficient.TransactionHash != "sell" ||
+		insufficient.Missing != 3*chain.BTC/2 {
+		t.Fatal("expected insufficient lots", err)
+	}
+}
+
+func TestTrackLotsSameBlock(t *testing.T) {
+	prices, err := chain.ReadPriceSeries(strings.NewReader(testPrices))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := func(name string, net chain.Amount,
+		spends ...string) chain.WalletTransaction {
+		wt := chain.WalletTransaction{
+			Transaction: chain.Transaction{
+				Hash:          name,
+				BlockHeight:   100,
+				BlockTime:     day(2),
+				Confirmations: 1,
+			},
+			Net: net,
+		}
+		for _, hash := range spends {
+			wt.Transaction.Inputs = append(wt.Transaction.Inputs,
+				chain.Input{OutputHash: hash})
+		}
+		return wt
+	}
+
+	// The disposal sorts before the transaction funding it.
+	for _, history := range [][]chain.WalletTransaction{
+		{tx("aa", -chain.BTC/2000, "bb"), tx("bb", chain.BTC/1000)},
+		{tx("aa", -chain.BTC/2000), tx("bb", chain.BTC/1000)},
+	} {
+		report, err := chain.TrackLots(history, prices, chain.FIFO, day(4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Disposals) != 1 ||
+			report.Lots[0].Remaining != chain.BTC/2000 {
+			t.Fatal("unexpected report", report)
+		}
+	}
+
+	// A received coin spent in the same block funds the next acquisition.
+	history := []chain.WalletTransaction{
+		tx("aa", chain.BTC/1000, "cc"),
+		tx("bb", chain.BTC/1000),
+		tx("cc", -chain.BTC/1000, "bb"),
+	}
+	report, err := chain.TrackLots(history, prices, chain.FIFO, day(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Lots) != 2 || report.Lots[0].TransactionHash != "bb" ||
+		report.Lots[1].TransactionHash != "aa" {
+		t.Fatal("unexpected lots", report.Lots)
+	}
+}
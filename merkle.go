@@ -0,0 +1,449 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrMerkleProof is returned when a merkle proof does not verify against the
+// expected merkle root.
+var ErrMerkleProof = errors.New("merkle proof does not match merkle root")
+
+// MerkleProof is a merkle branch proving that a transaction is included in a
+// block. It can be verified independently of the Chain.com API against a
+// trusted block header merkle root.
+type MerkleProof struct {
+	// TransactionHash is the hash of the proven transaction.
+	TransactionHash string
+
+	// Index is the position of the transaction within its block.
+	Index uint32
+
+	// Branch holds the sibling hashes from the transaction up to, but not
+	// including, the merkle root.
+	Branch []string
+}
+
+// merkleParent returns the hash of an interior merkle tree node.
+func merkleParent(left, right [hashSize]byte) [hashSize]byte {
+	var b [2 * hashSize]byte
+	copy(b[:hashSize], left[:])
+	copy(b[hashSize:], right[:])
+	return doubleSHA256(b[:])
+}
+
+func blockTransactionHashes(b Block) ([][hashSize]byte, error) {
+	if len(b.TransactionHashes) == 0 {
+		return nil, errors.New("block has no transaction hashes")
+	}
+	hashes := make([][hashSize]byte, len(b.TransactionHashes))
+	for i, s := range b.TransactionHashes {
+		h, err := hashFromHex(s)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = h
+	}
+	return hashes, nil
+}
+
+// NewMerkleProof builds the merkle branch for the transaction with the given
+// hash from a block's TransactionHashes. If the block has a MerkleRoot the
+// proof is checked against it before being returned.
+func NewMerkleProof(b Block, txHash string) (*MerkleProof, error) {
+	hashes, err := blockTransactionHashes(b)
+	if err != nil {
+		return nil, err
+	}
+
+	index := -1
+	for i, h := range b.TransactionHashes {
+		if h == txHash {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, fmt.Errorf("transaction %s not in block %s", txHash, b.Hash)
+	}
+
+	proof := &MerkleProof{TransactionHash: txHash, Index: uint32(index)}
+	for level, pos := hashes, index; len(level) > 1; pos /= 2 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		proof.Branch = append(proof.Branch, hashToHex(level[pos^1]))
+
+		next := make([][hashSize]byte, len(level)/2)
+		for i := range next {
+			next[i] = merkleParent(level[2*i], level[2*i+1])
+		}
+		level = next
+	}
+
+	if b.MerkleRoot != "" {
+		if err := proof.Verify(b.MerkleRoot); err != nil {
+			return nil, err
+		}
+	}
+	return proof, nil
+}
+
+// Root computes the merkle root implied by the proof.
+func (p *MerkleProof) Root() (string, error) {
+	h, err := hashFromHex(p.TransactionHash)
+	if err != nil {
+		return "", err
+	}
+
+	index := p.Index
+	for _, s := range p.Branch {
+		sibling, err := hashFromHex(s)
+		if err != nil {
+			return "", err
+		}
+		if index&1 == 0 {
+			h = merkleParent(h, sibling)
+		} else {
+			h = merkleParent(sibling, h)
+		}
+		index >>= 1
+	}
+	if index != 0 {
+		return "", errors.New("merkle proof index out of range for branch")
+	}
+	return hashToHex(h), nil
+}
+
+// Verify checks the proof against merkleRoot, which should come from a block
+// header the caller trusts.
+func (p *MerkleProof) Verify(merkleRoot string) error {
+	root, err := p.Root()
+	if err != nil {
+		return err
+	}
+	if root != merkleRoot {
+		return ErrMerkleProof
+	}
+	return nil
+}
+
+// MarshalBinary encodes the proof in a compact format: the 32 byte
+// transaction hash, the index as a little endian uint32, then the branch as a
+// CompactSize count followed by 32 byte hashes. Hashes are in internal byte
+// order.
+func (p *MerkleProof) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, hashSize+4+1+len(p.Branch)*hashSize)
+
+	h, err := hashFromHex(p.TransactionHash)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, h[:]...)
+	b = binary.LittleEndian.AppendUint32(b, p.Index)
+
+	b = writeVarInt(b, uint64(len(p.Branch)))
+	for _, s := range p.Branch {
+		h, err := hashFromHex(s)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, h[:]...)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a proof encoded with MarshalBinary.
+func (p *MerkleProof) UnmarshalBinary(data []byte) error {
+	r := &wireReader{b: data}
+	txHash := r.readHash()
+	index := r.readUint32()
+	branch := make([]string, r.readCount(hashSize))
+	for i := range branch {
+		branch[i] = hashToHex(r.readHash())
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.b) != 0 {
+		return errors.New("trailing data after merkle proof")
+	}
+
+	p.TransactionHash = hashToHex(txHash)
+	p.Index = index
+	p.Branch = branch
+	return nil
+}
+
+// PartialMerkleTree is the BIP37 partial merkle tree encoding used in
+// merkleblock messages. It proves the inclusion of any number of
+// transactions in a block in a single structure.
+//
+// Specification can be found here
+// https://github.com/bitcoin/bips/blob/master/bip-0037.mediawiki#partial-merkle-branch-format.
+type PartialMerkleTree struct {
+	// Transactions is the total number of transactions in the block.
+	Transactions uint32
+
+	// Hashes are the tree node hashes in depth first order.
+	Hashes []string
+
+	// Flags are the traversal flag bits packed least significant bit first.
+	Flags []byte
+}
+
+// maxBlockTransactions bounds the number of transactions a partial merkle
+// tree may claim, based on the smallest possible transaction in a maximum
+// weight block.
+const maxBlockTransactions = 4000000 / 240
+
+type partialMerkleBuilder struct {
+	txns    [][hashSize]byte
+	matches []bool
+	hashes  [][hashSize]byte
+	bits    []bool
+}
+
+func treeWidth(n uint32, height uint) uint32 {
+	return uint32((uint64(n) + (1 << height) - 1) >> height)
+}
+
+func treeHeight(n uint32) uint {
+	height := uint(0)
+	for treeWidth(n, height) > 1 {
+		height++
+	}
+	return height
+}
+
+func (b *partialMerkleBuilder) hash(height uint, pos uint32) [hashSize]byte {
+	if height == 0 {
+		return b.txns[pos]
+	}
+	left := b.hash(height-1, pos*2)
+	right := left
+	if pos*2+1 < treeWidth(uint32(len(b.txns)), height-1) {
+		right = b.hash(height-1, pos*2+1)
+	}
+	return merkleParent(left, right)
+}
+
+func (b *partialMerkleBuilder) build(height uint, pos uint32) {
+	n := uint32(len(b.txns))
+	parentOfMatch := false
+	for p := pos << height; p < (pos+1)<<height && p < n; p++ {
+		if b.matches[p] {
+			parentOfMatch = true
+			break
+		}
+	}
+	b.bits = append(b.bits, parentOfMatch)
+
+	if height == 0 || !parentOfMatch {
+		b.hashes = append(b.hashes, b.hash(height, pos))
+		return
+	}
+	b.build(height-1, pos*2)
+	if pos*2+1 < treeWidth(n, height-1) {
+		b.build(height-1, pos*2+1)
+	}
+}
+
+// NewPartialMerkleTree builds a BIP37 partial merkle tree for a block that
+// proves the inclusion of the transactions in matches. If the block has a
+// MerkleRoot the tree is checked against it before being returned.
+func NewPartialMerkleTree(b Block, matches []string) (*PartialMerkleTree,
+	error) {
+	hashes, err := blockTransactionHashes(b)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, len(matches))
+	for _, m := range matches {
+		wanted[m] = true
+	}
+	builder := &partialMerkleBuilder{
+		txns:    hashes,
+		matches: make([]bool, len(hashes)),
+	}
+	for i, h := range b.TransactionHashes {
+		if wanted[h] {
+			builder.matches[i] = true
+			delete(wanted, h)
+		}
+	}
+	for h := range wanted {
+		return nil, fmt.Errorf("transaction %s not in block %s", h, b.Hash)
+	}
+
+	n := uint32(len(hashes))
+	builder.build(treeHeight(n), 0)
+
+	tree := &PartialMerkleTree{
+		Transactions: n,
+		Hashes:       make([]string, len(builder.hashes)),
+		Flags:        make([]byte, (len(builder.bits)+7)/8),
+	}
+	for i, h := range builder.hashes {
+		tree.Hashes[i] = hashToHex(h)
+	}
+	for i, bit := range builder.bits {
+		if bit {
+			tree.Flags[i/8] |= 1 << uint(i%8)
+		}
+	}
+
+	if b.MerkleRoot != "" {
+		root, _, err := tree.Extract()
+		if err != nil {
+			return nil, err
+		}
+		if root != b.MerkleRoot {
+			return nil, ErrMerkleProof
+		}
+	}
+	return tree, nil
+}
+
+type partialMerkleExtractor struct {
+	n        uint32
+	hashes   [][hashSize]byte
+	flags    []byte
+	bitsUsed int
+	hashUsed int
+	matches  []string
+	err      error
+}
+
+func (e *partialMerkleExtractor) extract(height uint,
+	pos uint32) [hashSize]byte {
+	var h [hashSize]byte
+	if e.err != nil {
+		return h
+	}
+	if e.bitsUsed >= len(e.flags)*8 {
+		e.err = errors.New("partial merkle tree overflowed its flag bits")
+		return h
+	}
+	flag := e.flags[e.bitsUsed/8]&(1<<uint(e.bitsUsed%8)) != 0
+	e.bitsUsed++
+
+	if height == 0 || !flag {
+		if e.hashUsed >= len(e.hashes) {
+			e.err = errors.New("partial merkle tree overflowed its hashes")
+			return h
+		}
+		h = e.hashes[e.hashUsed]
+		e.hashUsed++
+		if height == 0 && flag {
+			e.matches = append(e.matches, hashToHex(h))
+		}
+		return h
+	}
+
+	left := e.extract(height-1, pos*2)
+	right := left
+	if pos*2+1 < treeWidth(e.n, height-1) {
+		right = e.extract(height-1, pos*2+1)
+		if e.err == nil && right == left {
+			// Guards against CVE-2012-2459 style duplicate transactions.
+			e.err = errors.New("partial merkle tree has duplicate siblings")
+		}
+	}
+	return merkleParent(left, right)
+}
+
+// Extract validates the tree and returns the merkle root it commits to along
+// with the hashes of the matched transactions. The root must be compared to
+// a trusted block header by the caller.
+func (t *PartialMerkleTree) Extract() (string, []string, error) {
+	switch {
+	case t.Transactions == 0:
+		return "", nil, errors.New("partial merkle tree has no transactions")
+	case t.Transactions > maxBlockTransactions:
+		return "", nil, errors.New("partial merkle tree has too many transactions")
+	case uint32(len(t.Hashes)) > t.Transactions:
+		return "", nil, errors.New("partial merkle tree has more hashes than transactions")
+	case len(t.Flags)*8 < len(t.Hashes):
+		return "", nil, errors.New("partial merkle tree has fewer flag bits than hashes")
+	}
+
+	e := &partialMerkleExtractor{
+		n:      t.Transactions,
+		hashes: make([][hashSize]byte, len(t.Hashes)),
+		flags:  t.Flags,
+	}
+	for i, s := range t.Hashes {
+		h, err := hashFromHex(s)
+		if err != nil {
+			return "", nil, err
+		}
+		e.hashes[i] = h
+	}
+
+	root := e.extract(treeHeight(t.Transactions), 0)
+	switch {
+	case e.err != nil:
+		return "", nil, e.err
+	case (e.bitsUsed+7)/8 != len(t.Flags):
+		return "", nil, errors.New("partial merkle tree has unused flag bytes")
+	case e.hashUsed != len(e.hashes):
+		return "", nil, errors.New("partial merkle tree has unused hashes")
+	}
+	return hashToHex(root), e.matches, nil
+}
+
+// Verify checks that the tree commits to merkleRoot and returns the hashes of
+// the matched transactions.
+func (t *PartialMerkleTree) Verify(merkleRoot string) ([]string, error) {
+	root, matches, err := t.Extract()
+	if err != nil {
+		return nil, err
+	}
+	if root != merkleRoot {
+		return nil, ErrMerkleProof
+	}
+	return matches, nil
+}
+
+// MarshalBinary encodes the tree in the BIP37 wire format: the transaction
+// count as a little endian uint32, the CompactSize prefixed hashes and the
+// CompactSize prefixed flag bytes.
+func (t *PartialMerkleTree) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(binary.LittleEndian.AppendUint32(nil, t.Transactions))
+	buf.Write(writeVarInt(nil, uint64(len(t.Hashes))))
+	for _, s := range t.Hashes {
+		h, err := hashFromHex(s)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(h[:])
+	}
+	buf.Write(writeVarInt(nil, uint64(len(t.Flags))))
+	buf.Write(t.Flags)
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a tree in the BIP37 wire format.
+func (t *PartialMerkleTree) UnmarshalBinary(data []byte) error {
+	r := &wireReader{b: data}
+	n := r.readUint32()
+	hashes := make([]string, r.readCount(hashSize))
+	for i := range hashes {
+		hashes[i] = hashToHex(r.readHash())
+	}
+	flags := append([]byte(nil), r.readVarBytes()...)
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.b) != 0 {
+		return errors.New("trailing data after partial merkle tree")
+	}
+
+	t.Transactions, t.Hashes, t.Flags = n, hashes, flags
+	return nil
+}
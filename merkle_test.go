@@ -0,0 +1,167 @@
+package chain_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/qedus/chain"
+)
+
+// block100000 is Bitcoin MainNet block 100000 which has four transactions.
+var block100000 = chain.Block{
+	Hash:       "000000000003ba27aa200b1cecaad478d2b00432346c3f1f3986da1afd33e506",
+	MerkleRoot: "f3e94742aca4b5ef85488dc37c06c3282295ffec960994b2c0d5ac2a25a95766",
+	TransactionHashes: []string{
+		"8c14f0db3df150123e6f3dbbf30f8b955a8249b62ac1d1ff16284aefa3d06d87",
+		"fff2525b8931402dd09222c50775608f75787bd2b87e56995a7bdd30f79702c4",
+		"6359f0868171b1d194cbee1af2f16ea598ae8fad666d9b012c8ed2b79a236ec4",
+		"e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d",
+	},
+}
+
+func TestMerkleProof(t *testing.T) {
+	for _, txHash := range block100000.TransactionHashes {
+		proof, err := chain.NewMerkleProof(block100000, txHash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(proof.Branch) != 2 {
+			t.Fatal("expected branch of 2 hashes", len(proof.Branch))
+		}
+
+		data, err := proof.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := &chain.MerkleProof{}
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.Verify(block100000.MerkleRoot); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMerkleProofTampered(t *testing.T) {
+	proof, err := chain.NewMerkleProof(block100000,
+		block100000.TransactionHashes[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof.Index = 3
+	if err := proof.Verify(block100000.MerkleRoot); err != chain.ErrMerkleProof {
+		t.Fatal("expected merkle proof error", err)
+	}
+
+	if _, err := chain.NewMerkleProof(block100000, "00"); err == nil {
+		t.Fatal("expected error for missing transaction")
+	}
+
+	bad := block100000
+	bad.MerkleRoot = block100000.TransactionHashes[0]
+	if _, err := chain.NewMerkleProof(bad,
+		block100000.TransactionHashes[0]); err != chain.ErrMerkleProof {
+		t.Fatal("expected merkle proof error", err)
+	}
+}
+
+// syntheticBlock creates a block with n transactions and fills in its merkle
+// root.
+func syntheticBlock(t *testing.T, n int) chain.Block {
+	b := chain.Block{Hash: fmt.Sprintf("synthetic-%d", n)}
+	for i := 0; i < n; i++ {
+		h := sha256.Sum256([]byte{byte(i), byte(i >> 8)})
+		b.TransactionHashes = append(b.TransactionHashes,
+			hex.EncodeToString(h[:]))
+	}
+	tree, err := chain.NewPartialMerkleTree(b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.MerkleRoot, _, err = tree.Extract(); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPartialMerkleTree(t *testing.T) {
+	tree, err := chain.NewPartialMerkleTree(block100000,
+		[]string{block100000.TransactionHashes[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &chain.PartialMerkleTree{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	matches, err := decoded.Verify(block100000.MerkleRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0] != block100000.TransactionHashes[1] {
+		t.Fatal("unexpected matches", matches)
+	}
+
+	for _, n := range []int{1, 2, 3, 7, 12, 33} {
+		b := syntheticBlock(t, n)
+		matched := []string{}
+		for i := 0; i < n; i += 3 {
+			matched = append(matched, b.TransactionHashes[i])
+		}
+		tree, err := chain.NewPartialMerkleTree(b, matched)
+		if err != nil {
+			t.Fatal(n, err)
+		}
+		matches, err := tree.Verify(b.MerkleRoot)
+		if err != nil {
+			t.Fatal(n, err)
+		}
+		if fmt.Sprint(matches) != fmt.Sprint(matched) {
+			t.Fatal(n, "unexpected matches", matches)
+		}
+
+		for _, txHash := range matched {
+			proof, err := chain.NewMerkleProof(b, txHash)
+			if err != nil {
+				t.Fatal(n, err)
+			}
+			if err := proof.Verify(b.MerkleRoot); err != nil {
+				t.Fatal(n, err)
+			}
+		}
+	}
+}
+
+func TestPartialMerkleTreeMalformed(t *testing.T) {
+	tree, err := chain.NewPartialMerkleTree(block100000,
+		block100000.TransactionHashes[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extra := *tree
+	extra.Flags = append(append([]byte(nil), tree.Flags...), 0)
+	if _, err := extra.Verify(block100000.MerkleRoot); err == nil {
+		t.Fatal("expected error for unused flag bytes")
+	}
+
+	short := *tree
+	short.Hashes = tree.Hashes[:len(tree.Hashes)-1]
+	if _, err := short.Verify(block100000.MerkleRoot); err == nil {
+		t.Fatal("expected error for missing hashes")
+	}
+
+	if err := (&chain.PartialMerkleTree{}).UnmarshalBinary(
+		[]byte{1, 0, 0, 0, 0xff}); err == nil {
+		t.Fatal("expected error for truncated data")
+	}
+}
@@ -0,0 +1,325 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Messages to P2PKH addresses are signed in the legacy "Bitcoin Signed
+// Message" format of Bitcoin Core's signmessage. Messages to P2WPKH and P2TR
+// addresses use BIP 322 simple signatures.
+//
+// Signing uses the big.Int secp256k1 code in secp256k1.go, which is not
+// constant time. The time taken to sign depends on the private key and nonce,
+// so an attacker able to time many signatures may recover the key. Sign only
+// where nobody else can measure signing times, for example not on a shared
+// host or behind a network service that signs on request, and prefer the
+// wallet holding the key where possible. Verification only handles public
+// data and is safe anywhere.
+
+// ErrInvalidSignature is returned when a message signature is well formed
+// but was not made by the key of the address.
+var ErrInvalidSignature = errors.New("message signature does not match address")
+
+const messageMagic = "Bitcoin Signed Message:\n"
+
+// legacyMessageHash returns the hash signed by legacy message signatures.
+func legacyMessageHash(message string) []byte {
+	b := writeVarInt(nil, uint64(len(messageMagic)))
+	b = append(b, messageMagic...)
+	b = writeVarInt(b, uint64(len(message)))
+	b = append(b, message...)
+	h := doubleSHA256(b)
+	return h[:]
+}
+
+// bip322Transaction returns the BIP 322 to_sign transaction, without its
+// witness, that proves script signed message, and the output it spends.
+func bip322Transaction(script []byte, message string) (*RawTransaction,
+	RawOutput, error) {
+	hash := taggedHash("BIP0322-signed-message", []byte(message))
+	toSpend := &RawTransaction{
+		Inputs: []RawInput{{
+			PreviousHash:  strings.Repeat("0", 2*hashSize),
+			PreviousIndex: 0xffffffff,
+			ScriptSig:     pushData([]byte{op0}, hash),
+		}},
+		Outputs: []RawOutput{{Value: 0, Script: script}},
+	}
+	toSpendHash, err := toSpend.Hash()
+	if err != nil {
+		return nil, RawOutput{}, err
+	}
+	toSign := &RawTransaction{
+		Inputs: []RawInput{{
+			PreviousHash: toSpendHash,
+			ScriptSig:    []byte{},
+		}},
+		Outputs: []RawOutput{{Value: 0, Script: []byte{opReturn}}},
+	}
+	return toSign, toSpend.Outputs[0], nil
+}
+
+// p2pkhScriptCode is the BIP 143 script code of a P2WPKH output.
+func p2pkhScriptCode(keyHash []byte) []byte {
+	s := append([]byte{opDup, opHash160, 20}, keyHash...)
+	return append(s, opEqualVerify, opCheckSig)
+}
+
+// parseWIF decodes a private key in wallet import format for network n. It
+// returns the key and whether its public key is compressed.
+func parseWIF(wif string, n Network) (*big.Int, bool, error) {
+	params, ok := networks[n]
+	if !ok {
+		return nil, false, fmt.Errorf("unknown network %q", n)
+	}
+	version, payload, err := base58CheckDecode(wif)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid private key: %v", err)
+	}
+	if version != params.privateKey {
+		return nil, false, fmt.Errorf("private key is not for %s", n)
+	}
+	compressed := len(payload) == 33 && payload[32] == 1
+	if len(payload) != 32 && !compressed {
+		return nil, false, errors.New("invalid private key length")
+	}
+	d := new(big.Int).SetBytes(payload[:32])
+	if d.Sign() == 0 || d.Cmp(curveN) >= 0 {
+		return nil, false, errors.New("private key out of range")
+	}
+	return d, compressed, nil
+}
+
+// encodeWitness serializes a witness stack as in a BIP 322 simple signature.
+func encodeWitness(witness [][]byte) string {
+	b := writeVarInt(nil, uint64(len(witness)))
+	for _, item := range witness {
+		b = writeVarInt(b, uint64(len(item)))
+		b = append(b, item...)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// signingKey decodes wif and address and returns the key, its public key,
+// whether it is compressed and the decoded address.
+func signingKey(wif, address string, n Network) (*big.Int, *curvePoint, bool,
+	*DecodedAddress, error) {
+	a, err := DecodeAddress(address, n)
+	if err != nil {
+		return nil, nil, false, nil, err
+	}
+	d, compressed, err := parseWIF(wif, n)
+	if err != nil {
+		return nil, nil, false, nil, err
+	}
+	return d, pointMul(curveG, d), compressed, a, nil
+}
+
+// SignMessage signs message with the private key wif, in wallet import
+// format, in the legacy "Bitcoin Signed Message" format proving ownership of
+// the P2PKH address on network n. The signature is base64 encoded.
+//
+// Signing is not constant time; see the caveat at the top of message.go.
+func SignMessage(wif, address, message string, n Network) (string, error) {
+	d, pub, compressed, a, err := signingKey(wif, address, n)
+	if err != nil {
+		return "", err
+	}
+	if a.Type != PubKeyHashScript {
+		return "", fmt.Errorf("legacy message signatures need a P2PKH "+
+			"address, use SignMessageBIP322 for %s address %s", a.Type,
+			address)
+	}
+	if !bytes.Equal(hash160(pub.serialize(compressed)), a.Program) {
+		return "", fmt.Errorf("private key is not the key of address %s",
+			address)
+	}
+	r, s, recovery := signECDSA(d, legacyMessageHash(message), false)
+	header := 27 + recovery
+	if compressed {
+		header += 4
+	}
+	sig := append([]byte{header}, scalarBytes(r)...)
+	return base64.StdEncoding.EncodeToString(append(sig,
+		scalarBytes(s)...)), nil
+}
+
+// SignMessage signs message with the private key wif in the legacy format
+// proving ownership of the P2PKH address on the network of c.
+func (c *Chain) SignMessage(wif, address, message string) (string, error) {
+	return SignMessage(wif, address, message, c.network)
+}
+
+// SignMessageBIP322 signs message with the private key wif, in wallet import
+// format, as a BIP 322 simple signature proving ownership of the P2WPKH or
+// P2TR address on network n. The signature is base64 encoded.
+//
+// Signing is not constant time; see the caveat at the top of message.go.
+func SignMessageBIP322(wif, address, message string, n Network) (string,
+	error) {
+	d, pub, _, a, err := signingKey(wif, address, n)
+	if err != nil {
+		return "", err
+	}
+	errKey := fmt.Errorf("private key is not the key of address %s", address)
+
+	switch a.Type {
+	case WitnessV0KeyHashScript:
+		key := pub.serialize(true)
+		if !bytes.Equal(hash160(key), a.Program) {
+			return "", errKey
+		}
+		tx, prevout, err := bip322Transaction(a.Script(), message)
+		if err != nil {
+			return "", err
+		}
+		hash, err := witnessV0Sighash(tx, 0, p2pkhScriptCode(a.Program),
+			prevout.Value)
+		if err != nil {
+			return "", err
+		}
+		r, s, _ := signECDSA(d, hash, true)
+		sig := append(encodeDER(r, s), sigHashAll)
+		return encodeWitness([][]byte{sig, key}), nil
+
+	case WitnessV1TaprootScript:
+		output, tweak := taprootTweak(pub)
+		if !bytes.Equal(scalarBytes(output.x), a.Program) {
+			return "", errKey
+		}
+		if pub.y.Bit(0) == 1 {
+			d = new(big.Int).Sub(curveN, d)
+		}
+		d = d.Add(d, tweak).Mod(d, curveN)
+		tx, prevout, err := bip322Transaction(a.Script(), message)
+		if err != nil {
+			return "", err
+		}
+		hash, err := taprootSighash(tx, 0, []RawOutput{prevout},
+			sigHashDefault)
+		if err != nil {
+			return "", err
+		}
+		aux := make([]byte, 32)
+		if _, err := rand.Read(aux); err != nil {
+			return "", err
+		}
+		return encodeWitness([][]byte{signSchnorr(d, hash, aux)}), nil
+	}
+	return "", fmt.Errorf("BIP 322 simple signatures need a P2WPKH or P2TR "+
+		"address, use SignMessage for %s address %s", a.Type, address)
+}
+
+// SignMessageBIP322 signs message with the private key wif as a BIP 322
+// simple signature proving ownership of address on the network of c.
+func (c *Chain) SignMessageBIP322(wif, address, message string) (string,
+	error) {
+	return SignMessageBIP322(wif, address, message, c.network)
+}
+
+// VerifyMessage checks that signature, base64 encoded, is a signature of
+// message by the key of address on network n. It accepts legacy signatures
+// for P2PKH addresses and BIP 322 simple signatures for P2WPKH and P2TR
+// addresses. A well formed signature made by another key returns
+// ErrInvalidSignature.
+func VerifyMessage(address, message, signature string, n Network) error {
+	a, err := DecodeAddress(address, n)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+
+	if a.Type == PubKeyHashScript {
+		if len(sig) != 65 || sig[0] < 27 || sig[0] > 34 {
+			return errors.New("invalid legacy message signature")
+		}
+		recovery, compressed := (sig[0]-27)&3, sig[0] >= 31
+		pub, err := recoverPubKey(legacyMessageHash(message),
+			new(big.Int).SetBytes(sig[1:33]), new(big.Int).SetBytes(sig[33:]),
+			recovery)
+		if err != nil ||
+			!bytes.Equal(hash160(pub.serialize(compressed)), a.Program) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+
+	r := &wireReader{b: sig}
+	witness := make([][]byte, r.readCount(1))
+	for i := range witness {
+		witness[i] = r.readVarBytes()
+	}
+	if r.err != nil || len(r.b) != 0 {
+		return errors.New("invalid BIP 322 signature witness")
+	}
+	tx, prevout, err := bip322Transaction(a.Script(), message)
+	if err != nil {
+		return err
+	}
+
+	switch a.Type {
+	case WitnessV0KeyHashScript:
+		if len(witness) != 2 || len(witness[1]) != 33 ||
+			!bytes.Equal(hash160(witness[1]), a.Program) {
+			return ErrInvalidSignature
+		}
+		der := witness[0]
+		if len(der) == 0 || der[len(der)-1] != sigHashAll {
+			return ErrInvalidSignature
+		}
+		r, s, err := parseDER(der[:len(der)-1])
+		if err != nil || s.Cmp(curveHalfN) > 0 {
+			return ErrInvalidSignature
+		}
+		pub, err := parsePubKey(witness[1])
+		if err != nil {
+			return ErrInvalidSignature
+		}
+		hash, err := witnessV0Sighash(tx, 0, p2pkhScriptCode(a.Program),
+			prevout.Value)
+		if err != nil {
+			return err
+		}
+		if !verifyECDSA(pub, hash, r, s) {
+			return ErrInvalidSignature
+		}
+		return nil
+
+	case WitnessV1TaprootScript:
+		if len(witness) != 1 {
+			return ErrInvalidSignature
+		}
+		sig, hashType := witness[0], byte(sigHashDefault)
+		switch {
+		case len(sig) == 65 && sig[64] == sigHashAll:
+			sig, hashType = sig[:64], sigHashAll
+		case len(sig) != 64:
+			return ErrInvalidSignature
+		}
+		hash, err := taprootSighash(tx, 0, []RawOutput{prevout}, hashType)
+		if err != nil {
+			return err
+		}
+		if !verifySchnorr(a.Program, hash, sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+	return fmt.Errorf("cannot verify messages for %s address %s", a.Type,
+		address)
+}
+
+// VerifyMessage checks that signature is a signature of message by the key
+// of address, which must be an address of the network of c.
+func (c *Chain) VerifyMessage(address, message, signature string) error {
+	return VerifyMessage(address, message, signature, c.network)
+}
@@ -0,0 +1,141 @@
+package chain_test
+
+import (
+	"testing"
+
+	"github.com/qedus/chain"
+)
+
+// BIP 322 test vectors.
+const (
+	bip322Key     = "L3VFeEujGtevx9w18HD1fhRbCH67Az2dpCymeRE1SoPK6XQtaN2k"
+	bip322P2WPKH  = "bc1q9vza2e8x573nczrlzms0wvx3gsqjx7vavgkx0l"
+	bip322P2TR    = "bc1ppv609nr0vr25u07u95waq5lucwfm6tde4nydujnu8npg4q75mr5sxq8lt3"
+	bip322P2TRSig = "AUHd69PrJQEv+oKTfZ8l+WROBHuy9HKrbFCJu7U1iK2iiEy1vMU5EfMtjc+VS" +
+		"HM7aU0SDbak5IUZRVno2P5mjSafAQ=="
+)
+
+// otherAddressTestNet is a TestNet3 P2PKH address with no known key.
+const otherAddressTestNet = "mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn"
+
+func TestSignMessageBIP322(t *testing.T) {
+	for message, want := range map[string]string{
+		"": "AkcwRAIgM2gBAQqvZX15ZiysmKmQpDrG83avLIT492QBzLnQIxYCIBaTpOaD20qRl" +
+			"EylyxFSeEA2ba9YOixpX8z46TSDtS40ASECx/EgAxlkQpQ9hYjgGu6EBCPMVPwVIVJq" +
+			"O4XCsMvViHI=",
+		"Hello World": "AkcwRAIgZRfIY3p7/DoVTty6YZbWS71bc5Vct9p9Fia83eRmw2QCICK" +
+			"/ENGfwLtptFluMGs2KsqoNSk89pO7F29zJLUx9a/sASECx/EgAxlkQpQ9hYjgGu6EBC" +
+			"PMVPwVIVJqO4XCsMvViHI=",
+	} {
+		sig, err := chain.SignMessageBIP322(bip322Key, bip322P2WPKH, message,
+			chain.MainNet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig != want {
+			t.Fatalf("unexpected signature of %q: %s", message, sig)
+		}
+		if err := chain.VerifyMessage(bip322P2WPKH, message, sig,
+			chain.MainNet); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := chain.VerifyMessage(bip322P2TR, "Hello World", bip322P2TRSig,
+		chain.MainNet); err != nil {
+		t.Fatal(err)
+	}
+	// Schnorr signatures use random auxiliary data.
+	sig, err := chain.SignMessageBIP322(bip322Key, bip322P2TR, "Hello World",
+		chain.MainNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.VerifyMessage(bip322P2TR, "Hello World", sig,
+		chain.MainNet); err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.VerifyMessage(bip322P2TR, "Hello", sig,
+		chain.MainNet); err != chain.ErrInvalidSignature {
+		t.Fatal("expected invalid signature", err)
+	}
+}
+
+func TestSignMessageLegacy(t *testing.T) {
+	// From the Bitcoin Core signmessage tests.
+	const (
+		key     = "cUeKHd5orzT3mz8P9pxyREHfsWtVfgsfDjiZZBcjUBAaGk1BTj7N"
+		address = "mpLQjfK79b7CCV4VMJWEWAj5Mpx8Up5zxB"
+		message = "This is just a test message"
+		want    = "INbVnW4e6PeRmsv2Qgu8NuopvrVjkcxob+sX8OcZG0SALhWybUjzMLPdAsXI" +
+			"46YZGb0KQTRii+wWIQzRpG/U+S0="
+	)
+	c := chain.New(nil, chain.TestNet3, "", "")
+	sig, err := c.SignMessage(key, address, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig != want {
+		t.Fatal("unexpected signature", sig)
+	}
+	if err := c.VerifyMessage(address, message, sig); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.VerifyMessage(address, message+".",
+		sig); err != chain.ErrInvalidSignature {
+		t.Fatal("expected invalid signature", err)
+	}
+	if err := c.VerifyMessage(otherAddressTestNet, message,
+		sig); err != chain.ErrInvalidSignature {
+		t.Fatal("expected invalid signature", err)
+	}
+}
+
+func TestVerifyMessageErrors(t *testing.T) {
+	c := chain.New(nil, chain.TestNet3, "", "")
+	if _, ok := c.VerifyMessage(bip322P2WPKH, "", "AA==").(*chain.AddressNetworkError); !ok {
+		t.Fatal("expected network error")
+	}
+	if _, err := c.SignMessageBIP322(bip322Key, bip322P2WPKH, ""); err == nil {
+		t.Fatal("expected network error")
+	}
+
+	// Each format only signs for its own address types.
+	if _, err := chain.SignMessage(bip322Key, bip322P2WPKH, "",
+		chain.MainNet); err == nil {
+		t.Fatal("expected legacy address type error")
+	}
+	if _, err := chain.SignMessageBIP322(bip322Key, testAddress, "",
+		chain.MainNet); err == nil {
+		t.Fatal("expected BIP 322 address type error")
+	}
+
+	for _, tc := range []struct {
+		address, signature string
+	}{
+		{bip322P2WPKH, "not base64"},
+		{bip322P2WPKH, "AkcwRAIg"},
+		{testAddress, bip322P2TRSig},
+		{"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", bip322P2TRSig},
+	} {
+		err := chain.VerifyMessage(tc.address, "", tc.signature, chain.MainNet)
+		if err == nil || err == chain.ErrInvalidSignature {
+			t.Fatal("expected malformed signature error", tc, err)
+		}
+	}
+	if err := chain.VerifyMessage(bip322P2WPKH, "Hello World", bip322P2TRSig,
+		chain.MainNet); err != chain.ErrInvalidSignature {
+		t.Fatal("expected invalid signature", err)
+	}
+
+	// The key does not belong to these addresses.
+	if _, err := chain.SignMessage(bip322Key, otherAddress, "",
+		chain.MainNet); err == nil {
+		t.Fatal("expected key mismatch", otherAddress)
+	}
+	if _, err := chain.SignMessageBIP322(bip322Key,
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", "",
+		chain.MainNet); err == nil {
+		t.Fatal("expected key mismatch")
+	}
+}
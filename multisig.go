@@ -0,0 +1,233 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxMultisigKeys is the most public keys a multisig script built with
+	// NewMultisig can hold.
+	MaxMultisigKeys = 16
+
+	// maxRedeemScriptSize is the largest script that can be pushed as a P2SH
+	// redeem script.
+	maxRedeemScriptSize = 520
+)
+
+// Multisig is an m-of-n multisig script, OP_m <keys> OP_n OP_CHECKMULTISIG,
+// used as a bare output script, a P2SH redeem script or a P2WSH witness
+// script.
+type Multisig struct {
+	RequiredSignatures int
+
+	// PublicKeys are hex encoded, in script order.
+	PublicKeys []string
+}
+
+// NewMultisig creates an m-of-n multisig of publicKeys, which are hex encoded
+// compressed or uncompressed public keys, keeping their order.
+func NewMultisig(m int, publicKeys []string) (*Multisig, error) {
+	n := len(publicKeys)
+	if n < 1 || n > MaxMultisigKeys {
+		return nil, fmt.Errorf("multisig needs 1 to %d keys, got %d",
+			MaxMultisigKeys, n)
+	}
+	if m < 1 || m > n {
+		return nil, fmt.Errorf("invalid multisig threshold %d of %d", m, n)
+	}
+	keys := make([]string, n)
+	for i, k := range publicKeys {
+		b, err := hex.DecodeString(k)
+		if err != nil || !isPubKey(b) {
+			return nil, fmt.Errorf("invalid public key %q", k)
+		}
+		keys[i] = hex.EncodeToString(b)
+	}
+	return &Multisig{m, keys}, nil
+}
+
+// NewSortedMultisig creates an m-of-n multisig with its keys sorted as
+// specified by BIP 67, so the same keys always give the same script and
+// addresses. BIP 67 requires compressed public keys.
+func NewSortedMultisig(m int, publicKeys []string) (*Multisig, error) {
+	ms, err := NewMultisig(m, publicKeys)
+	if err != nil {
+		return nil, err
+	}
+	if !ms.compressed() {
+		return nil, errors.New("BIP 67 requires compressed public keys")
+	}
+	// Hex encoding preserves the byte order.
+	sort.Strings(ms.PublicKeys)
+	return ms, nil
+}
+
+// ParseMultisig parses a hex encoded multisig script, such as a redeem
+// script or an Output's ScriptHex.
+func ParseMultisig(scriptHex string) (*Multisig, error) {
+	script, err := hex.DecodeString(scriptHex)
+	if err != nil {
+		return nil, err
+	}
+	return parseMultisigScript(script)
+}
+
+func parseMultisigScript(script []byte) (*Multisig, error) {
+	ops, err := parseScript(script)
+	if err != nil {
+		return nil, err
+	}
+	m, keys, err := parseMultisig(ops)
+	if err != nil {
+		return nil, err
+	}
+	ms := &Multisig{RequiredSignatures: m}
+	for _, k := range keys {
+		ms.PublicKeys = append(ms.PublicKeys, hex.EncodeToString(k))
+	}
+	return ms, nil
+}
+
+// Multisig parses the bare multisig output script of o. The script is read
+// from ScriptHex, or from the Script assembly if ScriptHex is not set.
+func (o Output) Multisig() (*Multisig, error) {
+	if o.ScriptHex != "" {
+		return ParseMultisig(o.ScriptHex)
+	}
+	script, err := parseASM(o.Script)
+	if err != nil {
+		return nil, err
+	}
+	return parseMultisigScript(script)
+}
+
+// Script returns the multisig script.
+func (ms *Multisig) Script() []byte {
+	script := []byte{op1 + byte(ms.RequiredSignatures) - 1}
+	for _, k := range ms.PublicKeys {
+		b, _ := hex.DecodeString(k)
+		script = pushData(script, b)
+	}
+	return append(script, op1+byte(len(ms.PublicKeys))-1, opCheckMultisig)
+}
+
+// ScriptHex returns the multisig script in hex.
+func (ms *Multisig) ScriptHex() string {
+	return hex.EncodeToString(ms.Script())
+}
+
+// WitnessRedeemScript returns the P2SH redeem script of the P2SH-P2WSH
+// address, a version 0 witness program of the script's SHA-256.
+func (ms *Multisig) WitnessRedeemScript() []byte {
+	h := sha256.Sum256(ms.Script())
+	return append([]byte{op0, sha256.Size}, h[:]...)
+}
+
+// MultisigAddresses are the addresses paying to a Multisig.
+type MultisigAddresses struct {
+	// P2SH is the legacy pay to script hash address. It is empty if the
+	// script is too large to be a redeem script.
+	P2SH string
+
+	// P2SHP2WSH is the P2WSH address nested in P2SH, for wallets that cannot
+	// pay to segwit addresses. It is empty if any public key is uncompressed.
+	P2SHP2WSH string
+
+	// P2WSH is the native segwit address. It is empty if any public key is
+	// uncompressed, as BIP 143 makes such witness scripts non-standard.
+	P2WSH string
+}
+
+// compressed reports whether all the public keys of ms are compressed.
+func (ms *Multisig) compressed() bool {
+	for _, k := range ms.PublicKeys {
+		if len(k) != 66 {
+			return false
+		}
+	}
+	return true
+}
+
+// Addresses returns the addresses of ms on network n. Only the P2SH address
+// is set for multisigs with uncompressed public keys.
+func (ms *Multisig) Addresses(n Network) (*MultisigAddresses, error) {
+	params, ok := networks[n]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q", n)
+	}
+	script := ms.Script()
+	a := &MultisigAddresses{}
+	if ms.compressed() {
+		witnessHash := sha256.Sum256(script)
+		a.P2SHP2WSH = base58CheckEncode(params.scriptHash,
+			hash160(ms.WitnessRedeemScript()))
+		a.P2WSH = encodeSegwitAddress(params.hrp, 0, witnessHash[:])
+	}
+	if len(script) <= maxRedeemScriptSize {
+		a.P2SH = base58CheckEncode(params.scriptHash, hash160(script))
+	}
+	return a, nil
+}
+
+// MultisigAddresses returns the addresses of ms on the network of c.
+func (c *Chain) MultisigAddresses(ms *Multisig) (*MultisigAddresses, error) {
+	return ms.Addresses(c.network)
+}
+
+// asmOpcodes maps the opcode names used in script assembly to opcodes.
+var asmOpcodes = map[string]byte{
+	"0":                op0,
+	"-1":               op1Negate,
+	"OP_0":             op0,
+	"OP_FALSE":         op0,
+	"OP_1NEGATE":       op1Negate,
+	"OP_RETURN":        opReturn,
+	"OP_DUP":           opDup,
+	"OP_EQUAL":         opEqual,
+	"OP_EQUALVERIFY":   opEqualVerify,
+	"OP_HASH160":       opHash160,
+	"OP_CHECKSIG":      opCheckSig,
+	"OP_CHECKMULTISIG": opCheckMultisig,
+}
+
+// parseASM converts script assembly, as found in Output.Script, to a script.
+// It understands the standard script opcodes, small numbers written as OP_n,
+// OP_PUSHNUM_n or, as Bitcoin Core and btcd write them, bare decimals from -1
+// to 16, and hex pushes, optionally preceded by OP_PUSHBYTES_n or
+// OP_PUSHDATAn as written by Esplora. A bare token such as 12 is therefore
+// OP_12, never the push of the byte 0x12, which Core writes as 18.
+func parseASM(asm string) ([]byte, error) {
+	script := []byte{}
+	for _, token := range strings.Fields(asm) {
+		if op, ok := asmOpcodes[token]; ok {
+			script = append(script, op)
+			continue
+		}
+		if strings.HasPrefix(token, "OP_PUSHBYTES_") ||
+			strings.HasPrefix(token, "OP_PUSHDATA") {
+			continue
+		}
+		num := strings.TrimPrefix(strings.TrimPrefix(token, "OP_PUSHNUM_"),
+			"OP_")
+		if n, err := strconv.Atoi(num); err == nil && n >= 1 && n <= 16 &&
+			(num != token || len(token) <= 2) {
+			script = append(script, op1+byte(n)-1)
+			continue
+		}
+		data, err := hex.DecodeString(token)
+		if err != nil || strings.HasPrefix(token, "OP_") {
+			return nil, fmt.Errorf("unsupported script token %q", token)
+		}
+		script = pushData(script, data)
+	}
+	if len(script) == 0 {
+		return nil, errors.New("empty script")
+	}
+	return script, nil
+}
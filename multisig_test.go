@@ -0,0 +1,148 @@
+package chain_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/qedus/chain"
+)
+
+// BIP 67 test vector 1.
+var (
+	bip67Keys = []string{
+		"02ff12471208c14bd580709cb2358d98975247d8765f92bc25eab3b2763ed605f8",
+		"02fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753db7dc0adc188b2f",
+	}
+	bip67Script = "522102fe6f0a5a297eb38c391581c4413e084773ea23954d93f7753d" +
+		"b7dc0adc188b2f2102ff12471208c14bd580709cb2358d98975247d8765f92bc25eab" +
+		"3b2763ed605f852ae"
+)
+
+func TestMultisigAddresses(t *testing.T) {
+	ms, err := chain.NewSortedMultisig(2, bip67Keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms.ScriptHex() != bip67Script {
+		t.Fatal("unexpected script", ms.ScriptHex())
+	}
+	addresses, err := ms.Addresses(chain.MainNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addresses.P2SH != "39bgKC7RFbpoCRbtD5KEdkYKtNyhpsNa3Z" {
+		t.Fatal("unexpected P2SH address", addresses.P2SH)
+	}
+
+	witnessHash := sha256.Sum256(ms.Script())
+	p2wsh, err := chain.DecodeAddress(addresses.P2WSH, chain.MainNet)
+	if err != nil || p2wsh.Type != chain.WitnessV0ScriptHashScript ||
+		!bytes.Equal(p2wsh.Program, witnessHash[:]) {
+		t.Fatal("unexpected P2WSH address", addresses.P2WSH, err)
+	}
+	if redeem := ms.WitnessRedeemScript(); !bytes.Equal(redeem,
+		append([]byte{0, 32}, witnessHash[:]...)) {
+		t.Fatal("unexpected witness redeem script", redeem)
+	}
+	nested, err := chain.DecodeAddress(addresses.P2SHP2WSH, chain.MainNet)
+	if err != nil || nested.Type != chain.ScriptHashScript ||
+		addresses.P2SHP2WSH == addresses.P2SH {
+		t.Fatal("unexpected P2SH-P2WSH address", addresses.P2SHP2WSH, err)
+	}
+
+	c := chain.New(nil, chain.TestNet3, "", "")
+	testnet, err := c.MultisigAddresses(ms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range []string{testnet.P2SH, testnet.P2SHP2WSH, testnet.P2WSH} {
+		if err := c.ValidateAddress(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNewMultisigErrors(t *testing.T) {
+	uncompressed := "04" + bip67Keys[0][2:] + bip67Keys[1][2:]
+	for _, c := range []struct {
+		m    int
+		keys []string
+	}{
+		{0, bip67Keys},
+		{3, bip67Keys},
+		{1, nil},
+		{1, []string{"02ff"}},
+		{1, []string{"zz"}},
+	} {
+		if _, err := chain.NewMultisig(c.m, c.keys); err == nil {
+			t.Fatal("expected error", c.m, c.keys)
+		}
+	}
+	if _, err := chain.NewMultisig(1, []string{uncompressed}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chain.NewSortedMultisig(1,
+		[]string{uncompressed}); err == nil {
+		t.Fatal("expected uncompressed key error")
+	}
+}
+
+func TestMultisigAddressesUncompressed(t *testing.T) {
+	uncompressed := "04" + bip67Keys[0][2:] + bip67Keys[1][2:]
+	ms, err := chain.NewMultisig(1, []string{bip67Keys[0], uncompressed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses, err := ms.Addresses(chain.MainNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addresses.P2SH == "" || addresses.P2SHP2WSH != "" ||
+		addresses.P2WSH != "" {
+		t.Fatal("expected only a P2SH address", addresses)
+	}
+}
+
+func TestOutputMultisig(t *testing.T) {
+	for _, out := range []chain.Output{
+		{ScriptHex: bip67Script, RequiredSignatures: 2},
+		{Script: "OP_2 " + bip67Keys[1] + " " + bip67Keys[0] +
+			" OP_2 OP_CHECKMULTISIG", RequiredSignatures: 2},
+		// Bitcoin Core and btcd asm.
+		{Script: "2 " + bip67Keys[1] + " " + bip67Keys[0] +
+			" 2 OP_CHECKMULTISIG", RequiredSignatures: 2},
+		{Script: "OP_PUSHNUM_2 OP_PUSHBYTES_33 " + bip67Keys[1] +
+			" OP_PUSHBYTES_33 " + bip67Keys[0] + " OP_PUSHNUM_2 OP_CHECKMULTISIG",
+			RequiredSignatures: 2},
+	} {
+		ms, err := out.Multisig()
+		if err != nil {
+			t.Fatal(out.Script, err)
+		}
+		if int64(ms.RequiredSignatures) != out.RequiredSignatures ||
+			len(ms.PublicKeys) != 2 || ms.PublicKeys[0] != bip67Keys[1] ||
+			ms.ScriptHex() != bip67Script {
+			t.Fatal("unexpected multisig", ms)
+		}
+	}
+
+	for _, out := range []chain.Output{
+		{Script: "OP_DUP OP_HASH160 62e907b15cbf27d5425399ebf6f0fb50ebb88f18 " +
+			"OP_EQUALVERIFY OP_CHECKSIG"},
+		{ScriptHex: "5121" + bip67Keys[0] + "52ae"},
+		{Script: "OP_NOP"},
+		// Bare numbers are small number opcodes, so this is 12 of 2.
+		{Script: "12 " + bip67Keys[1] + " " + bip67Keys[0] +
+			" 2 OP_CHECKMULTISIG"},
+	} {
+		if _, err := out.Multisig(); err == nil {
+			t.Fatal("expected error", out)
+		}
+	}
+	script, _ := hex.DecodeString(bip67Script)
+	if chain.ScriptType(script) != chain.MultisigScript {
+		t.Fatal("expected multisig script type")
+	}
+}
@@ -3,43 +3,153 @@ package chain
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 )
 
+// NotificationType is the kind of event a notification is delivered for.
+type NotificationType string
+
+const (
+	// NewTransactionNotification is delivered for every new transaction on
+	// the network.
+	NewTransactionNotification NotificationType = "new-transaction"
+
+	// NewBlockNotification is delivered for every new block.
+	NewBlockNotification NotificationType = "new-block"
+
+	// AddressNotification is delivered for transactions involving a single
+	// address.
+	AddressNotification NotificationType = "address"
+
+	// TransactionNotification is delivered when a transaction reaches a
+	// target number of confirmations.
+	TransactionNotification NotificationType = "transaction"
+)
+
+// Notification states.
+const (
+	NotificationEnabled  = "enabled"
+	NotificationDisabled = "disabled"
+)
+
+// NotificationResponse represents a notification registered with Chain.com.
+//
+// Chain documentation can be found here
+// https://chain.com/docs#notifications.
 type NotificationResponse struct {
-	ID         string
-	State      string
-	URL        string
-	Type       string
-	Address    string
-	BlockChain string `json:"block_chain"`
+	ID              string
+	State           string
+	URL             string
+	Type            NotificationType
+	Address         string
+	TransactionHash string `json:"transaction_hash"`
+	Confirmations   int64
+	BlockChain      string `json:"block_chain"`
+}
+
+// notificationRequest is the body used to create a notification.
+type notificationRequest struct {
+	Type            NotificationType `json:"type"`
+	BlockChain      string           `json:"block_chain"`
+	URL             string           `json:"url"`
+	Address         string           `json:"address,omitempty"`
+	TransactionHash string           `json:"transaction_hash,omitempty"`
+	Confirmations   int64            `json:"confirmations,omitempty"`
 }
 
+// CreateNewTxNotification registers url to receive every new transaction on
+// the network.
 func (c *Chain) CreateNewTxNotification(url string) (
 	*NotificationResponse, error) {
-	return c.createNewNotification(url, "new-transaction")
+	return c.createNewNotification(&notificationRequest{
+		Type: NewTransactionNotification,
+		URL:  url,
+	})
 }
 
+// CreateNewBlockNotification registers url to receive every new block.
 func (c *Chain) CreateNewBlockNotification(url string) (
 	*NotificationResponse, error) {
-	return c.createNewNotification(url, "new-block")
+	return c.createNewNotification(&notificationRequest{
+		Type: NewBlockNotification,
+		URL:  url,
+	})
 }
 
-func (c *Chain) createNewNotification(url, ty string) (
+// CreateAddressNotification registers url to receive transactions that
+// involve address.
+func (c *Chain) CreateAddressNotification(url, address string) (
 	*NotificationResponse, error) {
+	return c.createNewNotification(&notificationRequest{
+		Type:    AddressNotification,
+		URL:     url,
+		Address: address,
+	})
+}
+
+// CreateAddressNotificationMulti registers url to receive transactions that
+// involve any of addresses. One notification is created per address and the
+// responses are in a one-to-one correspondence with addresses. If any
+// creation fails the error is a MultiError.
+func (c *Chain) CreateAddressNotificationMulti(url string,
+	addresses []string) ([]*NotificationResponse, error) {
+	if c.backend != nil {
+		return nil, ErrNotSupported
+	}
+	if len(addresses) > MaxAddresses {
+		return nil, fmt.Errorf("max addresses allowed is %d", MaxAddresses)
+	}
+
+	responses := make([]*NotificationResponse, len(addresses))
+	errs := make(MultiError, len(addresses))
+	isErrors := false
+	for i, address := range addresses {
+		responses[i], errs[i] = c.CreateAddressNotification(url, address)
+		if errs[i] != nil {
+			isErrors = true
+		}
+	}
+
+	if isErrors {
+		return responses, errs
+	}
+	return responses, nil
+}
+
+// CreateTransactionNotification registers url to be notified when the
+// transaction with hash txHash reaches the given number of confirmations.
+func (c *Chain) CreateTransactionNotification(url, txHash string,
+	confirmations int64) (*NotificationResponse, error) {
+	if confirmations < 1 {
+		return nil, errors.New("confirmations must be >= 1")
+	}
+	return c.createNewNotification(&notificationRequest{
+		Type:            TransactionNotification,
+		URL:             url,
+		TransactionHash: txHash,
+		Confirmations:   confirmations,
+	})
+}
+
+func (c *Chain) createNewNotification(req *notificationRequest) (
+	*NotificationResponse, error) {
+	if c.backend != nil {
+		return nil, ErrNotSupported
+	}
 	endpointURL := fmt.Sprintf("%s/notifications", baseURL)
-	req := &struct {
-		Type       string `json:"type"`
-		BlockChain string `json:"block_chain"`
-		URL        string `json:"url"`
-	}{ty, string(c.network), url}
+	req.BlockChain = string(c.network)
 
 	requestBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
 	response, err := c.httpPostJSON(endpointURL, bytes.NewReader(requestBody))
 	if err != nil {
 		return nil, err
 	}
+	defer response.Close()
 
 	responseBody, err := ioutil.ReadAll(response)
 	if err != nil {
@@ -53,13 +163,118 @@ func (c *Chain) createNewNotification(url, ty string) (
 	return resp, nil
 }
 
+// ListNotifications returns the notifications registered with the API key
+// for the client's network. All pages are fetched.
+//
+// Chain documentation can be found here
+// https://chain.com/docs#notifications-list.
 func (c *Chain) ListNotifications() ([]*NotificationResponse, error) {
-	url := fmt.Sprintf("%s/notifications", baseURL)
+	all, err := c.ListAllNotifications()
+	if err != nil {
+		return nil, err
+	}
+
 	resp := []*NotificationResponse{}
-	return resp, c.httpGetJSON(url, &resp)
+	for _, n := range all {
+		if n.BlockChain == string(c.network) {
+			resp = append(resp, n)
+		}
+	}
+	return resp, nil
 }
 
+// ListAllNotifications returns the notifications registered with the API
+// key for every network. All pages are fetched.
+func (c *Chain) ListAllNotifications() ([]*NotificationResponse, error) {
+	resp := []*NotificationResponse{}
+	err := c.ListNotificationPages(func(page []*NotificationResponse) error {
+		resp = append(resp, page...)
+		return nil
+	})
+	return resp, err
+}
+
+// ListNotificationPages calls fn with each page of notifications, for every
+// network, as it is fetched. It stops at the first error returned by fn.
+func (c *Chain) ListNotificationPages(
+	fn func([]*NotificationResponse) error) error {
+	if c.backend != nil {
+		return ErrNotSupported
+	}
+	url := fmt.Sprintf("%s/notifications", baseURL)
+	for url != "" {
+		page := []*NotificationResponse{}
+		next, err := c.httpGetJSONPage(url, &page)
+		if err != nil {
+			return err
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		url = next
+	}
+	return nil
+}
+
+// GetNotification returns the notification with the given ID.
+func (c *Chain) GetNotification(id string) (*NotificationResponse, error) {
+	if c.backend != nil {
+		return nil, ErrNotSupported
+	}
+	url := fmt.Sprintf("%s/notifications/%s", baseURL, id)
+
+	resp := &NotificationResponse{}
+	return resp, c.httpGetJSON(url, resp)
+}
+
+// NotificationUpdate holds the changes made by UpdateNotification. Empty
+// fields are left unchanged.
+type NotificationUpdate struct {
+	URL   string `json:"url,omitempty"`
+	State string `json:"state,omitempty"`
+}
+
+// UpdateNotification changes the URL or state of the notification with the
+// given ID.
+func (c *Chain) UpdateNotification(id string,
+	update NotificationUpdate) (*NotificationResponse, error) {
+	if c.backend != nil {
+		return nil, ErrNotSupported
+	}
+	url := fmt.Sprintf("%s/notifications/%s", baseURL, id)
+
+	requestBody, err := json.Marshal(update)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.httpPutJSON(url, bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Close()
+
+	resp := &NotificationResponse{}
+	return resp, decodeJSON(response, resp)
+}
+
+// PauseNotification stops deliveries for the notification with the given ID
+// without deleting it.
+func (c *Chain) PauseNotification(id string) (*NotificationResponse, error) {
+	return c.UpdateNotification(id,
+		NotificationUpdate{State: NotificationDisabled})
+}
+
+// ResumeNotification restarts deliveries for a paused notification.
+func (c *Chain) ResumeNotification(id string) (*NotificationResponse, error) {
+	return c.UpdateNotification(id,
+		NotificationUpdate{State: NotificationEnabled})
+}
+
+// DeleteNotification removes the notification with the given ID.
 func (c *Chain) DeleteNotification(id string) (*NotificationResponse, error) {
+	if c.backend != nil {
+		return nil, ErrNotSupported
+	}
 	url := fmt.Sprintf("%s/notifications/%s", baseURL, id)
 
 	resp := &NotificationResponse{}
@@ -0,0 +1,183 @@
+package chain
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// NotificationSpec describes a notification that should exist.
+type NotificationSpec struct {
+	Type            NotificationType
+	URL             string
+	Address         string
+	TransactionHash string
+	Confirmations   int64
+
+	// Network defaults to the network of the Chain the spec is synced with.
+	Network Network
+}
+
+func (s NotificationSpec) key() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%d", s.Network, s.Type, s.URL,
+		s.Address, s.TransactionHash, s.Confirmations)
+}
+
+func (s NotificationSpec) String() string {
+	str := fmt.Sprintf("%s %s %s", s.Network, s.Type, s.URL)
+	switch s.Type {
+	case AddressNotification:
+		str += " address=" + s.Address
+	case TransactionNotification:
+		str += fmt.Sprintf(" transaction=%s confirmations=%d",
+			s.TransactionHash, s.Confirmations)
+	}
+	return str
+}
+
+func notificationSpec(n *NotificationResponse) NotificationSpec {
+	return NotificationSpec{
+		Type:            n.Type,
+		URL:             n.URL,
+		Address:         n.Address,
+		TransactionHash: n.TransactionHash,
+		Confirmations:   n.Confirmations,
+		Network:         Network(n.BlockChain),
+	}
+}
+
+// NotificationPlan is the set of changes needed to make the registered
+// notifications match a desired set. It is returned by PlanNotifications so
+// it can be reviewed, as a dry run, before being applied.
+type NotificationPlan struct {
+	// Create holds the desired notifications that do not exist.
+	Create []NotificationSpec
+
+	// Delete holds the existing notifications that are not desired,
+	// including duplicates of desired notifications.
+	Delete []*NotificationResponse
+
+	// Keep holds the existing notifications that are desired.
+	Keep []*NotificationResponse
+
+	// Created is filled in with the new notifications as the plan is
+	// applied.
+	Created []*NotificationResponse
+}
+
+// Empty reports whether the plan has no changes.
+func (p *NotificationPlan) Empty() bool {
+	return len(p.Create) == 0 && len(p.Delete) == 0
+}
+
+// String describes the changes in the plan, one per line, prefixed with + for
+// creations and - for deletions.
+func (p *NotificationPlan) String() string {
+	buf := &bytes.Buffer{}
+	for _, s := range p.Create {
+		fmt.Fprintf(buf, "+ %s\n", s)
+	}
+	for _, n := range p.Delete {
+		fmt.Fprintf(buf, "- %s %s\n", notificationSpec(n), n.ID)
+	}
+	return buf.String()
+}
+
+// PlanNotifications compares the registered notifications with desired by
+// type, URL, address, transaction, confirmations and network. Only existing
+// notifications on the client's network or a network named in desired are
+// considered for deletion so notifications for other networks are left
+// alone.
+func (c *Chain) PlanNotifications(
+	desired []NotificationSpec) (*NotificationPlan, error) {
+	existing, err := c.ListAllNotifications()
+	if err != nil {
+		return nil, err
+	}
+
+	managed := map[Network]bool{c.network: true}
+	wanted := map[string]bool{}
+	plan := &NotificationPlan{}
+	for _, s := range desired {
+		if s.Network == "" {
+			s.Network = c.network
+		}
+		managed[s.Network] = true
+		if k := s.key(); !wanted[k] {
+			wanted[k] = true
+			plan.Create = append(plan.Create, s)
+		}
+	}
+
+	kept := map[string]bool{}
+	for _, n := range existing {
+		if !managed[Network(n.BlockChain)] {
+			continue
+		}
+		k := notificationSpec(n).key()
+		if wanted[k] && !kept[k] {
+			kept[k] = true
+			plan.Keep = append(plan.Keep, n)
+		} else {
+			plan.Delete = append(plan.Delete, n)
+		}
+	}
+
+	create := plan.Create[:0]
+	for _, s := range plan.Create {
+		if !kept[s.key()] {
+			create = append(create, s)
+		}
+	}
+	plan.Create = create
+	return plan, nil
+}
+
+// createNotification creates the notification described by s, which may be
+// on a network other than the client's.
+func (c *Chain) createNotification(s NotificationSpec) (
+	*NotificationResponse, error) {
+	nc := c
+	if s.Network != "" && s.Network != c.network {
+		copied := *c
+		copied.network = s.Network
+		nc = &copied
+	}
+	return nc.createNewNotification(&notificationRequest{
+		Type:            s.Type,
+		URL:             s.URL,
+		Address:         s.Address,
+		TransactionHash: s.TransactionHash,
+		Confirmations:   s.Confirmations,
+	})
+}
+
+// ApplyNotificationPlan creates and then deletes the notifications in plan.
+// Creations happen first so desired notifications are never missing. It
+// stops at the first error; Created records the progress made.
+func (c *Chain) ApplyNotificationPlan(plan *NotificationPlan) error {
+	for _, s := range plan.Create {
+		n, err := c.createNotification(s)
+		if err != nil {
+			return fmt.Errorf("creating notification %s: %s", s, err)
+		}
+		plan.Created = append(plan.Created, n)
+	}
+	for _, n := range plan.Delete {
+		if _, err := c.DeleteNotification(n.ID); err != nil {
+			return fmt.Errorf("deleting notification %s: %s", n.ID, err)
+		}
+	}
+	return nil
+}
+
+// SyncNotifications makes the registered notifications match desired by
+// creating missing notifications and deleting extra ones. It returns the
+// applied plan. Use PlanNotifications for a dry run.
+func (c *Chain) SyncNotifications(
+	desired []NotificationSpec) (*NotificationPlan, error) {
+	plan, err := c.PlanNotifications(desired)
+	if err != nil {
+		return nil, err
+	}
+	return plan, c.ApplyNotificationPlan(plan)
+}
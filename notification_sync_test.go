@@ -0,0 +1,71 @@
+package chain_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/qedus/chain"
+)
+
+func TestSyncNotifications(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 0)
+	c := newTestChain(t, chain.MainNet, api)
+
+	// Stale notifications from earlier deploys, one of them duplicated, and
+	// one on a network that is not managed.
+	for _, url := range []string{"https://old.example.com/hook",
+		"https://example.com/blocks", "https://example.com/blocks"} {
+		if _, err := c.CreateNewBlockNotification(url); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testnet := newTestChain(t, chain.TestNet3, api)
+	if _, err := testnet.CreateNewTxNotification(
+		"https://example.com/testnet"); err != nil {
+		t.Fatal(err)
+	}
+
+	desired := []chain.NotificationSpec{
+		{Type: chain.NewBlockNotification, URL: "https://example.com/blocks"},
+		{
+			Type:    chain.AddressNotification,
+			URL:     "https://example.com/addresses",
+			Address: "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa",
+		},
+	}
+
+	plan, err := c.PlanNotifications(desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Create) != 1 || len(plan.Delete) != 2 || len(plan.Keep) != 1 {
+		t.Fatalf("unexpected plan\n%s", plan)
+	}
+	if !strings.Contains(plan.String(),
+		"+ bitcoin address https://example.com/addresses") {
+		t.Fatalf("unexpected plan description\n%s", plan)
+	}
+
+	// Planning is a dry run.
+	all, err := c.ListAllNotifications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 {
+		t.Fatal("expected plan not to change notifications", len(all))
+	}
+
+	if plan, err = c.SyncNotifications(desired); err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Created) != 1 {
+		t.Fatal("expected one created notification")
+	}
+
+	if plan, err = c.PlanNotifications(desired); err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Empty() {
+		t.Fatalf("expected empty plan after sync\n%s", plan)
+	}
+}
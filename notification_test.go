@@ -44,3 +44,147 @@ func TestNotifications(t *testing.T) {
 		t.Fatal("expected no notifications")
 	}
 }
+
+func TestCreateAddressNotificationMulti(t *testing.T) {
+	api := newFakeAPI(t, chain.TestNet3, 0)
+	c := newTestChain(t, chain.TestNet3, api)
+
+	addresses := []string{
+		"msk1uz21sUAXdmgqUiWvkRBLNfL1SXatyj",
+		"n4CyDypGn7jyfKamweA26gQyJGm2HwWbmE",
+	}
+	responses, err := c.CreateAddressNotificationMulti(
+		"https://example.com/hook", addresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, resp := range responses {
+		if resp.Type != chain.AddressNotification ||
+			resp.Address != addresses[i] ||
+			resp.BlockChain != string(chain.TestNet3) {
+			t.Fatal("unexpected notification", resp)
+		}
+	}
+
+	resp, err := c.CreateTransactionNotification("https://example.com/hook",
+		"0bf0de38c26195919179f42d475beb7a6b15258c38b57236afdd60a07eddd2cc", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Type != chain.TransactionNotification || resp.Confirmations != 6 {
+		t.Fatal("unexpected notification", resp)
+	}
+
+	if _, err := c.CreateTransactionNotification("https://example.com/hook",
+		resp.TransactionHash, 0); err == nil {
+		t.Fatal("expected error for zero confirmations")
+	}
+}
+
+func TestListNotificationsNetwork(t *testing.T) {
+	api := newFakeAPI(t, chain.TestNet3, 0)
+	mainnet := newTestChain(t, chain.MainNet, api)
+	testnet := newTestChain(t, chain.TestNet3, api)
+
+	for i := 0; i < 3; i++ {
+		if _, err := mainnet.CreateNewBlockNotification(
+			"https://example.com/mainnet"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created, err := testnet.CreateNewTxNotification("https://example.com/testnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses, err := testnet.ListNotifications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 || responses[0].ID != created.ID {
+		t.Fatal("expected only the testnet notification", responses)
+	}
+
+	all, err := testnet.ListAllNotifications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 {
+		t.Fatal("expected notifications from every page", len(all))
+	}
+
+	n, err := testnet.GetNotification(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.URL != created.URL {
+		t.Fatal("unexpected notification", n)
+	}
+
+	if n, err = testnet.PauseNotification(created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n.State != chain.NotificationDisabled {
+		t.Fatal("expected paused notification", n.State)
+	}
+	if n, err = testnet.UpdateNotification(created.ID, chain.NotificationUpdate{
+		URL: "https://example.com/moved",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n.URL != "https://example.com/moved" ||
+		n.State != chain.NotificationDisabled {
+		t.Fatal("unexpected notification", n)
+	}
+	if n, err = testnet.ResumeNotification(created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n.State != chain.NotificationEnabled {
+		t.Fatal("expected resumed notification", n.State)
+	}
+
+	if _, err := testnet.GetNotification("missing"); !chain.IsNotFound(err) {
+		t.Fatal("expected not found error", err)
+	}
+}
+
+// noAddressBackend is a Backend whose GetAddressMulti returns no addresses.
+type noAddressBackend struct {
+	chain.Backend
+}
+
+func (noAddressBackend) GetAddressMulti([]string) ([]chain.Address, error) {
+	return nil, nil
+}
+
+func TestNotificationsWithBackend(t *testing.T) {
+	c := chain.NewWithBackend(noAddressBackend{}, chain.MainNet)
+
+	if _, err := c.ListNotifications(); err != chain.ErrNotSupported {
+		t.Fatal("expected not supported", err)
+	}
+	if _, err := c.CreateNewBlockNotification("http://example.com"); err !=
+		chain.ErrNotSupported {
+		t.Fatal("expected not supported", err)
+	}
+	if _, err := c.CreateAddressNotificationMulti("http://example.com",
+		[]string{testAddress}); err != chain.ErrNotSupported {
+		t.Fatal("expected not supported", err)
+	}
+	if _, err := c.GetNotification("1"); err != chain.ErrNotSupported {
+		t.Fatal("expected not supported", err)
+	}
+	if _, err := c.PauseNotification("1"); err != chain.ErrNotSupported {
+		t.Fatal("expected not supported", err)
+	}
+	if _, err := c.DeleteNotification("1"); err != chain.ErrNotSupported {
+		t.Fatal("expected not supported", err)
+	}
+	if _, err := c.SyncNotifications(nil); err != chain.ErrNotSupported {
+		t.Fatal("expected not supported", err)
+	}
+
+	if _, err := c.GetAddress(testAddress); err == nil {
+		t.Fatal("expected error for a missing address")
+	}
+}
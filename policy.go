@@ -0,0 +1,412 @@
+package chain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Send policy defaults, the same as Bitcoin Core's. Fee rates are in
+// satoshis per 1000 virtual bytes.
+const (
+	DefaultMinFeeRate       Amount = 1000
+	DefaultMaxFeeRate       Amount = BTC / 10
+	DefaultDustRelayFeeRate Amount = 3000
+
+	// MaxStandardWeight is the largest weight of a standard transaction.
+	MaxStandardWeight = 400000
+
+	// minStandardSize is the smallest size of a standard transaction
+	// without its witness.
+	minStandardSize = 65
+
+	// maxStandardScriptSigSize is the largest standard input script.
+	maxStandardScriptSigSize = 1650
+
+	// maxNullDataSize is the largest standard OP_RETURN output script.
+	maxNullDataSize = 83
+
+	// maxBareMultisigKeys is the most keys of a standard bare multisig
+	// output.
+	maxBareMultisigKeys = 3
+)
+
+// PolicyRule identifies a pre-broadcast check made by CheckTransaction.
+type PolicyRule string
+
+const (
+	// RuleDecode is violated by hex that is not a transaction.
+	RuleDecode PolicyRule = "decode"
+
+	// RuleInvalid is violated by transactions that break consensus rules:
+	// no inputs or outputs, output values out of range, duplicate inputs
+	// or outputs worth more than the inputs.
+	RuleInvalid PolicyRule = "invalid"
+
+	// RuleWeight is violated by transactions heavier than
+	// MaxStandardWeight or too small to be relayed.
+	RuleWeight PolicyRule = "weight"
+
+	// RuleDust is violated by outputs worth less than it costs to spend
+	// them.
+	RuleDust PolicyRule = "dust"
+
+	// RuleNonStandardScript is violated by non-standard output scripts and
+	// input scripts that are too large or do more than push data.
+	RuleNonStandardScript PolicyRule = "nonstandard-script"
+
+	// RuleMissingInput is violated by inputs spending unknown outputs.
+	RuleMissingInput PolicyRule = "missing-input"
+
+	// RuleSpentInput is violated by inputs spending outputs already spent
+	// by another transaction.
+	RuleSpentInput PolicyRule = "spent-input"
+
+	// RuleLowFee is violated by fee rates below the minimum relay fee rate.
+	RuleLowFee PolicyRule = "low-fee"
+
+	// RuleAbsurdFee is violated by fees above the configured ceilings.
+	RuleAbsurdFee PolicyRule = "absurd-fee"
+)
+
+// PolicyViolation is a failed check.
+type PolicyViolation struct {
+	Rule PolicyRule
+
+	// Index is the input or output the violation is about, or -1 if it is
+	// about the whole transaction.
+	Index   int
+	Message string
+}
+
+func (v PolicyViolation) String() string {
+	return fmt.Sprintf("%s: %s", v.Rule, v.Message)
+}
+
+// SendPolicy configures CheckTransaction. Zero fields take their defaults.
+type SendPolicy struct {
+	// MinFeeRate is the lowest fee rate allowed. It defaults to
+	// DefaultMinFeeRate.
+	MinFeeRate Amount
+
+	// MaxFeeRate is the highest fee rate allowed. It defaults to
+	// DefaultMaxFeeRate.
+	MaxFeeRate Amount
+
+	// MaxFee is the highest absolute fee allowed. Zero means no limit.
+	MaxFee Amount
+
+	// DustRelayFeeRate sets the dust threshold: an output is dust if
+	// spending it at this fee rate costs more than it is worth. It defaults
+	// to DefaultDustRelayFeeRate.
+	DustRelayFeeRate Amount
+
+	// Allow lists rules whose violations are reported but do not stop
+	// SendTransaction.
+	Allow []PolicyRule
+}
+
+func (p SendPolicy) withDefaults() SendPolicy {
+	if p.MinFeeRate == 0 {
+		p.MinFeeRate = DefaultMinFeeRate
+	}
+	if p.MaxFeeRate == 0 {
+		p.MaxFeeRate = DefaultMaxFeeRate
+	}
+	if p.DustRelayFeeRate == 0 {
+		p.DustRelayFeeRate = DefaultDustRelayFeeRate
+	}
+	return p
+}
+
+// PolicyReport is the result of CheckTransaction.
+type PolicyReport struct {
+	TransactionHash string
+
+	// Size is the serialized size including the witness.
+	Size        int
+	VirtualSize int
+	Weight      int
+
+	// InputValue, Fee and FeeRate are only set when every spent output is
+	// known. FeeRate is in satoshis per 1000 virtual bytes.
+	InputValue  Amount
+	OutputValue Amount
+	Fee         Amount
+	FeeRate     Amount
+
+	// Known is set if the network already knows the transaction.
+	Known bool
+
+	Violations []PolicyViolation
+}
+
+// OK reports whether there are no violations.
+func (r *PolicyReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+func (r *PolicyReport) violate(rule PolicyRule, index int, format string,
+	args ...interface{}) {
+	r.Violations = append(r.Violations,
+		PolicyViolation{rule, index, fmt.Sprintf(format, args...)})
+}
+
+// blocking returns the violations not allowed by p.
+func (r *PolicyReport) blocking(p SendPolicy) []PolicyViolation {
+	allowed := map[PolicyRule]bool{}
+	for _, rule := range p.Allow {
+		allowed[rule] = true
+	}
+	violations := []PolicyViolation{}
+	for _, v := range r.Violations {
+		if !allowed[v.Rule] {
+			violations = append(violations, v)
+		}
+	}
+	return violations
+}
+
+// PolicyError is returned by SendTransaction when a transaction violates the
+// send policy. Nothing is sent.
+type PolicyError struct {
+	Report *PolicyReport
+
+	// Violations are the violations that stopped the send.
+	Violations []PolicyViolation
+}
+
+func (e *PolicyError) Error() string {
+	s := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		s[i] = v.String()
+	}
+	return "transaction violates send policy: " + strings.Join(s, "; ")
+}
+
+// WithSendPolicy returns a shallow copy of c whose SendTransaction checks
+// transactions with CheckTransaction first and refuses to send them if they
+// violate p. A nil p turns the checks off, which can be used to override
+// them for a single send. Copies share the cache, request coalescing and
+// statistics of c.
+func (c *Chain) WithSendPolicy(p *SendPolicy) *Chain {
+	copied := *c
+	if p != nil {
+		policy := *p
+		copied.sendPolicy = &policy
+	} else {
+		copied.sendPolicy = nil
+	}
+	return &copied
+}
+
+// dustThreshold returns the smallest value of out that is not dust.
+func dustThreshold(out RawOutput, dustRelayFeeRate Amount) Amount {
+	if ScriptType(out.Script) == NullDataScript {
+		return 0
+	}
+	size := 8 + len(writeVarInt(nil, uint64(len(out.Script)))) +
+		len(out.Script)
+	// The size of an input spending out: outpoint, script length and
+	// sequence plus a typical signature script or witness.
+	if _, _, ok := witnessProgram(out.Script); ok {
+		size += 32 + 4 + 1 + 107/4 + 4
+	} else {
+		size += 32 + 4 + 1 + 107 + 4
+	}
+	return Amount(size) * dustRelayFeeRate / 1000
+}
+
+// CheckTransaction decodes a transaction in hex format and checks it against
+// the standardness rules nodes apply before relaying it and the fee limits of
+// p. The outputs it spends are looked up with GetTransactionMulti to find
+// missing and spent inputs and to compute the fee. The returned error is only
+// set if the lookups fail; violations are listed in the report.
+func (c *Chain) CheckTransaction(hex string, p SendPolicy) (*PolicyReport,
+	error) {
+	p = p.withDefaults()
+	report := &PolicyReport{}
+	tx, err := DecodeRawTransaction(hex)
+	if err != nil {
+		report.violate(RuleDecode, -1, "%v", err)
+		return report, nil
+	}
+	if report.TransactionHash, err = tx.Hash(); err != nil {
+		return nil, err
+	}
+	full, _ := tx.MarshalBinary()
+	base, _ := tx.encode(false)
+	report.Size = len(full)
+	report.Weight, _ = tx.Weight()
+	report.VirtualSize, _ = tx.VirtualSize()
+
+	checkStructure(tx, report)
+	switch {
+	case report.Weight > MaxStandardWeight:
+		report.violate(RuleWeight, -1, "weight %d is above %d",
+			report.Weight, MaxStandardWeight)
+	case len(base) < minStandardSize:
+		report.violate(RuleWeight, -1, "size %d without witness is below %d",
+			len(base), minStandardSize)
+	}
+	checkScripts(tx, p, report)
+
+	if len(tx.Inputs) == 0 {
+		return report, nil
+	}
+	if err := c.checkInputs(tx, p, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// checkStructure checks the consensus rules that do not need the spent
+// outputs.
+func checkStructure(tx *RawTransaction, report *PolicyReport) {
+	if len(tx.Inputs) == 0 {
+		report.violate(RuleInvalid, -1, "no inputs")
+	}
+	if len(tx.Outputs) == 0 {
+		report.violate(RuleInvalid, -1, "no outputs")
+	}
+	spent := map[string]bool{}
+	for i, in := range tx.Inputs {
+		outpoint := fmt.Sprint(in.PreviousHash, ":", in.PreviousIndex)
+		if spent[outpoint] {
+			report.violate(RuleInvalid, i, "input %d spends %s twice",
+				i, outpoint)
+		}
+		spent[outpoint] = true
+	}
+	for i, out := range tx.Outputs {
+		if out.Value < 0 || out.Value > MaxAmount {
+			report.violate(RuleInvalid, i, "output %d value %d is out of range",
+				i, int64(out.Value))
+			continue
+		}
+		report.OutputValue += out.Value
+	}
+	if report.OutputValue > MaxAmount {
+		report.violate(RuleInvalid, -1, "total output value %s is out of range",
+			report.OutputValue)
+	}
+}
+
+// checkScripts checks the standardness of input and output scripts and
+// dust outputs.
+func checkScripts(tx *RawTransaction, p SendPolicy, report *PolicyReport) {
+	for i, in := range tx.Inputs {
+		ops, err := parseScript(in.ScriptSig)
+		switch {
+		case len(in.ScriptSig) > maxStandardScriptSigSize:
+			report.violate(RuleNonStandardScript, i,
+				"input %d script size %d is above %d", i, len(in.ScriptSig),
+				maxStandardScriptSigSize)
+		case err != nil || !isPushOnly(ops):
+			report.violate(RuleNonStandardScript, i,
+				"input %d script does not only push data", i)
+		}
+	}
+
+	nullData := 0
+	for i, out := range tx.Outputs {
+		switch ScriptType(out.Script) {
+		case NonStandardScript:
+			report.violate(RuleNonStandardScript, i,
+				"output %d script is not standard", i)
+			continue
+		case NullDataScript:
+			nullData++
+			if len(out.Script) > maxNullDataSize {
+				report.violate(RuleNonStandardScript, i,
+					"output %d data size %d is above %d", i, len(out.Script),
+					maxNullDataSize)
+			} else if nullData == 2 {
+				report.violate(RuleNonStandardScript, i,
+					"output %d is a second data output", i)
+			}
+		case MultisigScript:
+			ops, _ := parseScript(out.Script)
+			if _, keys, _ := parseMultisig(ops); len(keys) > maxBareMultisigKeys {
+				report.violate(RuleNonStandardScript, i,
+					"output %d is bare multisig with %d keys", i, len(keys))
+			}
+		}
+		if dust := dustThreshold(out, p.DustRelayFeeRate); out.Value < dust {
+			report.violate(RuleDust, i,
+				"output %d value %d is below the dust threshold %d",
+				i, int64(out.Value), int64(dust))
+		}
+	}
+}
+
+// checkInputs looks up the outputs spent by tx, checks they exist and are
+// unspent and, if they all exist, checks the fee.
+func (c *Chain) checkInputs(tx *RawTransaction, p SendPolicy,
+	report *PolicyReport) error {
+	// The transaction itself is looked up too: if it is already known its
+	// inputs are spent by it, which is not a conflict.
+	hashes, index := []string{report.TransactionHash}, map[string]int{}
+	for _, in := range tx.Inputs {
+		if _, ok := index[in.PreviousHash]; !ok {
+			index[in.PreviousHash] = len(hashes)
+			hashes = append(hashes, in.PreviousHash)
+		}
+	}
+	txns, err := c.GetTransactionMulti(hashes)
+	errs, _ := err.(MultiError)
+	if err != nil && errs == nil {
+		return err
+	}
+	found := make([]bool, len(hashes))
+	for i := range hashes {
+		switch {
+		case errs == nil || errs[i] == nil:
+			found[i] = true
+		case !IsNotFound(errs[i]):
+			return errs[i]
+		}
+	}
+	report.Known = found[0]
+
+	complete := true
+	for i, in := range tx.Inputs {
+		j := index[in.PreviousHash]
+		if !found[j] || int(in.PreviousIndex) >= len(txns[j].Outputs) {
+			report.violate(RuleMissingInput, i, "input %d spends unknown "+
+				"output %s:%d", i, in.PreviousHash, in.PreviousIndex)
+			complete = false
+			continue
+		}
+		prev := txns[j].Outputs[in.PreviousIndex]
+		if prev.Spent && !report.Known {
+			report.violate(RuleSpentInput, i, "input %d spends output %s:%d "+
+				"which is already spent", i, in.PreviousHash, in.PreviousIndex)
+		}
+		report.InputValue += prev.Value
+	}
+	if !complete {
+		report.InputValue = 0
+		return nil
+	}
+
+	report.Fee = report.InputValue - report.OutputValue
+	if report.Fee < 0 {
+		report.violate(RuleInvalid, -1, "outputs %s are worth more than "+
+			"inputs %s", report.OutputValue, report.InputValue)
+		return nil
+	}
+	report.FeeRate = report.Fee * 1000 / Amount(report.VirtualSize)
+	switch {
+	case report.FeeRate < p.MinFeeRate:
+		report.violate(RuleLowFee, -1, "fee rate %d sat/kvB is below %d",
+			int64(report.FeeRate), int64(p.MinFeeRate))
+	case report.FeeRate > p.MaxFeeRate:
+		report.violate(RuleAbsurdFee, -1, "fee rate %d sat/kvB is above %d",
+			int64(report.FeeRate), int64(p.MaxFeeRate))
+	}
+	if p.MaxFee > 0 && report.Fee > p.MaxFee {
+		report.violate(RuleAbsurdFee, -1, "fee %s is above %s",
+			report.Fee, p.MaxFee)
+	}
+	return nil
+}
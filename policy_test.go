@@ -0,0 +1,165 @@
+package chain_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/qedus/chain"
+)
+
+// policyTransaction spends output 0 of the transaction "funding", worth
+// 100000 satoshis, paying value to testAddress.
+func policyTransaction(t *testing.T, value chain.Amount) *chain.RawTransaction {
+	a, err := chain.DecodeAddress(testAddress, chain.MainNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A typical P2PKH signature script: a signature and a public key.
+	scriptSig := append([]byte{72}, make([]byte, 72)...)
+	scriptSig = append(scriptSig, 33)
+	scriptSig = append(scriptSig, make([]byte, 33)...)
+	return &chain.RawTransaction{
+		Version: 1,
+		Inputs: []chain.RawInput{{
+			PreviousHash: fakeHash("tx", "funding"),
+			ScriptSig:    scriptSig,
+			Sequence:     0xffffffff,
+		}},
+		Outputs: []chain.RawOutput{{Value: value, Script: a.Script()}},
+	}
+}
+
+func rawHex(t *testing.T, tx *chain.RawTransaction) string {
+	b, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func violatedRules(r *chain.PolicyReport) []chain.PolicyRule {
+	rules := []chain.PolicyRule{}
+	for _, v := range r.Violations {
+		rules = append(rules, v.Rule)
+	}
+	return rules
+}
+
+func TestCheckTransaction(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 1)
+	api.mine(payment("funding", otherAddress, testAddress, 100000))
+	c := newTestChain(t, chain.MainNet, api)
+
+	report, err := c.CheckTransaction(rawHex(t, policyTransaction(t, 90000)),
+		chain.SendPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.InputValue != 100000 || report.Fee != 10000 ||
+		report.VirtualSize != 192 || report.FeeRate != 10000*1000/192 {
+		t.Fatal("unexpected report", report)
+	}
+
+	for _, tc := range []struct {
+		modify func(tx *chain.RawTransaction)
+		policy chain.SendPolicy
+		rule   chain.PolicyRule
+	}{
+		{func(tx *chain.RawTransaction) { tx.Outputs[0].Value = 545 },
+			chain.SendPolicy{MaxFeeRate: chain.BTC}, chain.RuleDust},
+		{func(tx *chain.RawTransaction) { tx.Outputs[0].Script = []byte{0x51} },
+			chain.SendPolicy{}, chain.RuleNonStandardScript},
+		{func(tx *chain.RawTransaction) {
+			tx.Inputs[0].ScriptSig = []byte{0x76}
+		}, chain.SendPolicy{}, chain.RuleNonStandardScript},
+		{func(tx *chain.RawTransaction) { tx.Outputs[0].Value = 99900 },
+			chain.SendPolicy{}, chain.RuleLowFee},
+		{func(tx *chain.RawTransaction) { tx.Outputs[0].Value = 100001 },
+			chain.SendPolicy{}, chain.RuleInvalid},
+		{nil, chain.SendPolicy{MaxFee: 5000}, chain.RuleAbsurdFee},
+		{nil, chain.SendPolicy{MaxFeeRate: 10000}, chain.RuleAbsurdFee},
+		{func(tx *chain.RawTransaction) {
+			tx.Inputs[0].PreviousHash = fakeHash("unknown")
+		}, chain.SendPolicy{}, chain.RuleMissingInput},
+		{func(tx *chain.RawTransaction) { tx.Inputs[0].PreviousIndex = 5 },
+			chain.SendPolicy{}, chain.RuleMissingInput},
+		{func(tx *chain.RawTransaction) {
+			tx.Inputs = append(tx.Inputs, tx.Inputs[0])
+		}, chain.SendPolicy{}, chain.RuleInvalid},
+	} {
+		tx := policyTransaction(t, 90000)
+		if tc.modify != nil {
+			tc.modify(tx)
+		}
+		report, err := c.CheckTransaction(rawHex(t, tx), tc.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rules := violatedRules(report); len(rules) != 1 ||
+			rules[0] != tc.rule {
+			t.Fatal("unexpected violations", tc.rule, report.Violations)
+		}
+	}
+
+	report, err = c.CheckTransaction("0100", chain.SendPolicy{})
+	if err != nil || len(report.Violations) != 1 ||
+		report.Violations[0].Rule != chain.RuleDecode {
+		t.Fatal("expected decode violation", report, err)
+	}
+}
+
+func TestCheckTransactionSpent(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 1)
+	funding := payment("funding", otherAddress, testAddress, 100000)
+	funding.Outputs[0].Spent = true
+	api.mine(funding)
+	c := newTestChain(t, chain.MainNet, api)
+
+	tx := policyTransaction(t, 90000)
+	report, err := c.CheckTransaction(rawHex(t, tx), chain.SendPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules := violatedRules(report); len(rules) != 1 ||
+		rules[0] != chain.RuleSpentInput || report.Known {
+		t.Fatal("expected spent input", report.Violations)
+	}
+
+	// The output is spent by the transaction itself once it is known.
+	hash, _ := tx.Hash()
+	api.addTransaction(chain.Transaction{Hash: hash})
+	report, err = c.CheckTransaction(rawHex(t, tx), chain.SendPolicy{})
+	if err != nil || !report.OK() || !report.Known {
+		t.Fatal("expected known transaction", report, err)
+	}
+}
+
+func TestSendTransactionPolicy(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 1)
+	api.mine(payment("funding", otherAddress, testAddress, 100000))
+	c := newTestChain(t, chain.MainNet, api).WithSendPolicy(&chain.SendPolicy{})
+
+	dust := rawHex(t, policyTransaction(t, 100))
+	_, err := c.SendTransaction(dust)
+	policyErr, ok := err.(*chain.PolicyError)
+	if !ok || len(policyErr.Violations) != 1 ||
+		policyErr.Violations[0].Rule != chain.RuleDust {
+		t.Fatal("expected policy error", err)
+	}
+	if len(api.sent) != 0 {
+		t.Fatal("transaction sent", api.sent)
+	}
+
+	allowed := c.WithSendPolicy(&chain.SendPolicy{
+		Allow: []chain.PolicyRule{chain.RuleDust},
+	})
+	if _, err := allowed.SendTransaction(dust); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WithSendPolicy(nil).SendTransaction(dust); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.sent) != 2 {
+		t.Fatal("expected two sends", api.sent)
+	}
+}
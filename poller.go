@@ -0,0 +1,331 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultPollInterval is the default time a Poller waits between polls.
+const DefaultPollInterval = 30 * time.Second
+
+// EventSource delivers notification events to EventHandlers until its
+// context is done.
+type EventSource interface {
+	Run(ctx context.Context) error
+}
+
+// Poller is an EventSource that produces the same typed events a
+// WebhookHandler would by polling the API instead of receiving webhooks. It
+// is meant for environments that cannot accept inbound connections, such as
+// development machines and CI.
+//
+// New blocks are found with a Follower and delivered as BlockEvents. If
+// NewTransactions is set, each transaction in a new block is also delivered
+// as a TransactionEvent; unlike webhooks, unconfirmed transactions are not
+// seen. Activity on Addresses is delivered as AddressEvents when a
+// transaction is first seen and again when it first confirms.
+//
+// Events are delivered at least once: if a callback returns an error the
+// poll stops and the same events are produced again on the next poll.
+type Poller struct {
+	Handlers EventHandlers
+
+	// Addresses are watched for AddressEvents.
+	Addresses []string
+
+	// NewTransactions enables TransactionEvents for transactions in new
+	// blocks. This makes one request per transaction.
+	NewTransactions bool
+
+	// AddressTransactionsLimit is the number of recent transactions fetched
+	// per poll for Addresses. Activity is missed if more transactions than
+	// this happen between polls. It defaults to
+	// DefaultAddressTransactionsLimit.
+	AddressTransactionsLimit int
+
+	// Interval is the time Run waits between polls. It defaults to
+	// DefaultPollInterval.
+	Interval time.Duration
+
+	chain    *Chain
+	follower *Follower
+
+	// seen maps the hashes of recent address transactions to whether they
+	// were confirmed. It is nil until the first address poll.
+	seen map[string]bool
+}
+
+// NewPoller creates a Poller that delivers events to h. The block position
+// is persisted in store so polling resumes where it left off.
+func NewPoller(c *Chain, store CheckpointStore, h EventHandlers) *Poller {
+	p := &Poller{Handlers: h, chain: c}
+	p.follower = NewFollower(c, store, p.blockEvent)
+	return p
+}
+
+func (p *Poller) header(ty NotificationType, id string) EventHeader {
+	return EventHeader{
+		ID:              fmt.Sprintf("poll-%s-%s", ty, id),
+		CreatedAt:       time.Now().UTC(),
+		DeliveryAttempt: 1,
+		Type:            ty,
+		BlockChain:      p.chain.network,
+	}
+}
+
+func (p *Poller) blockEvent(e FollowerEvent) error {
+	if e.Type != BlockConnected {
+		return nil
+	}
+	if err := p.Handlers.Dispatch(&BlockEvent{
+		EventHeader: p.header(NewBlockNotification, e.Block.Hash),
+		Block:       e.Block,
+	}); err != nil {
+		return err
+	}
+
+	if !p.NewTransactions || p.Handlers.NewTransaction == nil {
+		return nil
+	}
+	txns, err := p.chain.GetTransactionMulti(e.Block.TransactionHashes)
+	if err != nil {
+		return err
+	}
+	for _, tx := range txns {
+		if err := p.Handlers.Dispatch(&TransactionEvent{
+			EventHeader: p.header(NewTransactionNotification, tx.Hash),
+			Transaction: tx,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addressEvent summarizes the effect of tx on address.
+func (p *Poller) addressEvent(tx Transaction, address string) *AddressEvent {
+	e := &AddressEvent{
+		EventHeader: p.header(AddressNotification, fmt.Sprintf("%s-%s-%d",
+			address, tx.Hash, tx.Confirmations)),
+		Address:         address,
+		TransactionHash: tx.Hash,
+		BlockHash:       tx.BlockHash,
+		Confirmations:   tx.Confirmations,
+	}
+	inputs, outputs := map[string]bool{}, map[string]bool{}
+	for _, in := range tx.Inputs {
+		for _, a := range in.Addresses {
+			if a == address {
+				e.Sent += in.Value
+			}
+			if !inputs[a] {
+				inputs[a] = true
+				e.InputAddresses = append(e.InputAddresses, a)
+			}
+		}
+	}
+	for _, out := range tx.Outputs {
+		for _, a := range out.Addresses {
+			if a == address {
+				e.Received += out.Value
+			}
+			if !outputs[a] {
+				outputs[a] = true
+				e.OutputAddresses = append(e.OutputAddresses, a)
+			}
+		}
+	}
+	return e
+}
+
+func (p *Poller) pollAddresses() error {
+	if len(p.Addresses) == 0 {
+		return nil
+	}
+	txns, err := p.chain.GetAddressTransactionsMulti(p.Addresses,
+		p.AddressTransactionsLimit)
+	if err != nil {
+		return err
+	}
+
+	// The first poll only records existing activity, as a newly created
+	// webhook would not deliver it either.
+	first := p.seen == nil
+	seen := make(map[string]bool, len(txns))
+	watched := make(map[string]bool, len(p.Addresses))
+	for _, a := range p.Addresses {
+		watched[a] = true
+	}
+
+	for i := len(txns) - 1; i >= 0; i-- {
+		tx := txns[i]
+		confirmed := tx.Confirmations > 0
+		wasConfirmed, wasSeen := p.seen[tx.Hash]
+		if !first && (!wasSeen || (confirmed && !wasConfirmed)) {
+			for _, a := range p.transactionAddresses(tx, watched) {
+				if err := p.Handlers.Dispatch(p.addressEvent(tx, a)); err != nil {
+					return err
+				}
+			}
+		}
+		seen[tx.Hash] = confirmed
+	}
+	p.seen = seen
+	return nil
+}
+
+// transactionAddresses returns the watched addresses involved in tx in a
+// stable order.
+func (p *Poller) transactionAddresses(tx Transaction,
+	watched map[string]bool) []string {
+	involved := map[string]bool{}
+	for _, in := range tx.Inputs {
+		for _, a := range in.Addresses {
+			involved[a] = watched[a]
+		}
+	}
+	for _, out := range tx.Outputs {
+		for _, a := range out.Addresses {
+			involved[a] = watched[a]
+		}
+	}
+	addresses := []string{}
+	for _, a := range p.Addresses {
+		if involved[a] {
+			addresses = append(addresses, a)
+			involved[a] = false
+		}
+	}
+	return addresses
+}
+
+// Poll checks for new blocks and address activity once, delivering any
+// events.
+func (p *Poller) Poll() error {
+	if err := p.follower.Poll(); err != nil {
+		return err
+	}
+	return p.pollAddresses()
+}
+
+// Run polls until ctx is done, waiting Interval between polls. It returns
+// the first error from Poll or ctx.Err().
+func (p *Poller) Run(ctx context.Context) error {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+
+	for {
+		if err := p.Poll(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// WebhookServer is an EventSource that serves a WebhookHandler over HTTP.
+type WebhookServer struct {
+	Addr    string
+	Path    string
+	Handler *WebhookHandler
+}
+
+// Run listens on Addr and serves the handler on Path until ctx is done.
+func (s *WebhookServer) Run(ctx context.Context) error {
+	mux := http.NewServeMux()
+	path := s.Path
+	if path == "" {
+		path = "/"
+	}
+	mux.Handle(path, s.Handler)
+
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: mux}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.Serve(l)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(),
+			5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+		return ctx.Err()
+	}
+}
+
+// EventDelivery selects how an EventSource receives events.
+type EventDelivery string
+
+const (
+	// WebhookDelivery receives events as Chain.com webhooks.
+	WebhookDelivery EventDelivery = "webhook"
+
+	// PollingDelivery produces events by polling the API.
+	PollingDelivery EventDelivery = "polling"
+)
+
+// EventSourceConfig configures NewEventSource. Delivery selects which of the
+// other fields are used, so applications can switch between webhooks and
+// polling with a single setting.
+type EventSourceConfig struct {
+	Delivery EventDelivery
+
+	// Webhook delivery settings. See WebhookHandler.
+	ListenAddr string
+	Path       string
+	Token      string
+	Verify     bool
+	Dedup      DedupStore
+
+	// Polling delivery settings. See Poller.
+	Addresses       []string
+	NewTransactions bool
+	PollInterval    time.Duration
+	Checkpoints     CheckpointStore
+}
+
+// NewEventSource creates the EventSource selected by cfg.Delivery delivering
+// events to h.
+func NewEventSource(c *Chain, cfg EventSourceConfig,
+	h EventHandlers) (EventSource, error) {
+	switch cfg.Delivery {
+	case WebhookDelivery:
+		if cfg.ListenAddr == "" {
+			return nil, errors.New("webhook delivery requires a listen address")
+		}
+		wh := NewWebhookHandler(h)
+		wh.Token, wh.Dedup = cfg.Token, cfg.Dedup
+		if cfg.Verify {
+			wh.Verify = c
+		}
+		return &WebhookServer{cfg.ListenAddr, cfg.Path, wh}, nil
+	case PollingDelivery:
+		store := cfg.Checkpoints
+		if store == nil {
+			store = &MemoryCheckpointStore{}
+		}
+		p := NewPoller(c, store, h)
+		p.Addresses = cfg.Addresses
+		p.NewTransactions = cfg.NewTransactions
+		p.Interval = cfg.PollInterval
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown event delivery %q", cfg.Delivery)
+}
@@ -0,0 +1,144 @@
+package chain_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/qedus/chain"
+)
+
+const (
+	testAddress  = "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"
+	otherAddress = "1BoatSLRHtKNngkdXEeobR76b53LETtpyT"
+)
+
+// payment creates a transaction paying value to the to address from an
+// output of the from address.
+func payment(name string, from, to string, value chain.Amount) chain.Transaction {
+	return chain.Transaction{
+		Hash: fakeHash("tx", name),
+		Inputs: []chain.Input{{
+			OutputHash: fakeHash("funding", name),
+			Value:      value + 1000,
+			Addresses:  []string{from},
+		}},
+		Outputs: []chain.Output{
+			{Value: value, Addresses: []string{to}},
+			{Value: 0, Addresses: []string{from}},
+		},
+		Amount: value,
+		Fees:   1000,
+	}
+}
+
+func TestPoller(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 10)
+	api.addTransaction(payment("old", otherAddress, testAddress, 1))
+	c := newTestChain(t, chain.MainNet, api)
+
+	events := []string{}
+	p := chain.NewPoller(c, &chain.MemoryCheckpointStore{}, chain.EventHandlers{
+		NewBlock: func(e *chain.BlockEvent) error {
+			events = append(events, fmt.Sprint("block ", e.Block.Height))
+			return nil
+		},
+		NewTransaction: func(e *chain.TransactionEvent) error {
+			events = append(events, fmt.Sprintf("tx %.8s", e.Transaction.Hash))
+			return nil
+		},
+		Address: func(e *chain.AddressEvent) error {
+			if e.BlockChain != chain.MainNet ||
+				e.Type != chain.AddressNotification {
+				t.Fatal("unexpected event header", e.EventHeader)
+			}
+			events = append(events, fmt.Sprintf("address %.8s %d %d",
+				e.TransactionHash, e.Received, e.Confirmations))
+			return nil
+		},
+	})
+	p.Addresses = []string{testAddress}
+	p.NewTransactions = true
+
+	if err := p.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(events) != "[block 10]" {
+		t.Fatal("expected only the latest block", events)
+	}
+	events = nil
+
+	tx := payment("new", otherAddress, testAddress, 5000)
+	api.addTransaction(tx)
+	if err := p.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(events) != fmt.Sprintf("[address %.8s 5000 0]", tx.Hash) {
+		t.Fatal("expected unconfirmed address event", events)
+	}
+	events = nil
+
+	api.mine(tx)
+	if err := p.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("[block 11 tx %.8s address %.8s 5000 1]",
+		tx.Hash, tx.Hash)
+	if fmt.Sprint(events) != expected {
+		t.Fatal("unexpected events", events)
+	}
+	events = nil
+
+	if err := p.Poll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatal("expected no events", events)
+	}
+}
+
+func TestNewEventSource(t *testing.T) {
+	api := newFakeAPI(t, chain.TestNet3, 3)
+	c := newTestChain(t, chain.TestNet3, api)
+
+	blocks := make(chan int64, 1)
+	source, err := chain.NewEventSource(c, chain.EventSourceConfig{
+		Delivery:     chain.PollingDelivery,
+		PollInterval: time.Millisecond,
+	}, chain.EventHandlers{
+		NewBlock: func(e *chain.BlockEvent) error {
+			select {
+			case blocks <- e.Block.Height:
+			default:
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- source.Run(ctx)
+	}()
+	if height := <-blocks; height != 3 {
+		t.Fatal("unexpected block height", height)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatal("expected context canceled", err)
+	}
+
+	if _, err := chain.NewEventSource(c, chain.EventSourceConfig{
+		Delivery: chain.WebhookDelivery,
+	}, chain.EventHandlers{}); err == nil {
+		t.Fatal("expected error without listen address")
+	}
+	if _, err := chain.NewEventSource(c, chain.EventSourceConfig{},
+		chain.EventHandlers{}); err == nil {
+		t.Fatal("expected error for unknown delivery")
+	}
+}
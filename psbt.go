@@ -0,0 +1,939 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Partially signed transactions are specified by BIP 174, version 0, and
+// BIP 370, version 2.
+
+const psbtMagic = "psbt\xff"
+
+// PSBT key types.
+const (
+	psbtGlobalUnsignedTx       = 0x00
+	psbtGlobalTxVersion        = 0x02
+	psbtGlobalFallbackLockTime = 0x03
+	psbtGlobalInputCount       = 0x04
+	psbtGlobalOutputCount      = 0x05
+	psbtGlobalVersion          = 0xfb
+
+	psbtInNonWitnessUTXO         = 0x00
+	psbtInWitnessUTXO            = 0x01
+	psbtInPartialSig             = 0x02
+	psbtInSighashType            = 0x03
+	psbtInRedeemScript           = 0x04
+	psbtInWitnessScript          = 0x05
+	psbtInBIP32Derivation        = 0x06
+	psbtInFinalScriptSig         = 0x07
+	psbtInFinalScriptWitness     = 0x08
+	psbtInPreviousTxID           = 0x0e
+	psbtInOutputIndex            = 0x0f
+	psbtInSequence               = 0x10
+	psbtInRequiredTimeLockTime   = 0x11
+	psbtInRequiredHeightLockTime = 0x12
+	psbtInTapKeySig              = 0x13
+	psbtInTapMerkleRoot          = 0x18
+
+	psbtOutAmount = 0x03
+	psbtOutScript = 0x04
+)
+
+// psbtMap is a PSBT key-value map keyed by the full key, type byte first.
+type psbtMap map[string][]byte
+
+func (m psbtMap) get(keyType byte) ([]byte, bool) {
+	v, ok := m[string([]byte{keyType})]
+	return v, ok
+}
+
+func (m psbtMap) set(keyType byte, keyData, value []byte) {
+	m[string(append([]byte{keyType}, keyData...))] = value
+}
+
+// deleteTypes removes every key with one of the types.
+func (m psbtMap) deleteTypes(keyTypes ...byte) {
+	for k := range m {
+		for _, t := range keyTypes {
+			if k[0] == t {
+				delete(m, k)
+			}
+		}
+	}
+}
+
+func (m psbtMap) copy() psbtMap {
+	c := make(psbtMap, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// PSBT is a partially signed Bitcoin transaction. It carries an unsigned
+// transaction along with the data signers need, such as the outputs spent,
+// and the signatures collected so far. Fields this package does not use are
+// kept as they are.
+type PSBT struct {
+	global  psbtMap
+	inputs  []psbtMap
+	outputs []psbtMap
+}
+
+// NewPSBT creates a PSBT of the given version, 0 or 2, for the unsigned
+// transaction tx.
+func NewPSBT(tx *RawTransaction, version uint32) (*PSBT, error) {
+	for i, in := range tx.Inputs {
+		if len(in.ScriptSig) > 0 || len(in.Witness) > 0 {
+			return nil, fmt.Errorf("input %d is signed", i)
+		}
+	}
+	p := &PSBT{
+		global:  psbtMap{},
+		inputs:  make([]psbtMap, len(tx.Inputs)),
+		outputs: make([]psbtMap, len(tx.Outputs)),
+	}
+	for i := range p.inputs {
+		p.inputs[i] = psbtMap{}
+	}
+	for i := range p.outputs {
+		p.outputs[i] = psbtMap{}
+	}
+
+	switch version {
+	case 0:
+		b, err := tx.encode(false)
+		if err != nil {
+			return nil, err
+		}
+		p.global.set(psbtGlobalUnsignedTx, nil, b)
+	case 2:
+		p.global.set(psbtGlobalVersion, nil, uint32Bytes(2))
+		p.global.set(psbtGlobalTxVersion, nil, uint32Bytes(uint32(tx.Version)))
+		p.global.set(psbtGlobalFallbackLockTime, nil, uint32Bytes(tx.LockTime))
+		p.global.set(psbtGlobalInputCount, nil,
+			writeVarInt(nil, uint64(len(tx.Inputs))))
+		p.global.set(psbtGlobalOutputCount, nil,
+			writeVarInt(nil, uint64(len(tx.Outputs))))
+		for i, in := range tx.Inputs {
+			h, err := hashFromHex(in.PreviousHash)
+			if err != nil {
+				return nil, err
+			}
+			p.inputs[i].set(psbtInPreviousTxID, nil, h[:])
+			p.inputs[i].set(psbtInOutputIndex, nil, uint32Bytes(in.PreviousIndex))
+			p.inputs[i].set(psbtInSequence, nil, uint32Bytes(in.Sequence))
+		}
+		for i, out := range tx.Outputs {
+			p.outputs[i].set(psbtOutAmount, nil,
+				binary.LittleEndian.AppendUint64(nil, uint64(out.Value)))
+			p.outputs[i].set(psbtOutScript, nil, out.Script)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PSBT version %d", version)
+	}
+	return p, nil
+}
+
+func uint32Bytes(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+// Version returns the PSBT version, 0 or 2.
+func (p *PSBT) Version() uint32 {
+	if v, ok := p.global.get(psbtGlobalVersion); ok && len(v) == 4 {
+		return binary.LittleEndian.Uint32(v)
+	}
+	return 0
+}
+
+// UnsignedTransaction returns the transaction being signed, without any
+// signatures.
+func (p *PSBT) UnsignedTransaction() (*RawTransaction, error) {
+	if p.Version() == 0 {
+		b, _ := p.global.get(psbtGlobalUnsignedTx)
+		tx := &RawTransaction{}
+		if err := tx.UnmarshalBinary(b); err != nil {
+			return nil, err
+		}
+		return tx, nil
+	}
+
+	v, _ := p.global.get(psbtGlobalTxVersion)
+	tx := &RawTransaction{Version: int32(binary.LittleEndian.Uint32(v))}
+	for _, in := range p.inputs {
+		var h [hashSize]byte
+		txid, _ := in.get(psbtInPreviousTxID)
+		copy(h[:], txid)
+		index, _ := in.get(psbtInOutputIndex)
+		raw := RawInput{
+			PreviousHash:  hashToHex(h),
+			PreviousIndex: binary.LittleEndian.Uint32(index),
+			Sequence:      0xffffffff,
+		}
+		if seq, ok := in.get(psbtInSequence); ok {
+			raw.Sequence = binary.LittleEndian.Uint32(seq)
+		}
+		tx.Inputs = append(tx.Inputs, raw)
+	}
+	for _, out := range p.outputs {
+		amount, _ := out.get(psbtOutAmount)
+		script, _ := out.get(psbtOutScript)
+		tx.Outputs = append(tx.Outputs, RawOutput{
+			Value:  Amount(binary.LittleEndian.Uint64(amount)),
+			Script: script,
+		})
+	}
+	lockTime, err := p.lockTime()
+	if err != nil {
+		return nil, err
+	}
+	tx.LockTime = lockTime
+	return tx, nil
+}
+
+// lockTime determines the lock time of a version 2 PSBT as specified by
+// BIP 370: the largest required lock time of the type every input that
+// requires one supports, preferring heights, or the fallback lock time.
+func (p *PSBT) lockTime() (uint32, error) {
+	canHeight, canTime, any := true, true, false
+	maxHeight, maxTime := uint32(0), uint32(0)
+	for _, in := range p.inputs {
+		height, hasHeight := in.get(psbtInRequiredHeightLockTime)
+		t, hasTime := in.get(psbtInRequiredTimeLockTime)
+		if !hasHeight && !hasTime {
+			continue
+		}
+		any = true
+		if hasHeight {
+			if v := binary.LittleEndian.Uint32(height); v > maxHeight {
+				maxHeight = v
+			}
+		} else {
+			canHeight = false
+		}
+		if hasTime {
+			if v := binary.LittleEndian.Uint32(t); v > maxTime {
+				maxTime = v
+			}
+		} else {
+			canTime = false
+		}
+	}
+	switch {
+	case !any:
+		if v, ok := p.global.get(psbtGlobalFallbackLockTime); ok {
+			return binary.LittleEndian.Uint32(v), nil
+		}
+		return 0, nil
+	case canHeight:
+		return maxHeight, nil
+	case canTime:
+		return maxTime, nil
+	}
+	return 0, errors.New("inputs require incompatible lock times")
+}
+
+// writePSBTMap appends m to b in key order followed by the separator.
+func writePSBTMap(b []byte, m psbtMap) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b = writeVarInt(b, uint64(len(k)))
+		b = append(b, k...)
+		b = writeVarInt(b, uint64(len(m[k])))
+		b = append(b, m[k]...)
+	}
+	return append(b, 0x00)
+}
+
+func readPSBTMap(r *wireReader) (psbtMap, error) {
+	m := psbtMap{}
+	for {
+		key := r.readVarBytes()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if len(key) == 0 {
+			return m, nil
+		}
+		value := r.readVarBytes()
+		if r.err != nil {
+			return nil, r.err
+		}
+		if _, ok := m[string(key)]; ok {
+			return nil, fmt.Errorf("duplicate PSBT key %x", key)
+		}
+		m[string(key)] = append([]byte(nil), value...)
+	}
+}
+
+// MarshalBinary encodes p in the binary PSBT format.
+func (p *PSBT) MarshalBinary() ([]byte, error) {
+	b := writePSBTMap([]byte(psbtMagic), p.global)
+	for _, m := range p.inputs {
+		b = writePSBTMap(b, m)
+	}
+	for _, m := range p.outputs {
+		b = writePSBTMap(b, m)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a PSBT in the binary format.
+func (p *PSBT) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(psbtMagic)) {
+		return errors.New("missing PSBT magic bytes")
+	}
+	r := &wireReader{b: data[len(psbtMagic):]}
+	global, err := readPSBTMap(r)
+	if err != nil {
+		return err
+	}
+	if v, ok := global.get(psbtGlobalVersion); ok && len(v) != 4 {
+		return errors.New("PSBT has an invalid version")
+	}
+	decoded := &PSBT{global: global}
+
+	var inputs, outputs int
+	switch version := decoded.Version(); version {
+	case 0:
+		for _, t := range []byte{psbtGlobalTxVersion,
+			psbtGlobalFallbackLockTime, psbtGlobalInputCount,
+			psbtGlobalOutputCount} {
+			if _, ok := global.get(t); ok {
+				return fmt.Errorf("PSBT version 0 has version 2 field %#x", t)
+			}
+		}
+		tx, err := decoded.UnsignedTransaction()
+		if err != nil {
+			return fmt.Errorf("invalid PSBT unsigned transaction: %v", err)
+		}
+		for i, in := range tx.Inputs {
+			if len(in.ScriptSig) > 0 || len(in.Witness) > 0 {
+				return fmt.Errorf("PSBT unsigned transaction input %d is "+
+					"signed", i)
+			}
+		}
+		inputs, outputs = len(tx.Inputs), len(tx.Outputs)
+	case 2:
+		if _, ok := global.get(psbtGlobalUnsignedTx); ok {
+			return errors.New("PSBT version 2 has an unsigned transaction")
+		}
+		if v, ok := global.get(psbtGlobalTxVersion); !ok || len(v) != 4 {
+			return errors.New("PSBT version 2 has no transaction version")
+		}
+		counts := []int{}
+		for _, t := range []byte{psbtGlobalInputCount, psbtGlobalOutputCount} {
+			v, ok := global.get(t)
+			cr := &wireReader{b: v}
+			n := cr.readVarInt()
+			if !ok || cr.err != nil || len(cr.b) != 0 || n > uint64(len(r.b)) {
+				return errors.New("PSBT version 2 has an invalid input or " +
+					"output count")
+			}
+			counts = append(counts, int(n))
+		}
+		inputs, outputs = counts[0], counts[1]
+	default:
+		return fmt.Errorf("unsupported PSBT version %d", version)
+	}
+
+	for i := 0; i < inputs; i++ {
+		m, err := readPSBTMap(r)
+		if err != nil {
+			return err
+		}
+		decoded.inputs = append(decoded.inputs, m)
+	}
+	for i := 0; i < outputs; i++ {
+		m, err := readPSBTMap(r)
+		if err != nil {
+			return err
+		}
+		decoded.outputs = append(decoded.outputs, m)
+	}
+	if len(r.b) != 0 {
+		return errors.New("trailing data after PSBT")
+	}
+	if err := decoded.checkVersion2Fields(); err != nil {
+		return err
+	}
+	*p = *decoded
+	return nil
+}
+
+// checkVersion2Fields checks the per input and output fields that are
+// required in version 2 and forbidden in version 0.
+func (p *PSBT) checkVersion2Fields() error {
+	v2 := p.Version() == 2
+	if v, ok := p.global.get(psbtGlobalFallbackLockTime); ok && len(v) != 4 {
+		return errors.New("PSBT has an invalid fallback lock time")
+	}
+	for i, in := range p.inputs {
+		txid, hasTxID := in.get(psbtInPreviousTxID)
+		index, hasIndex := in.get(psbtInOutputIndex)
+		if !v2 && (hasTxID || hasIndex) {
+			return fmt.Errorf("PSBT version 0 input %d has version 2 fields", i)
+		}
+		if v2 && (len(txid) != hashSize || len(index) != 4) {
+			return fmt.Errorf("PSBT input %d has no previous output", i)
+		}
+		for _, t := range []byte{psbtInSequence, psbtInRequiredTimeLockTime,
+			psbtInRequiredHeightLockTime} {
+			if v, ok := in.get(t); ok && (!v2 || len(v) != 4) {
+				return fmt.Errorf("PSBT input %d has an invalid field %#x", i, t)
+			}
+		}
+	}
+	for i, out := range p.outputs {
+		amount, hasAmount := out.get(psbtOutAmount)
+		_, hasScript := out.get(psbtOutScript)
+		if !v2 && (hasAmount || hasScript) {
+			return fmt.Errorf("PSBT version 0 output %d has version 2 fields",
+				i)
+		}
+		if v2 && (len(amount) != 8 || !hasScript) {
+			return fmt.Errorf("PSBT output %d has no amount or script", i)
+		}
+	}
+	return nil
+}
+
+// ParsePSBT decodes a base64 encoded PSBT.
+func ParsePSBT(s string) (*PSBT, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &PSBT{}
+	if err := p.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Base64 encodes p in base64, the usual text form of a PSBT.
+func (p *PSBT) Base64() (string, error) {
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func (p *PSBT) input(i int) (psbtMap, error) {
+	if i < 0 || i >= len(p.inputs) {
+		return nil, fmt.Errorf("PSBT has no input %d", i)
+	}
+	return p.inputs[i], nil
+}
+
+// SetWitnessUTXO records the output spent by input i. It is all that
+// signers need for segwit inputs.
+func (p *PSBT) SetWitnessUTXO(i int, out RawOutput) error {
+	in, err := p.input(i)
+	if err != nil {
+		return err
+	}
+	v := binary.LittleEndian.AppendUint64(nil, uint64(out.Value))
+	v = writeVarInt(v, uint64(len(out.Script)))
+	in.set(psbtInWitnessUTXO, nil, append(v, out.Script...))
+	return nil
+}
+
+// SetNonWitnessUTXO records the full transaction spent by input i, which
+// signers of legacy inputs need to check the value spent.
+func (p *PSBT) SetNonWitnessUTXO(i int, prev *RawTransaction) error {
+	in, err := p.input(i)
+	if err != nil {
+		return err
+	}
+	tx, err := p.UnsignedTransaction()
+	if err != nil {
+		return err
+	}
+	hash, err := prev.Hash()
+	if err != nil {
+		return err
+	}
+	if hash != tx.Inputs[i].PreviousHash {
+		return fmt.Errorf("transaction %s is not spent by input %d", hash, i)
+	}
+	b, err := prev.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	in.set(psbtInNonWitnessUTXO, nil, b)
+	return nil
+}
+
+// SetRedeemScript records the P2SH redeem script of input i.
+func (p *PSBT) SetRedeemScript(i int, script []byte) error {
+	in, err := p.input(i)
+	if err != nil {
+		return err
+	}
+	in.set(psbtInRedeemScript, nil, script)
+	return nil
+}
+
+// SetWitnessScript records the P2WSH witness script of input i.
+func (p *PSBT) SetWitnessScript(i int, script []byte) error {
+	in, err := p.input(i)
+	if err != nil {
+		return err
+	}
+	in.set(psbtInWitnessScript, nil, script)
+	return nil
+}
+
+// AddPartialSignature records the signature of input i by publicKey. The
+// signature includes its sighash type byte.
+func (p *PSBT) AddPartialSignature(i int, publicKey, signature []byte) error {
+	in, err := p.input(i)
+	if err != nil {
+		return err
+	}
+	if !isPubKey(publicKey) {
+		return fmt.Errorf("invalid public key %x", publicKey)
+	}
+	in.set(psbtInPartialSig, publicKey, signature)
+	return nil
+}
+
+// SetTaprootKeySignature records the BIP 341 key path signature of input i.
+func (p *PSBT) SetTaprootKeySignature(i int, signature []byte) error {
+	in, err := p.input(i)
+	if err != nil {
+		return err
+	}
+	if len(signature) != 64 && len(signature) != 65 {
+		return fmt.Errorf("invalid taproot signature length %d",
+			len(signature))
+	}
+	in.set(psbtInTapKeySig, nil, signature)
+	return nil
+}
+
+// UTXO returns the output spent by input i, from its witness UTXO or
+// non-witness UTXO.
+func (p *PSBT) UTXO(i int) (RawOutput, error) {
+	in, err := p.input(i)
+	if err != nil {
+		return RawOutput{}, err
+	}
+	if v, ok := in.get(psbtInWitnessUTXO); ok {
+		r := &wireReader{b: v}
+		out := RawOutput{Value: Amount(r.readUint64()), Script: r.readVarBytes()}
+		if r.err != nil || len(r.b) != 0 {
+			return RawOutput{}, fmt.Errorf("input %d has an invalid witness "+
+				"UTXO", i)
+		}
+		return out, nil
+	}
+	if v, ok := in.get(psbtInNonWitnessUTXO); ok {
+		prev := &RawTransaction{}
+		if err := prev.UnmarshalBinary(v); err != nil {
+			return RawOutput{}, fmt.Errorf("input %d has an invalid "+
+				"non-witness UTXO: %v", i, err)
+		}
+		tx, err := p.UnsignedTransaction()
+		if err != nil {
+			return RawOutput{}, err
+		}
+		hash, _ := prev.Hash()
+		index := tx.Inputs[i].PreviousIndex
+		if hash != tx.Inputs[i].PreviousHash ||
+			int(index) >= len(prev.Outputs) {
+			return RawOutput{}, fmt.Errorf("input %d non-witness UTXO does "+
+				"not match its previous output", i)
+		}
+		return prev.Outputs[index], nil
+	}
+	return RawOutput{}, fmt.Errorf("input %d has no UTXO", i)
+}
+
+// CreatePSBT creates a PSBT of the given version, 0 or 2, for an unsigned
+// transaction in hex format. The outputs it spends are fetched with
+// GetTransactionMulti and recorded as witness UTXOs, so every input must
+// spend a P2WPKH, P2WSH or P2TR output. The Chain.com API does not return raw
+// transactions, so PSBTs spending legacy or P2SH outputs must be made with
+// NewPSBT and their previous transactions added with SetNonWitnessUTXO.
+func (c *Chain) CreatePSBT(hex string, version uint32) (*PSBT, error) {
+	tx, err := DecodeRawTransaction(hex)
+	if err != nil {
+		return nil, err
+	}
+	p, err := NewPSBT(tx, version)
+	if err != nil {
+		return nil, err
+	}
+	prevouts, err := c.prevouts(tx)
+	if err != nil {
+		return nil, err
+	}
+	for i, out := range prevouts {
+		if out == nil {
+			return nil, fmt.Errorf("input %d spends unknown output %s:%d", i,
+				tx.Inputs[i].PreviousHash, tx.Inputs[i].PreviousIndex)
+		}
+		script, err := outputScript(*out)
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %v", i, err)
+		}
+		// The redeem script of a P2SH output, and so whether it is
+		// segwit, is not known until it is spent.
+		switch t := ScriptType(script); t {
+		case WitnessV0KeyHashScript, WitnessV0ScriptHashScript,
+			WitnessV1TaprootScript:
+		default:
+			return nil, fmt.Errorf("input %d spends a %s output, which needs "+
+				"its previous transaction set with SetNonWitnessUTXO", i, t)
+		}
+		p.SetWitnessUTXO(i, RawOutput{out.Value, script})
+	}
+	return p, nil
+}
+
+// prevouts returns the outputs spent by the inputs of tx, nil for those
+// that are not found.
+func (c *Chain) prevouts(tx *RawTransaction) ([]*Output, error) {
+	hashes, index := []string{}, map[string]int{}
+	for _, in := range tx.Inputs {
+		if _, ok := index[in.PreviousHash]; !ok {
+			index[in.PreviousHash] = len(hashes)
+			hashes = append(hashes, in.PreviousHash)
+		}
+	}
+	txns, err := c.GetTransactionMulti(hashes)
+	errs, _ := err.(MultiError)
+	if err != nil && errs == nil {
+		return nil, err
+	}
+
+	prevouts := make([]*Output, len(tx.Inputs))
+	for i, in := range tx.Inputs {
+		j := index[in.PreviousHash]
+		if errs != nil && errs[j] != nil {
+			if !IsNotFound(errs[j]) {
+				return nil, errs[j]
+			}
+			continue
+		}
+		if int(in.PreviousIndex) < len(txns[j].Outputs) {
+			prevouts[i] = &txns[j].Outputs[in.PreviousIndex]
+		}
+	}
+	return prevouts, nil
+}
+
+// outputScript returns the script of out from ScriptHex or, failing that,
+// its Script assembly.
+func outputScript(out Output) ([]byte, error) {
+	if out.ScriptHex != "" {
+		return hex.DecodeString(out.ScriptHex)
+	}
+	if out.Script != "" {
+		return parseASM(out.Script)
+	}
+	return nil, errors.New("output has no script")
+}
+
+// ValidatePSBT checks the UTXO of every input of p against the output it
+// spends fetched with GetTransactionMulti: the output must exist, be unspent
+// and have the same value and script. If any input fails a MultiError is
+// returned with an entry for each input.
+func (c *Chain) ValidatePSBT(p *PSBT) error {
+	tx, err := p.UnsignedTransaction()
+	if err != nil {
+		return err
+	}
+	prevouts, err := c.prevouts(tx)
+	if err != nil {
+		return err
+	}
+
+	errs, failed := make(MultiError, len(tx.Inputs)), false
+	for i, prev := range prevouts {
+		in := tx.Inputs[i]
+		utxo, err := p.UTXO(i)
+		switch {
+		case prev == nil:
+			err = fmt.Errorf("input %d spends unknown output %s:%d", i,
+				in.PreviousHash, in.PreviousIndex)
+		case err != nil:
+		case prev.Spent:
+			err = fmt.Errorf("input %d spends output %s:%d which is already "+
+				"spent", i, in.PreviousHash, in.PreviousIndex)
+		case utxo.Value != prev.Value:
+			err = fmt.Errorf("input %d UTXO value %s does not match %s", i,
+				utxo.Value, prev.Value)
+		default:
+			script, scriptErr := outputScript(*prev)
+			if scriptErr == nil && !bytes.Equal(script, utxo.Script) {
+				err = fmt.Errorf("input %d UTXO script does not match", i)
+			}
+		}
+		if err != nil {
+			errs[i], failed = err, true
+		}
+	}
+	if failed {
+		return errs
+	}
+	return nil
+}
+
+// CombinePSBT merges the signatures and other data of PSBTs for the same
+// transaction, for example copies signed by different signers. Where PSBTs
+// have different values for the same key the first is kept.
+func CombinePSBT(psbts ...*PSBT) (*PSBT, error) {
+	if len(psbts) == 0 {
+		return nil, errors.New("no PSBTs to combine")
+	}
+	first := psbts[0]
+	tx, err := first.UnsignedTransaction()
+	if err != nil {
+		return nil, err
+	}
+	hash, _ := tx.Hash()
+
+	combined := &PSBT{
+		global:  first.global.copy(),
+		inputs:  make([]psbtMap, len(first.inputs)),
+		outputs: make([]psbtMap, len(first.outputs)),
+	}
+	for i, m := range first.inputs {
+		combined.inputs[i] = m.copy()
+	}
+	for i, m := range first.outputs {
+		combined.outputs[i] = m.copy()
+	}
+
+	for _, p := range psbts[1:] {
+		other, err := p.UnsignedTransaction()
+		if err != nil {
+			return nil, err
+		}
+		if h, _ := other.Hash(); h != hash || p.Version() != first.Version() {
+			return nil, errors.New("PSBTs are for different transactions")
+		}
+		merge := func(dst, src psbtMap) {
+			for k, v := range src {
+				if _, ok := dst[k]; !ok {
+					dst[k] = v
+				}
+			}
+		}
+		merge(combined.global, p.global)
+		for i := range p.inputs {
+			merge(combined.inputs[i], p.inputs[i])
+		}
+		for i := range p.outputs {
+			merge(combined.outputs[i], p.outputs[i])
+		}
+	}
+	return combined, nil
+}
+
+// partialSignature returns the signature by a public key whose HASH160 is
+// keyHash.
+func partialSignature(in psbtMap, keyHash []byte) ([]byte, []byte, bool) {
+	for k, sig := range in {
+		if k[0] == psbtInPartialSig &&
+			bytes.Equal(hash160([]byte(k[1:])), keyHash) {
+			return []byte(k[1:]), sig, true
+		}
+	}
+	return nil, nil, false
+}
+
+// multisigSignatures returns the signatures for a multisig script in key
+// order.
+func multisigSignatures(in psbtMap, script []byte) ([][]byte, error) {
+	ms, err := parseMultisigScript(script)
+	if err != nil {
+		return nil, err
+	}
+	sigs := [][]byte{}
+	for _, k := range ms.PublicKeys {
+		key, _ := hex.DecodeString(k)
+		if sig, ok := in[string(append([]byte{psbtInPartialSig}, key...))]; ok {
+			sigs = append(sigs, sig)
+			if len(sigs) == ms.RequiredSignatures {
+				return sigs, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("has %d of %d signatures", len(sigs),
+		ms.RequiredSignatures)
+}
+
+// Finalize builds the final input scripts and witnesses of every input that
+// has enough signatures, removing the data only signers need. Inputs may
+// spend P2PK, P2PKH, P2WPKH, bare multisig, P2SH multisig, P2WSH multisig,
+// P2SH-P2WPKH, P2SH-P2WSH multisig and P2TR key path outputs. If any input
+// cannot be finalized a MultiError is returned with an entry for each input;
+// the other inputs are still finalized.
+func (p *PSBT) Finalize() error {
+	errs, failed := make(MultiError, len(p.inputs)), false
+	for i := range p.inputs {
+		if err := p.finalizeInput(i); err != nil {
+			errs[i], failed = fmt.Errorf("input %d: %v", i, err), true
+		}
+	}
+	if failed {
+		return errs
+	}
+	return nil
+}
+
+func (p *PSBT) finalizeInput(i int) error {
+	in := p.inputs[i]
+	_, hasScriptSig := in.get(psbtInFinalScriptSig)
+	_, hasWitness := in.get(psbtInFinalScriptWitness)
+	if hasScriptSig || hasWitness {
+		return nil
+	}
+	utxo, err := p.UTXO(i)
+	if err != nil {
+		return err
+	}
+
+	script, scriptSig := utxo.Script, []byte(nil)
+	var witness [][]byte
+	nested := ScriptType(script) == ScriptHashScript
+	if nested {
+		redeem, ok := in.get(psbtInRedeemScript)
+		if !ok || !bytes.Equal(hash160(redeem), script[2:22]) {
+			return errors.New("missing or mismatched redeem script")
+		}
+		script = redeem
+	}
+
+	switch ScriptType(script) {
+	case PubKeyScript:
+		sig, ok := in[string(append([]byte{psbtInPartialSig},
+			script[1:len(script)-1]...))]
+		if !ok {
+			return errors.New("missing signature")
+		}
+		scriptSig = pushData(nil, sig)
+	case PubKeyHashScript:
+		key, sig, ok := partialSignature(in, script[3:23])
+		if !ok {
+			return errors.New("missing signature")
+		}
+		scriptSig = pushData(pushData(nil, sig), key)
+	case WitnessV0KeyHashScript:
+		key, sig, ok := partialSignature(in, script[2:22])
+		if !ok {
+			return errors.New("missing signature")
+		}
+		if len(key) != 33 {
+			return errors.New("segwit public key is not compressed")
+		}
+		witness = [][]byte{sig, key}
+	case MultisigScript:
+		sigs, err := multisigSignatures(in, script)
+		if err != nil {
+			return err
+		}
+		// OP_CHECKMULTISIG pops one extra item.
+		scriptSig = []byte{op0}
+		for _, sig := range sigs {
+			scriptSig = pushData(scriptSig, sig)
+		}
+	case WitnessV0ScriptHashScript:
+		ws, ok := in.get(psbtInWitnessScript)
+		if h := sha256.Sum256(ws); !ok || !bytes.Equal(h[:], script[2:]) {
+			return errors.New("missing or mismatched witness script")
+		}
+		// BIP 143 makes witness scripts with uncompressed keys
+		// non-standard.
+		if ms, err := parseMultisigScript(ws); err == nil && !ms.compressed() {
+			return errors.New("witness script has uncompressed public keys")
+		}
+		sigs, err := multisigSignatures(in, ws)
+		if err != nil {
+			return err
+		}
+		witness = append(append([][]byte{{}}, sigs...), ws)
+	case WitnessV1TaprootScript:
+		sig, ok := in.get(psbtInTapKeySig)
+		if !ok || nested {
+			return errors.New("missing taproot key path signature")
+		}
+		witness = [][]byte{sig}
+	default:
+		return errors.New("unsupported output script")
+	}
+	if nested {
+		scriptSig = pushData(scriptSig, script)
+	}
+
+	// The finalizer removes everything but the UTXOs, the final scripts,
+	// the version 2 outpoint fields and unknown fields.
+	in.deleteTypes(psbtInPartialSig, psbtInSighashType, psbtInRedeemScript,
+		psbtInWitnessScript, psbtInBIP32Derivation)
+	for t := byte(psbtInTapKeySig); t <= psbtInTapMerkleRoot; t++ {
+		in.deleteTypes(t)
+	}
+	if len(scriptSig) > 0 {
+		in.set(psbtInFinalScriptSig, nil, scriptSig)
+	}
+	if witness != nil {
+		w := writeVarInt(nil, uint64(len(witness)))
+		for _, item := range witness {
+			w = writeVarInt(w, uint64(len(item)))
+			w = append(w, item...)
+		}
+		in.set(psbtInFinalScriptWitness, nil, w)
+	}
+	return nil
+}
+
+// Extract returns the signed transaction in hex format, ready for
+// SendTransaction. Every input must be finalized.
+func (p *PSBT) Extract() (string, error) {
+	tx, err := p.UnsignedTransaction()
+	if err != nil {
+		return "", err
+	}
+	for i, in := range p.inputs {
+		scriptSig, hasScriptSig := in.get(psbtInFinalScriptSig)
+		w, hasWitness := in.get(psbtInFinalScriptWitness)
+		if !hasScriptSig && !hasWitness {
+			return "", fmt.Errorf("input %d is not finalized", i)
+		}
+		tx.Inputs[i].ScriptSig = scriptSig
+		if hasWitness {
+			r := &wireReader{b: w}
+			items := make([][]byte, r.readCount(1))
+			for j := range items {
+				items[j] = r.readVarBytes()
+			}
+			if r.err != nil || len(r.b) != 0 {
+				return "", fmt.Errorf("input %d has an invalid final witness",
+					i)
+			}
+			tx.Inputs[i].Witness = items
+		}
+	}
+	b, err := tx.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
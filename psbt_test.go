@@ -0,0 +1,346 @@
+package chain_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/qedus/chain"
+)
+
+// psbtSetup funds a P2WSH multisig, a P2SH multisig and a P2TR output and
+// returns the funding transaction and an unsigned transaction spending them.
+func psbtSetup(t *testing.T) (*fakeAPI, *chain.Multisig, *chain.RawTransaction,
+	*chain.RawTransaction) {
+	ms, err := chain.NewSortedMultisig(2, bip67Keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addresses, _ := ms.Addresses(chain.MainNet)
+	p2sh, err := chain.DecodeAddress(addresses.P2SH, chain.MainNet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	witnessHash := sha256.Sum256(ms.Script())
+	p2wsh := append([]byte{0, 32}, witnessHash[:]...)
+	p2tr := append([]byte{0x51, 32}, bytes.Repeat([]byte{7}, 32)...)
+
+	funding := &chain.RawTransaction{
+		Version: 2,
+		Inputs: []chain.RawInput{{
+			PreviousHash: fakeHash("tx", "psbt"),
+			ScriptSig:    []byte{},
+			Sequence:     0xffffffff,
+		}},
+		Outputs: []chain.RawOutput{
+			{Value: 50000, Script: p2wsh},
+			{Value: 30000, Script: p2sh.Script()},
+			{Value: 20000, Script: p2tr},
+		},
+	}
+	fundingHash, err := funding.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := newFakeAPI(t, chain.MainNet, 1)
+	fundingTx := chain.Transaction{Hash: fundingHash}
+	for _, out := range funding.Outputs {
+		fundingTx.Outputs = append(fundingTx.Outputs, chain.Output{
+			Value: out.Value, ScriptHex: hex.EncodeToString(out.Script)})
+	}
+	api.mine(fundingTx)
+
+	to, _ := chain.DecodeAddress(testAddress, chain.MainNet)
+	tx := &chain.RawTransaction{Version: 2}
+	for i := range funding.Outputs {
+		tx.Inputs = append(tx.Inputs, chain.RawInput{
+			PreviousHash:  fundingHash,
+			PreviousIndex: uint32(i),
+			Sequence:      0xfffffffd,
+		})
+	}
+	tx.Outputs = []chain.RawOutput{{Value: 95000, Script: to.Script()}}
+	return api, ms, funding, tx
+}
+
+// setupPSBT creates a PSBT for the transaction of psbtSetup. The P2SH input
+// is not segwit so needs its previous transaction.
+func setupPSBT(t *testing.T, funding, tx *chain.RawTransaction,
+	version uint32) *chain.PSBT {
+	p, err := chain.NewPSBT(tx, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		p.SetWitnessUTXO(0, funding.Outputs[0]),
+		p.SetNonWitnessUTXO(1, funding),
+		p.SetWitnessUTXO(2, funding.Outputs[2]),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+func fakeSignature(b byte) []byte {
+	return append(bytes.Repeat([]byte{b}, 71), 0x01)
+}
+
+func TestPSBTSignAndFinalize(t *testing.T) {
+	api, ms, funding, tx := psbtSetup(t)
+	c := newTestChain(t, chain.MainNet, api)
+
+	p := setupPSBT(t, funding, tx, 0)
+	if err := c.ValidatePSBT(p); err != nil {
+		t.Fatal(err)
+	}
+	p.SetWitnessScript(0, ms.Script())
+	p.SetRedeemScript(1, ms.Script())
+	encoded, err := p.Base64()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each signer works on its own copy.
+	keys := [][]byte{}
+	for _, k := range ms.PublicKeys {
+		b, _ := hex.DecodeString(k)
+		keys = append(keys, b)
+	}
+	signed := []*chain.PSBT{}
+	for i, key := range keys {
+		s, err := chain.ParsePSBT(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.AddPartialSignature(0, key, fakeSignature(byte(i+1)))
+		s.AddPartialSignature(1, key, fakeSignature(byte(i+3)))
+		signed = append(signed, s)
+	}
+	if err := signed[0].SetTaprootKeySignature(2,
+		bytes.Repeat([]byte{9}, 64)); err != nil {
+		t.Fatal(err)
+	}
+
+	partial, _ := chain.CombinePSBT(signed[0])
+	err = partial.Finalize()
+	if errs, ok := err.(chain.MultiError); !ok || errs[0] == nil ||
+		errs[1] == nil || errs[2] != nil {
+		t.Fatal("expected missing signature errors", err)
+	}
+
+	combined, err := chain.CombinePSBT(signed[1], signed[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := combined.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	rawTx, err := combined.Extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	final, err := chain.DecodeRawTransaction(rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(final.Inputs[0].Witness, [][]byte{{},
+		fakeSignature(1), fakeSignature(2), ms.Script()}) {
+		t.Fatal("unexpected P2WSH witness", final.Inputs[0].Witness)
+	}
+	scriptSig := []byte{0, 72}
+	scriptSig = append(append(scriptSig, fakeSignature(3)...), 72)
+	scriptSig = append(append(scriptSig, fakeSignature(4)...), 71)
+	scriptSig = append(scriptSig, ms.Script()...)
+	if !bytes.Equal(final.Inputs[1].ScriptSig, scriptSig) ||
+		len(final.Inputs[1].Witness) != 0 {
+		t.Fatal("unexpected P2SH script", final.Inputs[1].ScriptSig)
+	}
+	if len(final.Inputs[2].Witness) != 1 ||
+		len(final.Inputs[2].Witness[0]) != 64 {
+		t.Fatal("unexpected P2TR witness", final.Inputs[2].Witness)
+	}
+
+	hash, err := c.SendTransaction(rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := final.Hash(); hash != want || len(api.sent) != 1 {
+		t.Fatal("unexpected send", hash, api.sent)
+	}
+
+	if _, err := p.Extract(); err == nil {
+		t.Fatal("expected unfinalized error")
+	}
+}
+
+func TestPSBTVersion2(t *testing.T) {
+	_, _, funding, tx := psbtSetup(t)
+	tx.LockTime = 100
+
+	p := setupPSBT(t, funding, tx, 2)
+	if p.Version() != 2 {
+		t.Fatal("unexpected version", p.Version())
+	}
+	encoded, _ := p.Base64()
+	parsed, err := chain.ParsePSBT(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := parsed.UnsignedTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(unsigned, tx) {
+		t.Fatal("unexpected unsigned transaction", unsigned)
+	}
+
+	v0, _ := chain.NewPSBT(tx, 0)
+	if _, err := chain.CombinePSBT(parsed, v0); err == nil {
+		t.Fatal("expected version mismatch error")
+	}
+}
+
+func TestCreatePSBT(t *testing.T) {
+	api, _, funding, tx := psbtSetup(t)
+	c := newTestChain(t, chain.MainNet, api)
+
+	if _, err := c.CreatePSBT(rawHex(t, tx), 0); err == nil ||
+		!strings.Contains(err.Error(), "SetNonWitnessUTXO") {
+		t.Fatal("expected P2SH input error", err)
+	}
+
+	// Without the P2SH input every input is segwit.
+	tx.Inputs = append(tx.Inputs[:1], tx.Inputs[2])
+	p, err := c.CreatePSBT(rawHex(t, tx), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []chain.RawOutput{funding.Outputs[0],
+		funding.Outputs[2]} {
+		if utxo, err := p.UTXO(i); err != nil ||
+			!reflect.DeepEqual(utxo, want) {
+			t.Fatal("unexpected UTXO", i, utxo, err)
+		}
+	}
+	if err := c.ValidatePSBT(p); err != nil {
+		t.Fatal(err)
+	}
+
+	tx.Inputs[0].PreviousHash = fakeHash("unknown")
+	if _, err := c.CreatePSBT(rawHex(t, tx), 0); err == nil {
+		t.Fatal("expected unknown input error")
+	}
+	if _, err := c.CreatePSBT(rawHex(t, policyTransaction(t, 1)), 0); err == nil {
+		t.Fatal("expected signed input error")
+	}
+}
+
+func TestValidatePSBT(t *testing.T) {
+	api, _, funding, tx := psbtSetup(t)
+	c := newTestChain(t, chain.MainNet, api)
+
+	p := setupPSBT(t, funding, tx, 0)
+	utxo, err := p.UTXO(2)
+	if err != nil || utxo.Value != 20000 {
+		t.Fatal("unexpected UTXO", utxo, err)
+	}
+	utxo.Value = 2000000
+	p.SetWitnessUTXO(2, utxo)
+	err = c.ValidatePSBT(p)
+	if errs, ok := err.(chain.MultiError); !ok || errs[0] != nil ||
+		errs[2] == nil {
+		t.Fatal("expected value mismatch", err)
+	}
+
+	if err := p.SetNonWitnessUTXO(0, policyTransaction(t, 1)); err == nil {
+		t.Fatal("expected previous transaction mismatch")
+	}
+}
+
+func TestPSBTFinalizeUncompressed(t *testing.T) {
+	uncompressed := "04" + bip67Keys[0][2:] + bip67Keys[1][2:]
+	ms, err := chain.NewMultisig(1, []string{uncompressed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	witnessHash := sha256.Sum256(ms.Script())
+	tx := &chain.RawTransaction{
+		Version: 2,
+		Inputs:  []chain.RawInput{{PreviousHash: fakeHash("tx", "psbt")}},
+		Outputs: []chain.RawOutput{{Value: 1000, Script: []byte{0x6a}}},
+	}
+	p, err := chain.NewPSBT(tx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := hex.DecodeString(uncompressed)
+	p.SetWitnessUTXO(0, chain.RawOutput{Value: 2000,
+		Script: append([]byte{0, 32}, witnessHash[:]...)})
+	p.SetWitnessScript(0, ms.Script())
+	p.AddPartialSignature(0, key, fakeSignature(1))
+	if err := p.Finalize(); err == nil ||
+		!strings.Contains(err.Error(), "uncompressed") {
+		t.Fatal("expected uncompressed key error", err)
+	}
+}
+
+// bip174Vector is a PSBT with a non-witness UTXO from the BIP 174 test
+// vectors.
+const bip174Vector = "cHNidP8BAHUCAAAAASaBcTce3/KF6Tet7qSze3gADAVmy7OtZGQXE8pCFxv2AAAAAAD+" +
+	"////AtPf9QUAAAAAGXapFNDFmQPFusKGh2DpD9UhpGZap2UgiKwA4fUFAAAAABepFDVF" +
+	"5uM7gyxHBQ8k0+65PJwDlIvHh7MuEwAAAQD9pQEBAAAAAAECiaPHHqtNIOA3G7ukzGmP" +
+	"opXJRjr6Ljl/hTPMti+VZ+UBAAAAFxYAFL4Y0VKpsBIDna89p95PUzSe7LmF/////4b4" +
+	"qkOnHf8USIk6UwpyN+9rRgi7st0tAXHmOuxqSJC0AQAAABcWABT+Pp7xp0XpdNkCxDVZ" +
+	"Q6vLNL1TU/////8CAMLrCwAAAAAZdqkUhc/xCX/Z4Ai7NK9wnGIZeziXikiIrHL++E4s" +
+	"AAAAF6kUM5cluiHv1irHU6m80GfWx6ajnQWHAkcwRAIgJxK+IuAnDzlPVoMR3Hyppolw" +
+	"uAJf3TskAinwf4pfOiQCIAGLONfc0xTnNMkna9b7QPZzMlvEuqFEyADS8vAtsnZcASED" +
+	"0uFWdJQbrUqZY3LLh+GFbTZSYG2YVi/jnF6efkE/IQUCSDBFAiEA0SuFLYXc2WHS9fSr" +
+	"ZgZU327tzHlMDDPOXMMJ/7X85Y0CIGczio4OFyXBl/saiK9Z9R5E5CVbIBZ8hoQDHAXR" +
+	"8lkqASECI7cr7vCWXRC+B3jv7NYfysb3mk6haTkzgHNEZPhPKrMAAAAAAAAA"
+
+func TestParsePSBT(t *testing.T) {
+	p, err := chain.ParsePSBT(bip174Vector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded, _ := p.Base64(); encoded != bip174Vector {
+		t.Fatal("round trip failed", encoded)
+	}
+	utxo, err := p.UTXO(0)
+	if err != nil || utxo.Value != 2*chain.BTC {
+		t.Fatal("unexpected UTXO", utxo, err)
+	}
+}
+
+func TestParsePSBTErrors(t *testing.T) {
+	_, _, _, tx := psbtSetup(t)
+	p, err := chain.NewPSBT(tx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := p.MarshalBinary()
+
+	for _, data := range [][]byte{
+		nil,
+		[]byte("psbt"),
+		b[:len(b)-1],
+		append(append([]byte{}, b...), 0),
+		append([]byte("psbu\xff"), b[5:]...),
+	} {
+		if err := (&chain.PSBT{}).UnmarshalBinary(data); err == nil {
+			t.Fatalf("expected error %x", data)
+		}
+	}
+	if _, err := chain.ParsePSBT("not base64!"); err == nil {
+		t.Fatal("expected base64 error")
+	}
+	if _, err := chain.NewPSBT(tx, 1); err == nil {
+		t.Fatal("expected version error")
+	}
+}
@@ -0,0 +1,195 @@
+package chain
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+)
+
+// RawInput is an input of a RawTransaction.
+type RawInput struct {
+	// PreviousHash and PreviousIndex identify the output spent, with the
+	// hash displayed as by the Chain.com API.
+	PreviousHash  string
+	PreviousIndex uint32
+
+	ScriptSig []byte
+	Sequence  uint32
+
+	// Witness is the segregated witness stack, nil if there is none.
+	Witness [][]byte
+}
+
+// RawOutput is an output of a RawTransaction.
+type RawOutput struct {
+	Value  Amount
+	Script []byte
+}
+
+// RawTransaction is a transaction decoded from the Bitcoin wire format, as
+// accepted by SendTransaction.
+type RawTransaction struct {
+	Version  int32
+	Inputs   []RawInput
+	Outputs  []RawOutput
+	LockTime uint32
+}
+
+// DecodeRawTransaction decodes a transaction in hex format.
+func DecodeRawTransaction(s string) (*RawTransaction, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	tx := &RawTransaction{}
+	if err := tx.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// HasWitness reports whether any input has a witness.
+func (tx *RawTransaction) HasWitness() bool {
+	for _, in := range tx.Inputs {
+		if len(in.Witness) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encode serializes tx, with the segregated witness if witness is set and
+// tx has one.
+func (tx *RawTransaction) encode(witness bool) ([]byte, error) {
+	witness = witness && tx.HasWitness()
+	b := binary.LittleEndian.AppendUint32(nil, uint32(tx.Version))
+	if witness {
+		b = append(b, 0x00, 0x01)
+	}
+	b = writeVarInt(b, uint64(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		h, err := hashFromHex(in.PreviousHash)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, h[:]...)
+		b = binary.LittleEndian.AppendUint32(b, in.PreviousIndex)
+		b = writeVarInt(b, uint64(len(in.ScriptSig)))
+		b = append(b, in.ScriptSig...)
+		b = binary.LittleEndian.AppendUint32(b, in.Sequence)
+	}
+	b = writeVarInt(b, uint64(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		b = binary.LittleEndian.AppendUint64(b, uint64(out.Value))
+		b = writeVarInt(b, uint64(len(out.Script)))
+		b = append(b, out.Script...)
+	}
+	if witness {
+		for _, in := range tx.Inputs {
+			b = writeVarInt(b, uint64(len(in.Witness)))
+			for _, item := range in.Witness {
+				b = writeVarInt(b, uint64(len(item)))
+				b = append(b, item...)
+			}
+		}
+	}
+	return binary.LittleEndian.AppendUint32(b, tx.LockTime), nil
+}
+
+// MarshalBinary encodes tx in the wire format, using the BIP 144 segregated
+// witness serialization if any input has a witness.
+func (tx *RawTransaction) MarshalBinary() ([]byte, error) {
+	return tx.encode(true)
+}
+
+// UnmarshalBinary decodes a transaction in the wire format, with or without
+// a segregated witness.
+func (tx *RawTransaction) UnmarshalBinary(data []byte) error {
+	r := &wireReader{b: data}
+	version := int32(r.readUint32())
+	witness := len(r.b) >= 2 && r.b[0] == 0x00 && r.b[1] == 0x01
+	if witness {
+		r.read(2)
+	}
+
+	// An input takes at least 41 bytes and an output 9.
+	inputs := make([]RawInput, r.readCount(41))
+	for i := range inputs {
+		in := &inputs[i]
+		in.PreviousHash = hashToHex(r.readHash())
+		in.PreviousIndex = r.readUint32()
+		in.ScriptSig = r.readVarBytes()
+		in.Sequence = r.readUint32()
+	}
+	outputs := make([]RawOutput, r.readCount(9))
+	for i := range outputs {
+		outputs[i].Value = Amount(r.readUint64())
+		outputs[i].Script = r.readVarBytes()
+	}
+	if witness {
+		for i := range inputs {
+			items := make([][]byte, r.readCount(1))
+			for j := range items {
+				items[j] = r.readVarBytes()
+			}
+			if len(items) > 0 {
+				inputs[i].Witness = items
+			}
+		}
+	}
+	lockTime := r.readUint32()
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.b) != 0 {
+		return errors.New("trailing data after transaction")
+	}
+	if witness && !(&RawTransaction{Inputs: inputs}).HasWitness() {
+		return errors.New("transaction has an empty witness")
+	}
+
+	tx.Version = version
+	tx.Inputs = inputs
+	tx.Outputs = outputs
+	tx.LockTime = lockTime
+	return nil
+}
+
+// Hash returns the transaction hash, which does not cover the witness.
+func (tx *RawTransaction) Hash() (string, error) {
+	b, err := tx.encode(false)
+	if err != nil {
+		return "", err
+	}
+	return hashToHex(doubleSHA256(b)), nil
+}
+
+// WitnessHash returns the BIP 141 witness transaction hash. It equals Hash
+// for transactions without a witness.
+func (tx *RawTransaction) WitnessHash() (string, error) {
+	b, err := tx.encode(true)
+	if err != nil {
+		return "", err
+	}
+	return hashToHex(doubleSHA256(b)), nil
+}
+
+// Weight returns the BIP 141 weight of tx: three times its size without the
+// witness plus its full size.
+func (tx *RawTransaction) Weight() (int, error) {
+	base, err := tx.encode(false)
+	if err != nil {
+		return 0, err
+	}
+	full, err := tx.encode(true)
+	if err != nil {
+		return 0, err
+	}
+	return 3*len(base) + len(full), nil
+}
+
+// VirtualSize returns the weight divided by four, rounded up.
+func (tx *RawTransaction) VirtualSize() (int, error) {
+	w, err := tx.Weight()
+	return (w + 3) / 4, err
+}
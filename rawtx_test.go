@@ -0,0 +1,89 @@
+package chain_test
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/qedus/chain"
+)
+
+// genesisCoinbase is the coinbase transaction of the MainNet genesis block.
+const genesisCoinbase = "01000000010000000000000000000000000000000000000000" +
+	"000000000000000000000000ffffffff4d04ffff001d0104455468652054696d657320" +
+	"30332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f6620" +
+	"7365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a0100" +
+	"0000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61" +
+	"deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac" +
+	"00000000"
+
+func TestDecodeRawTransaction(t *testing.T) {
+	tx, err := chain.DecodeRawTransaction(genesisCoinbase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := tx.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b" {
+		t.Fatal("unexpected hash", hash)
+	}
+	if len(tx.Outputs) != 1 || tx.Outputs[0].Value != 50*chain.BTC ||
+		chain.ScriptType(tx.Outputs[0].Script) != chain.PubKeyScript {
+		t.Fatal("unexpected outputs", tx.Outputs)
+	}
+	if w, _ := tx.Weight(); w != 4*len(genesisCoinbase)/2 {
+		t.Fatal("unexpected weight", w)
+	}
+
+	for _, s := range []string{"", "0100", genesisCoinbase + "00", "zz"} {
+		if _, err := chain.DecodeRawTransaction(s); err == nil {
+			t.Fatal("expected decode error", s)
+		}
+	}
+}
+
+func TestRawTransactionWitness(t *testing.T) {
+	tx := &chain.RawTransaction{
+		Version: 2,
+		Inputs: []chain.RawInput{{
+			PreviousHash:  fakeHash("prev"),
+			PreviousIndex: 1,
+			ScriptSig:     []byte{},
+			Sequence:      0xfffffffd,
+			Witness:       [][]byte{make([]byte, 71), make([]byte, 33)},
+		}},
+		Outputs: []chain.RawOutput{{Value: 1000, Script: []byte{0x6a}}},
+	}
+	b, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &chain.RawTransaction{}
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tx, decoded) {
+		t.Fatal("round trip failed", decoded)
+	}
+
+	hash, _ := tx.Hash()
+	witnessHash, _ := tx.WitnessHash()
+	if hash == witnessHash {
+		t.Fatal("witness hash should differ")
+	}
+	// The witness adds the marker, flag and 107 bytes of stack.
+	weight, _ := tx.Weight()
+	vsize, _ := tx.VirtualSize()
+	base := len(b) - 2 - 1 - 1 - 71 - 1 - 33
+	if weight != 3*base+len(b) || vsize != (weight+3)/4 {
+		t.Fatal("unexpected weight", weight, vsize, len(b))
+	}
+
+	tx.Inputs[0].Witness = nil
+	b, _ = tx.MarshalBinary()
+	if hex.EncodeToString(b[4:6]) == "0001" {
+		t.Fatal("unexpected witness marker")
+	}
+}
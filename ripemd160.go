@@ -0,0 +1,106 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+)
+
+// RIPEMD-160 is not in the standard library. It is only used for HASH160,
+// so this is a plain one shot implementation of
+// https://homes.esat.kuleuven.be/~bosselae/ripemd160.html.
+
+var (
+	ripemdR = [80]uint8{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		7, 4, 13, 1, 10, 6, 15, 3, 12, 0, 9, 5, 2, 14, 11, 8,
+		3, 10, 14, 4, 9, 15, 8, 1, 2, 7, 0, 6, 13, 11, 5, 12,
+		1, 9, 11, 10, 0, 8, 12, 4, 13, 3, 7, 15, 14, 5, 6, 2,
+		4, 0, 5, 9, 7, 12, 2, 10, 14, 1, 3, 8, 11, 6, 15, 13,
+	}
+	ripemdRPrime = [80]uint8{
+		5, 14, 7, 0, 9, 2, 11, 4, 13, 6, 15, 8, 1, 10, 3, 12,
+		6, 11, 3, 7, 0, 13, 5, 10, 14, 15, 8, 12, 4, 9, 1, 2,
+		15, 5, 1, 3, 7, 14, 6, 9, 11, 8, 12, 2, 10, 0, 4, 13,
+		8, 6, 4, 1, 3, 11, 15, 0, 5, 12, 2, 13, 9, 7, 10, 14,
+		12, 15, 10, 4, 1, 5, 8, 7, 6, 2, 13, 14, 0, 3, 9, 11,
+	}
+	ripemdS = [80]uint8{
+		11, 14, 15, 12, 5, 8, 7, 9, 11, 13, 14, 15, 6, 7, 9, 8,
+		7, 6, 8, 13, 11, 9, 7, 15, 7, 12, 15, 9, 11, 7, 13, 12,
+		11, 13, 6, 7, 14, 9, 13, 15, 14, 8, 13, 6, 5, 12, 7, 5,
+		11, 12, 14, 15, 14, 15, 9, 8, 9, 14, 5, 6, 8, 6, 5, 12,
+		9, 15, 5, 11, 6, 8, 13, 12, 5, 12, 13, 14, 11, 8, 5, 6,
+	}
+	ripemdSPrime = [80]uint8{
+		8, 9, 9, 11, 13, 15, 15, 5, 7, 7, 8, 11, 14, 14, 12, 6,
+		9, 13, 15, 7, 12, 8, 9, 11, 7, 7, 12, 7, 6, 15, 13, 11,
+		9, 7, 15, 11, 8, 6, 6, 14, 12, 13, 5, 14, 13, 13, 7, 5,
+		15, 5, 8, 11, 14, 14, 6, 14, 6, 9, 12, 9, 12, 5, 15, 8,
+		8, 5, 12, 9, 12, 5, 14, 6, 8, 13, 6, 5, 15, 13, 11, 11,
+	}
+	ripemdK      = [5]uint32{0x00000000, 0x5a827999, 0x6ed9eba1, 0x8f1bbcdc, 0xa953fd4e}
+	ripemdKPrime = [5]uint32{0x50a28be6, 0x5c4dd124, 0x6d703ef3, 0x7a6d76e9, 0x00000000}
+)
+
+func ripemdF(j int, x, y, z uint32) uint32 {
+	switch j / 16 {
+	case 0:
+		return x ^ y ^ z
+	case 1:
+		return (x & y) | (^x & z)
+	case 2:
+		return (x | ^y) ^ z
+	case 3:
+		return (x & z) | (y &^ z)
+	}
+	return x ^ (y | ^z)
+}
+
+// ripemd160Sum returns the RIPEMD-160 digest of b.
+func ripemd160Sum(b []byte) [20]byte {
+	msg := append([]byte(nil), b...)
+	msg = append(msg, 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(len(b))*8)
+
+	h := [5]uint32{0x67452301, 0xefcdab89, 0x98badcfe, 0x10325476, 0xc3d2e1f0}
+	var x [16]uint32
+	for ; len(msg) > 0; msg = msg[64:] {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(msg[i*4:])
+		}
+		a, b, c, d, e := h[0], h[1], h[2], h[3], h[4]
+		ap, bp, cp, dp, ep := a, b, c, d, e
+		for j := 0; j < 80; j++ {
+			t := bits.RotateLeft32(a+ripemdF(j, b, c, d)+x[ripemdR[j]]+
+				ripemdK[j/16], int(ripemdS[j])) + e
+			a, e, d, c, b = e, d, bits.RotateLeft32(c, 10), b, t
+
+			t = bits.RotateLeft32(ap+ripemdF(79-j, bp, cp, dp)+
+				x[ripemdRPrime[j]]+ripemdKPrime[j/16], int(ripemdSPrime[j])) + ep
+			ap, ep, dp, cp, bp = ep, dp, bits.RotateLeft32(cp, 10), bp, t
+		}
+		t := h[1] + c + dp
+		h[1] = h[2] + d + ep
+		h[2] = h[3] + e + ap
+		h[3] = h[4] + a + bp
+		h[4] = h[0] + b + cp
+		h[0] = t
+	}
+
+	var sum [20]byte
+	for i, v := range h {
+		binary.LittleEndian.PutUint32(sum[i*4:], v)
+	}
+	return sum
+}
+
+// hash160 returns RIPEMD-160(SHA-256(b)), the hash in legacy addresses.
+func hash160(b []byte) []byte {
+	sha := sha256.Sum256(b)
+	sum := ripemd160Sum(sha[:])
+	return sum[:]
+}
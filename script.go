@@ -0,0 +1,196 @@
+package chain
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Output script types, as found in Output.ScriptType. They are the names
+// used by Chain.com and Bitcoin Core.
+const (
+	PubKeyScript              = "pubkey"
+	PubKeyHashScript          = "pubkeyhash"
+	ScriptHashScript          = "scripthash"
+	MultisigScript            = "multisig"
+	NullDataScript            = "nulldata"
+	WitnessV0KeyHashScript    = "witness_v0_keyhash"
+	WitnessV0ScriptHashScript = "witness_v0_scripthash"
+	WitnessV1TaprootScript    = "witness_v1_taproot"
+	WitnessUnknownScript      = "witness_unknown"
+	NonStandardScript         = "nonstandard"
+)
+
+// Script opcodes used by standard scripts.
+const (
+	op0             = 0x00
+	opPushData1     = 0x4c
+	opPushData2     = 0x4d
+	opPushData4     = 0x4e
+	op1Negate       = 0x4f
+	op1             = 0x51
+	op16            = 0x60
+	opReturn        = 0x6a
+	opDup           = 0x76
+	opEqual         = 0x87
+	opEqualVerify   = 0x88
+	opHash160       = 0xa9
+	opCheckSig      = 0xac
+	opCheckMultisig = 0xae
+)
+
+var errScriptTruncated = errors.New("script truncated")
+
+// scriptOp is a parsed script operation. Data is set for pushes.
+type scriptOp struct {
+	code byte
+	data []byte
+}
+
+// parseScript splits script into operations.
+func parseScript(script []byte) ([]scriptOp, error) {
+	ops := []scriptOp{}
+	for len(script) > 0 {
+		op := scriptOp{code: script[0]}
+		script = script[1:]
+		n := -1
+		switch {
+		case op.code > op0 && op.code < opPushData1:
+			n = int(op.code)
+		case op.code == opPushData1 && len(script) >= 1:
+			n, script = int(script[0]), script[1:]
+		case op.code == opPushData2 && len(script) >= 2:
+			n = int(binary.LittleEndian.Uint16(script))
+			script = script[2:]
+		case op.code == opPushData4 && len(script) >= 4:
+			n = int(binary.LittleEndian.Uint32(script))
+			script = script[4:]
+		case op.code >= opPushData1 && op.code <= opPushData4:
+			return nil, errScriptTruncated
+		}
+		if n >= 0 {
+			if n > len(script) {
+				return nil, errScriptTruncated
+			}
+			op.data, script = script[:n], script[n:]
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// pushData appends the shortest push of data to script.
+func pushData(script, data []byte) []byte {
+	switch n := len(data); {
+	case n < opPushData1:
+		script = append(script, byte(n))
+	case n <= 0xff:
+		script = append(script, opPushData1, byte(n))
+	case n <= 0xffff:
+		script = append(script, opPushData2)
+		script = binary.LittleEndian.AppendUint16(script, uint16(n))
+	default:
+		script = append(script, opPushData4)
+		script = binary.LittleEndian.AppendUint32(script, uint32(n))
+	}
+	return append(script, data...)
+}
+
+// isPushOnly reports whether ops only push data.
+func isPushOnly(ops []scriptOp) bool {
+	for _, op := range ops {
+		if op.code > op16 {
+			return false
+		}
+	}
+	return true
+}
+
+// smallInt returns the value of OP_1 to OP_16 and whether code is one.
+func smallInt(code byte) (int, bool) {
+	if code < op1 || code > op16 {
+		return 0, false
+	}
+	return int(code-op1) + 1, true
+}
+
+func isPubKey(b []byte) bool {
+	return (len(b) == 33 && (b[0] == 0x02 || b[0] == 0x03)) ||
+		(len(b) == 65 && b[0] == 0x04)
+}
+
+// witnessProgram returns the version and program of a segwit output script.
+func witnessProgram(script []byte) (byte, []byte, bool) {
+	if len(script) < 4 || len(script) > 42 || int(script[1]) != len(script)-2 {
+		return 0, nil, false
+	}
+	switch {
+	case script[0] == op0:
+		return 0, script[2:], true
+	case script[0] >= op1 && script[0] <= op16:
+		return script[0] - op1 + 1, script[2:], true
+	}
+	return 0, nil, false
+}
+
+// ScriptType classifies an output script as one of the output script types,
+// NonStandardScript if it is none of them.
+func ScriptType(script []byte) string {
+	if version, program, ok := witnessProgram(script); ok {
+		switch {
+		case version == 0 && len(program) == 20:
+			return WitnessV0KeyHashScript
+		case version == 0 && len(program) == 32:
+			return WitnessV0ScriptHashScript
+		case version == 0:
+			return NonStandardScript
+		case version == 1 && len(program) == 32:
+			return WitnessV1TaprootScript
+		}
+		return WitnessUnknownScript
+	}
+
+	ops, err := parseScript(script)
+	if err != nil {
+		return NonStandardScript
+	}
+	switch {
+	case len(script) == 25 && script[0] == opDup && script[1] == opHash160 &&
+		script[2] == 20 && script[23] == opEqualVerify &&
+		script[24] == opCheckSig:
+		return PubKeyHashScript
+	case len(script) == 23 && script[0] == opHash160 && script[1] == 20 &&
+		script[22] == opEqual:
+		return ScriptHashScript
+	case len(ops) == 2 && isPubKey(ops[0].data) && ops[1].code == opCheckSig:
+		return PubKeyScript
+	case len(ops) > 0 && ops[0].code == opReturn && isPushOnly(ops[1:]):
+		return NullDataScript
+	}
+	if _, _, err := parseMultisig(ops); err == nil {
+		return MultisigScript
+	}
+	return NonStandardScript
+}
+
+// parseMultisig returns the required signatures and public keys of a bare
+// multisig script, OP_m <keys> OP_n OP_CHECKMULTISIG.
+func parseMultisig(ops []scriptOp) (int, [][]byte, error) {
+	errNotMultisig := errors.New("not a multisig script")
+	if len(ops) < 4 || ops[len(ops)-1].code != opCheckMultisig {
+		return 0, nil, errNotMultisig
+	}
+	m, ok := smallInt(ops[0].code)
+	n, okN := smallInt(ops[len(ops)-2].code)
+	keys := ops[1 : len(ops)-2]
+	if !ok || !okN || m > n || n != len(keys) {
+		return 0, nil, errNotMultisig
+	}
+	pubKeys := make([][]byte, n)
+	for i, op := range keys {
+		if !isPubKey(op.data) {
+			return 0, nil, errNotMultisig
+		}
+		pubKeys[i] = op.data
+	}
+	return m, pubKeys, nil
+}
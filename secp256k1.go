@@ -0,0 +1,359 @@
+package chain
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+// secp256k1 is not in the standard library. This is a plain big.Int
+// implementation of the curve operations needed to sign and verify messages.
+// It is neither fast nor constant time: big.Int arithmetic and the
+// double-and-add scalar multiplication take time that depends on the secret
+// key and nonce when signing. See message.go for what that means for callers.
+
+var (
+	curveP, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	curveN, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	curveGx, _ = new(big.Int).SetString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", 16)
+	curveGy, _ = new(big.Int).SetString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", 16)
+	curveG     = &curvePoint{curveGx, curveGy}
+	curveHalfN = new(big.Int).Rsh(curveN, 1)
+
+	errInvalidPoint = errors.New("invalid secp256k1 point")
+)
+
+// curvePoint is an affine point on secp256k1. The point at infinity is nil.
+type curvePoint struct {
+	x, y *big.Int
+}
+
+func modP(v *big.Int) *big.Int {
+	return v.Mod(v, curveP)
+}
+
+func pointAdd(a, b *curvePoint) *curvePoint {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	var slope *big.Int
+	if a.x.Cmp(b.x) == 0 {
+		if a.y.Cmp(b.y) != 0 || a.y.Sign() == 0 {
+			return nil
+		}
+		// Doubling: slope = 3x² / 2y.
+		num := new(big.Int).Mul(a.x, a.x)
+		num.Mul(num, big.NewInt(3))
+		den := new(big.Int).Lsh(a.y, 1)
+		slope = modP(num.Mul(num, den.ModInverse(den, curveP)))
+	} else {
+		num := new(big.Int).Sub(b.y, a.y)
+		den := modP(new(big.Int).Sub(b.x, a.x))
+		slope = modP(num.Mul(num, den.ModInverse(den, curveP)))
+	}
+	x := new(big.Int).Mul(slope, slope)
+	x = modP(x.Sub(x, a.x).Sub(x, b.x))
+	y := new(big.Int).Sub(a.x, x)
+	y = modP(y.Mul(y, slope).Sub(y, a.y))
+	return &curvePoint{x, y}
+}
+
+func pointMul(p *curvePoint, k *big.Int) *curvePoint {
+	var r *curvePoint
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		r = pointAdd(r, r)
+		if k.Bit(i) == 1 {
+			r = pointAdd(r, p)
+		}
+	}
+	return r
+}
+
+func pointNeg(p *curvePoint) *curvePoint {
+	if p == nil {
+		return nil
+	}
+	return &curvePoint{p.x, modP(new(big.Int).Neg(p.y))}
+}
+
+// liftX returns the point with x coordinate x and the given y parity.
+func liftX(x *big.Int, odd bool) (*curvePoint, error) {
+	if x.Sign() < 0 || x.Cmp(curveP) >= 0 {
+		return nil, errInvalidPoint
+	}
+	// y² = x³ + 7.
+	c := new(big.Int).Exp(x, big.NewInt(3), curveP)
+	c = modP(c.Add(c, big.NewInt(7)))
+	y := new(big.Int).ModSqrt(c, curveP)
+	if y == nil {
+		return nil, errInvalidPoint
+	}
+	if (y.Bit(0) == 1) != odd {
+		y.Sub(curveP, y)
+	}
+	return &curvePoint{x, y}, nil
+}
+
+// parsePubKey decodes a compressed or uncompressed public key.
+func parsePubKey(b []byte) (*curvePoint, error) {
+	switch {
+	case len(b) == 33 && (b[0] == 2 || b[0] == 3):
+		return liftX(new(big.Int).SetBytes(b[1:]), b[0] == 3)
+	case len(b) == 65 && b[0] == 4:
+		p := &curvePoint{new(big.Int).SetBytes(b[1:33]),
+			new(big.Int).SetBytes(b[33:])}
+		if check, err := liftX(p.x, p.y.Bit(0) == 1); err != nil ||
+			check.y.Cmp(p.y) != 0 {
+			return nil, errInvalidPoint
+		}
+		return p, nil
+	}
+	return nil, errInvalidPoint
+}
+
+// scalarBytes returns v as 32 big-endian bytes.
+func scalarBytes(v *big.Int) []byte {
+	return v.FillBytes(make([]byte, 32))
+}
+
+// serialize encodes p as a compressed or uncompressed public key.
+func (p *curvePoint) serialize(compressed bool) []byte {
+	if !compressed {
+		return append(append([]byte{4}, scalarBytes(p.x)...),
+			scalarBytes(p.y)...)
+	}
+	return append([]byte{2 + byte(p.y.Bit(0))}, scalarBytes(p.x)...)
+}
+
+// taggedHash is the BIP 340 tagged hash SHA-256(SHA-256(tag) ||
+// SHA-256(tag) || data).
+func taggedHash(tag string, data ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// rfc6979Nonces returns a function generating the RFC 6979 deterministic
+// nonces for signing hash with key d and optional extra data.
+func rfc6979Nonces(d *big.Int, hash, extra []byte) func() *big.Int {
+	mac := func(key []byte, data ...[]byte) []byte {
+		m := hmac.New(sha256.New, key)
+		for _, b := range data {
+			m.Write(b)
+		}
+		return m.Sum(nil)
+	}
+	x := scalarBytes(d)
+	h := scalarBytes(new(big.Int).Mod(new(big.Int).SetBytes(hash), curveN))
+	v := make([]byte, 32)
+	for i := range v {
+		v[i] = 1
+	}
+	k := make([]byte, 32)
+	k = mac(k, v, []byte{0}, x, h, extra)
+	v = mac(k, v)
+	k = mac(k, v, []byte{1}, x, h, extra)
+	v = mac(k, v)
+	first := true
+	return func() *big.Int {
+		for {
+			if !first {
+				k = mac(k, v, []byte{0})
+				v = mac(k, v)
+			}
+			first = false
+			v = mac(k, v)
+			if n := new(big.Int).SetBytes(v); n.Sign() > 0 &&
+				n.Cmp(curveN) < 0 {
+				return n
+			}
+		}
+	}
+}
+
+// signECDSA signs hash with key d, returning a low S signature and the
+// recovery ID of the public key. Like Bitcoin Core, if lowR is set it adds a
+// counter as extra nonce data until R has no high bit, saving a byte of DER.
+func signECDSA(d *big.Int, hash []byte, lowR bool) (r, s *big.Int,
+	recovery byte) {
+	e := new(big.Int).SetBytes(hash)
+	nonce := rfc6979Nonces(d, hash, nil)
+	for counter := uint32(1); ; {
+		k := nonce()
+		R := pointMul(curveG, k)
+		r = new(big.Int).Mod(R.x, curveN)
+		if r.Sign() == 0 {
+			continue
+		}
+		s = new(big.Int).Mul(r, d)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(k, curveN))
+		s.Mod(s, curveN)
+		if s.Sign() == 0 {
+			continue
+		}
+		recovery = byte(R.y.Bit(0))
+		if R.x.Cmp(curveN) >= 0 {
+			recovery |= 2
+		}
+		if s.Cmp(curveHalfN) > 0 {
+			s.Sub(curveN, s)
+			recovery ^= 1
+		}
+		if lowR && r.BitLen() == 256 {
+			extra := make([]byte, 32)
+			binary.LittleEndian.PutUint32(extra, counter)
+			nonce = rfc6979Nonces(d, hash, extra)
+			counter++
+			continue
+		}
+		return r, s, recovery
+	}
+}
+
+// verifyECDSA reports whether r and s are a signature of hash by pub.
+func verifyECDSA(pub *curvePoint, hash []byte, r, s *big.Int) bool {
+	if r.Sign() <= 0 || s.Sign() <= 0 || r.Cmp(curveN) >= 0 ||
+		s.Cmp(curveN) >= 0 {
+		return false
+	}
+	w := new(big.Int).ModInverse(s, curveN)
+	u1 := new(big.Int).SetBytes(hash)
+	u1.Mul(u1, w).Mod(u1, curveN)
+	u2 := new(big.Int).Mul(r, w)
+	u2.Mod(u2, curveN)
+	X := pointAdd(pointMul(curveG, u1), pointMul(pub, u2))
+	return X != nil && new(big.Int).Mod(X.x, curveN).Cmp(r) == 0
+}
+
+// recoverPubKey returns the public key that made the signature r, s of hash
+// given its recovery ID.
+func recoverPubKey(hash []byte, r, s *big.Int, recovery byte) (*curvePoint,
+	error) {
+	if r.Sign() <= 0 || s.Sign() <= 0 || r.Cmp(curveN) >= 0 ||
+		s.Cmp(curveN) >= 0 || recovery > 3 {
+		return nil, errors.New("invalid signature")
+	}
+	x := new(big.Int).Set(r)
+	if recovery&2 != 0 {
+		x.Add(x, curveN)
+	}
+	R, err := liftX(x, recovery&1 == 1)
+	if err != nil {
+		return nil, err
+	}
+	// Q = r⁻¹(sR - eG).
+	e := new(big.Int).SetBytes(hash)
+	rInv := new(big.Int).ModInverse(r, curveN)
+	Q := pointAdd(pointMul(R, s), pointNeg(pointMul(curveG, e)))
+	Q = pointMul(Q, rInv)
+	if Q == nil {
+		return nil, errInvalidPoint
+	}
+	return Q, nil
+}
+
+// encodeDER encodes an ECDSA signature in DER.
+func encodeDER(r, s *big.Int) []byte {
+	integer := func(v *big.Int) []byte {
+		b := v.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return append([]byte{0x02, byte(len(b))}, b...)
+	}
+	body := append(integer(r), integer(s)...)
+	return append([]byte{0x30, byte(len(body))}, body...)
+}
+
+// parseDER decodes a strictly DER encoded ECDSA signature, as required by
+// BIP 66.
+func parseDER(b []byte) (r, s *big.Int, err error) {
+	errDER := errors.New("invalid DER signature")
+	if len(b) < 8 || len(b) > 72 || b[0] != 0x30 || int(b[1]) != len(b)-2 {
+		return nil, nil, errDER
+	}
+	rest := b[2:]
+	ints := []*big.Int{}
+	for i := 0; i < 2; i++ {
+		if len(rest) < 2 || rest[0] != 0x02 || int(rest[1]) > len(rest)-2 ||
+			rest[1] == 0 {
+			return nil, nil, errDER
+		}
+		v := rest[2 : 2+rest[1]]
+		if v[0]&0x80 != 0 || (len(v) > 1 && v[0] == 0 && v[1]&0x80 == 0) {
+			return nil, nil, errDER
+		}
+		ints = append(ints, new(big.Int).SetBytes(v))
+		rest = rest[2+rest[1]:]
+	}
+	if len(rest) != 0 {
+		return nil, nil, errDER
+	}
+	return ints[0], ints[1], nil
+}
+
+// signSchnorr makes a BIP 340 signature of msg with key d using the
+// auxiliary randomness aux.
+func signSchnorr(d *big.Int, msg, aux []byte) []byte {
+	P := pointMul(curveG, d)
+	if P.y.Bit(0) == 1 {
+		d = new(big.Int).Sub(curveN, d)
+	}
+	t := taggedHash("BIP0340/aux", aux)
+	for i, b := range scalarBytes(d) {
+		t[i] ^= b
+	}
+	px := scalarBytes(P.x)
+	k := new(big.Int).SetBytes(taggedHash("BIP0340/nonce", t, px, msg))
+	k.Mod(k, curveN)
+	R := pointMul(curveG, k)
+	if R.y.Bit(0) == 1 {
+		k.Sub(curveN, k)
+	}
+	rx := scalarBytes(R.x)
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", rx, px, msg))
+	s := e.Mul(e, d)
+	s.Add(s, k).Mod(s, curveN)
+	return append(rx, scalarBytes(s)...)
+}
+
+// verifySchnorr reports whether sig is a BIP 340 signature of msg by the
+// x-only public key pub.
+func verifySchnorr(pub, msg, sig []byte) bool {
+	if len(pub) != 32 || len(sig) != 64 {
+		return false
+	}
+	P, err := liftX(new(big.Int).SetBytes(pub), false)
+	if err != nil {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if r.Cmp(curveP) >= 0 || s.Cmp(curveN) >= 0 {
+		return false
+	}
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", sig[:32], pub,
+		msg))
+	e.Mod(e, curveN)
+	R := pointAdd(pointMul(curveG, s), pointNeg(pointMul(P, e)))
+	return R != nil && R.y.Bit(0) == 0 && R.x.Cmp(r) == 0
+}
+
+// taprootTweak returns the BIP 86 taproot output key of the internal key P,
+// which commits to no script tree, and the tweak added to it.
+func taprootTweak(P *curvePoint) (*curvePoint, *big.Int) {
+	even, _ := liftX(P.x, false)
+	t := new(big.Int).SetBytes(taggedHash("TapTweak", scalarBytes(P.x)))
+	return pointAdd(even, pointMul(curveG, t)), t
+}
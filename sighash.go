@@ -0,0 +1,98 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// Signature hash types.
+const (
+	sigHashDefault = 0x00
+	sigHashAll     = 0x01
+)
+
+// outpointBytes returns the serialized outpoint spent by in.
+func outpointBytes(in RawInput) ([]byte, error) {
+	h, err := hashFromHex(in.PreviousHash)
+	if err != nil {
+		return nil, err
+	}
+	return binary.LittleEndian.AppendUint32(h[:], in.PreviousIndex), nil
+}
+
+// witnessV0Sighash returns the BIP 143 SIGHASH_ALL signature hash of input i
+// of tx, which spends amount with scriptCode.
+func witnessV0Sighash(tx *RawTransaction, i int, scriptCode []byte,
+	amount Amount) ([]byte, error) {
+	var prevouts, sequences, outputs []byte
+	for _, in := range tx.Inputs {
+		outpoint, err := outpointBytes(in)
+		if err != nil {
+			return nil, err
+		}
+		prevouts = append(prevouts, outpoint...)
+		sequences = binary.LittleEndian.AppendUint32(sequences, in.Sequence)
+	}
+	for _, out := range tx.Outputs {
+		outputs = binary.LittleEndian.AppendUint64(outputs, uint64(out.Value))
+		outputs = writeVarInt(outputs, uint64(len(out.Script)))
+		outputs = append(outputs, out.Script...)
+	}
+	outpoint, err := outpointBytes(tx.Inputs[i])
+	if err != nil {
+		return nil, err
+	}
+
+	hashPrevouts := doubleSHA256(prevouts)
+	hashSequence := doubleSHA256(sequences)
+	hashOutputs := doubleSHA256(outputs)
+	b := binary.LittleEndian.AppendUint32(nil, uint32(tx.Version))
+	b = append(append(b, hashPrevouts[:]...), hashSequence[:]...)
+	b = append(b, outpoint...)
+	b = writeVarInt(b, uint64(len(scriptCode)))
+	b = append(b, scriptCode...)
+	b = binary.LittleEndian.AppendUint64(b, uint64(amount))
+	b = binary.LittleEndian.AppendUint32(b, tx.Inputs[i].Sequence)
+	b = append(b, hashOutputs[:]...)
+	b = binary.LittleEndian.AppendUint32(b, tx.LockTime)
+	b = binary.LittleEndian.AppendUint32(b, sigHashAll)
+	h := doubleSHA256(b)
+	return h[:], nil
+}
+
+// taprootSighash returns the BIP 341 key path signature hash of input i of
+// tx, which spends prevouts, for SIGHASH_DEFAULT or SIGHASH_ALL.
+func taprootSighash(tx *RawTransaction, i int, prevouts []RawOutput,
+	hashType byte) ([]byte, error) {
+	var outpoints, amounts, scripts, sequences, outputs []byte
+	for j, in := range tx.Inputs {
+		outpoint, err := outpointBytes(in)
+		if err != nil {
+			return nil, err
+		}
+		outpoints = append(outpoints, outpoint...)
+		sequences = binary.LittleEndian.AppendUint32(sequences, in.Sequence)
+		amounts = binary.LittleEndian.AppendUint64(amounts,
+			uint64(prevouts[j].Value))
+		scripts = writeVarInt(scripts, uint64(len(prevouts[j].Script)))
+		scripts = append(scripts, prevouts[j].Script...)
+	}
+	for _, out := range tx.Outputs {
+		outputs = binary.LittleEndian.AppendUint64(outputs, uint64(out.Value))
+		outputs = writeVarInt(outputs, uint64(len(out.Script)))
+		outputs = append(outputs, out.Script...)
+	}
+
+	// The epoch, hash type, transaction data, spend type and input index.
+	b := []byte{0x00, hashType}
+	b = binary.LittleEndian.AppendUint32(b, uint32(tx.Version))
+	b = binary.LittleEndian.AppendUint32(b, tx.LockTime)
+	for _, data := range [][]byte{outpoints, amounts, scripts, sequences,
+		outputs} {
+		h := sha256.Sum256(data)
+		b = append(b, h[:]...)
+	}
+	b = append(b, 0x00)
+	b = binary.LittleEndian.AppendUint32(b, uint32(i))
+	return taggedHash("TapSighash", b), nil
+}
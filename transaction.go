@@ -3,20 +3,32 @@ package chain
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // GetTransactionMultiWorkers determines how many worker go routines are used
 // concurrently to get transactions from the Chain.com API endpoint.
 const GetTransactionMultiWorkers = 5
 
+// MaxTransactionBatch is the number of transaction hashes GetTransactionMulti
+// requests from the Chain.com API endpoint in one call. A batch is requested
+// by joining the hashes with commas, as the address endpoints accept
+// addresses, and the response is a JSON array of transaction objects in any
+// order.
+const MaxTransactionBatch = 50
+
 // Input represents a Bitcoin transaction input.
 type Input struct {
 	TransactionHash string `json:"transaction_hash"`
 	OutputHash      string `json:"output_hash"`
 	OutputIndex     uint32 `json:"output_index"`
-	Value           int64
+	Value           Amount
 	Addresses       []string
 	ScriptSignature string `json:"script_signature"`
 	Sequence        uint32
@@ -29,7 +41,7 @@ type Input struct {
 type Output struct {
 	TransactionHash    string `json:"transaction_hash"`
 	OutputIndex        uint32 `json:"output_index"`
-	Value              int64
+	Value              Amount
 	Addresses          []string
 	Script             string
 	ScriptHex          string `json:"script_hex"`
@@ -46,15 +58,17 @@ type Output struct {
 // Chain documentation can be found here
 // https://chain.com/docs#object-bitcoin-transaction.
 type Transaction struct {
-	Hash          string
-	BlockHash     string `json:"block_hash"`
-	BlockHeight   int64  `json:"block_height"`
-	BlockTime     string `json:"block_time"`
+	Hash        string
+	BlockHash   string `json:"block_hash"`
+	BlockHeight int64  `json:"block_height"`
+
+	// BlockTime is the zero time for unconfirmed transactions.
+	BlockTime     time.Time `json:"block_time"`
 	Confirmations int64
 	Inputs        []Input
 	Outputs       []Output
-	Amount        int64
-	Fees          int64
+	Amount        Amount
+	Fees          Amount
 }
 
 func (c *Chain) transactionURL(hash string) string {
@@ -68,8 +82,10 @@ func (c *Chain) transactionURL(hash string) string {
 // Chain documentation can be found here
 // https://chain.com/docs#bitcoin-transaction.
 func (c *Chain) GetTransaction(hash string) (Transaction, error) {
-	url, tx := c.transactionURL(hash), Transaction{}
-	return tx, c.httpGetJSON(url, &tx)
+	if c.backend != nil {
+		return c.backend.GetTransaction(hash)
+	}
+	return c.getTransaction(c.transactionURL(hash))
 }
 
 func (c *Chain) sendTransactionURL() string {
@@ -78,65 +94,223 @@ func (c *Chain) sendTransactionURL() string {
 }
 
 // GetTransactionMulti returns a Transaction slice for all the TransactionHashes
-// within the block. Note that it currently calls the chain.com API endpoint
-// multiple times. This function produces an error if any of the API endpoint
-// calls fails.
+// within the block. Transactions are requested MaxTransactionBatch hashes at a
+// time using GetTransactionMultiWorkers concurrent API calls. Hashes in a batch
+// that fails, or that are missing from a batch response, are retried with
+// individual API calls so that errors are reported per hash. If any hash
+// fails a MultiError is returned. A Chain with a Backend makes one call per
+// hash.
+//
+// If a batch fails, or its response is not a JSON array, but every hash in
+// it can be fetched on its own, the API does not support batches and c, and
+// any Chain derived from it, stops requesting them.
 func (c *Chain) GetTransactionMulti(hashes []string) ([]Transaction, error) {
-	type request struct {
-		index int
-		hash  string
-	}
-	type response struct {
-		index int
-		tx    Transaction
-		err   error
-	}
-
 	txns := make([]Transaction, len(hashes))
 	errs := make(MultiError, len(hashes))
-	requestChan := make(chan request, len(hashes))
-	responseChan := make(chan response)
 
-	for i := 0; i < GetTransactionMultiWorkers; i++ {
-		go func() {
-			for req := range requestChan {
-				tx, err := c.GetTransaction(req.hash)
-				responseChan <- response{req.index, tx, err}
+	// Each distinct hash is requested once, cached transactions not at all.
+	indexes, unique := map[string][]int{}, []string{}
+	for i, hash := range hashes {
+		if _, ok := indexes[hash]; !ok {
+			unique = append(unique, hash)
+		}
+		indexes[hash] = append(indexes[hash], i)
+	}
+	batched, single := []string{}, []string{}
+	noBatch := atomic.LoadInt32(c.noBatch) == 1
+	for _, hash := range unique {
+		if c.backend != nil || noBatch {
+			single = append(single, hash)
+			continue
+		}
+		if c.cache != nil {
+			if _, ok := c.cache.Get(c.transactionURL(hash)); ok {
+				single = append(single, hash)
+				continue
 			}
-		}()
+		}
+		batched = append(batched, hash)
 	}
 
-	for i, hash := range hashes {
-		requestChan <- request{i, hash}
+	batches := [][]string{}
+	for len(batched) > 0 {
+		n := MaxTransactionBatch
+		if n > len(batched) {
+			n = len(batched)
+		}
+		batches = append(batches, batched[:n])
+		batched = batched[n:]
 	}
-	close(requestChan)
 
-	isErrors := false
-	for i := 0; i < len(hashes); i++ {
-		resp := <-responseChan
+	results := make([][]Transaction, len(batches))
+	batchErrs := make([]error, len(batches))
+	runWorkers(len(batches), func(i int) {
+		// A single hash gets the object response of GetTransaction.
+		if len(batches[i]) == 1 {
+			return
+		}
+		results[i], batchErrs[i] = c.getTransactionBatch(batches[i])
+	})
 
-		txns[resp.index] = resp.tx
-		if resp.err != nil {
-			isErrors = true
+	for i, batch := range batches {
+		found := make(map[string]Transaction, len(results[i]))
+		for _, tx := range results[i] {
+			found[tx.Hash] = tx
+		}
+		for _, hash := range batch {
+			tx, ok := found[hash]
+			if !ok {
+				single = append(single, hash)
+				continue
+			}
+			for _, index := range indexes[hash] {
+				txns[index] = tx
+			}
 		}
-		errs[resp.index] = resp.err
 	}
-	close(responseChan)
 
-	if isErrors {
-		return txns, errs
+	runWorkers(len(single), func(i int) {
+		tx, err := c.GetTransaction(single[i])
+		for _, index := range indexes[single[i]] {
+			txns[index], errs[index] = tx, err
+		}
+	})
+
+	for i, batch := range batches {
+		if batchErrs[i] == nil {
+			continue
+		}
+		supported := !batchRejected(batchErrs[i])
+		for _, hash := range batch {
+			if errs[indexes[hash][0]] != nil {
+				supported = true
+			}
+		}
+		if !supported {
+			atomic.StoreInt32(c.noBatch, 1)
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return txns, errs
+		}
 	}
 	return txns, nil
 }
 
+// errBatchResponse is returned by getTransactionBatch when the response is
+// not a JSON array.
+var errBatchResponse = errors.New("transaction batch response is not an array")
+
+// batchRejected reports whether err could mean the API does not accept
+// batches rather than a transient failure.
+func batchRejected(err error) bool {
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr.StatusCode >= 400 && apiErr.StatusCode < 500
+	}
+	return err == errBatchResponse
+}
+
+// getTransactionBatch gets the transactions for hashes with one API call. The
+// response may omit transactions.
+func (c *Chain) getTransactionBatch(hashes []string) ([]Transaction, error) {
+	raw := json.RawMessage{}
+	if err := c.httpGetJSON(c.transactionURL(strings.Join(hashes, ",")),
+		&raw); err != nil {
+		return nil, err
+	}
+	txns := []Transaction{}
+	if err := json.Unmarshal(raw, &txns); err != nil {
+		return nil, errBatchResponse
+	}
+	for _, tx := range txns {
+		c.cacheTransaction(tx)
+	}
+	return txns, nil
+}
+
+// runWorkers calls fn for each index below n using up to
+// GetTransactionMultiWorkers go routines and waits for them to finish.
+func runWorkers(n int, fn func(i int)) {
+	indexes := make(chan int, n)
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	workers := GetTransactionMultiWorkers
+	if workers > n {
+		workers = n
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 // SendTransaction accepts a signed transaction in hex format and sends it to
 // the Bitcoin network. See http://blog.chain.com/post/86529167421/sending-bitcoin-transactions-with-node-js
 // for information on creating and signing raw transactions. The transaction
 // hash is returned on a successful send.
 //
+// If c has a send policy, see WithSendPolicy, the transaction is first
+// checked with CheckTransaction and a *PolicyError is returned instead of
+// sending it if it violates the policy.
+//
+// Sending is idempotent, so a send that timed out can safely be retried. The
+// hash is computed locally from the hex and the hash returned by the network
+// must match it, otherwise a *TransactionHashMismatchError is returned. If
+// the network reports that it already knows the transaction, or the send
+// fails but the transaction can be found with GetTransaction, the local hash
+// is returned without an error. Hex that cannot be decoded is sent as is.
+//
 // Chain documentation can be found here
 // https://chain.com/docs#bitcoin-transaction-send.
 func (c *Chain) SendTransaction(hex string) (string, error) {
+	if c.sendPolicy != nil {
+		report, err := c.CheckTransaction(hex, *c.sendPolicy)
+		if err != nil {
+			return "", err
+		}
+		if v := report.blocking(*c.sendPolicy); len(v) > 0 {
+			return "", &PolicyError{report, v}
+		}
+	}
+
+	hash := ""
+	if tx, err := DecodeRawTransaction(hex); err == nil {
+		hash, _ = tx.Hash()
+	}
+	sent, err := c.sendTransaction(hex)
+	switch {
+	case err == nil && hash != "" && !strings.EqualFold(sent, hash):
+		return "", &TransactionHashMismatchError{hash, sent}
+	case err == nil:
+		return sent, nil
+	case hash == "":
+		return "", err
+	case IsAlreadyKnown(err):
+		return hash, nil
+	}
+	if _, getErr := c.GetTransaction(hash); getErr == nil {
+		return hash, nil
+	}
+	return "", err
+}
+
+// sendTransaction sends hex without any checks.
+func (c *Chain) sendTransaction(hex string) (string, error) {
+	if c.backend != nil {
+		return c.backend.SendTransaction(hex)
+	}
 	url := c.sendTransactionURL()
 
 	jsonRequest := struct {
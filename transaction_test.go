@@ -0,0 +1,154 @@
+package chain_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/qedus/chain"
+)
+
+// transactionRequests returns the number of batch and single transaction
+// requests made to api.
+func transactionRequests(api *fakeAPI) (batch, single int) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	prefix := "/v2/" + string(api.net) + "/transactions/"
+	for path, n := range api.requests {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		if strings.Contains(path, ",") {
+			batch += n
+		} else {
+			single += n
+		}
+	}
+	return batch, single
+}
+
+func TestGetTransactionMultiBatches(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 1)
+	c := newTestChain(t, chain.MainNet, api)
+
+	hashes := []string{}
+	for i := 0; i < 2*chain.MaxTransactionBatch+1; i++ {
+		tx := payment(fmt.Sprint(i), otherAddress, testAddress, 1)
+		api.addTransaction(tx)
+		hashes = append(hashes, tx.Hash)
+	}
+
+	txns, err := c.GetTransactionMulti(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tx := range txns {
+		if tx.Hash != hashes[i] {
+			t.Fatal("transaction out of order", i, tx.Hash)
+		}
+	}
+	// The last batch has a single hash so is requested on its own.
+	if batch, single := transactionRequests(api); batch != 2 || single != 1 {
+		t.Fatal("unexpected requests", batch, single)
+	}
+}
+
+func TestGetTransactionMultiFallback(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 1)
+	c := newTestChain(t, chain.MainNet, api)
+
+	hashes := []string{}
+	for i := 0; i < 3; i++ {
+		tx := payment(fmt.Sprint(i), otherAddress, testAddress, 1)
+		api.addTransaction(tx)
+		hashes = append(hashes, tx.Hash)
+	}
+	missing := fakeHash("missing")
+	hashes = append(hashes[:1], append([]string{missing}, hashes[1:]...)...)
+	hashes = append(hashes, hashes[0])
+
+	txns, err := c.GetTransactionMulti(hashes)
+	errs, ok := err.(chain.MultiError)
+	if !ok || len(errs) != len(hashes) {
+		t.Fatal("expected one-to-one MultiError", err)
+	}
+	for i, hash := range hashes {
+		if hash == missing {
+			if !chain.IsNotFound(errs[i]) {
+				t.Fatal("expected not found error", i, errs[i])
+			}
+			continue
+		}
+		if errs[i] != nil || txns[i].Hash != hash {
+			t.Fatal("unexpected result", i, txns[i].Hash, errs[i])
+		}
+	}
+
+	// The failed batch is retried per distinct hash.
+	if batch, single := transactionRequests(api); batch != 1 || single != 4 {
+		t.Fatal("unexpected requests", batch, single)
+	}
+
+	// A missing hash explains the failure so batching stays on.
+	if _, err := c.GetTransactionMulti(hashes[2:]); err != nil {
+		t.Fatal(err)
+	}
+	if batch, _ := transactionRequests(api); batch != 2 {
+		t.Fatal("expected another batch request", batch)
+	}
+}
+
+func TestGetTransactionMultiNoBatch(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 1)
+	api.noBatch = true
+	c := newTestChain(t, chain.MainNet, api)
+
+	hashes := []string{}
+	for i := 0; i < 3; i++ {
+		tx := payment(fmt.Sprint(i), otherAddress, testAddress, 1)
+		api.addTransaction(tx)
+		hashes = append(hashes, tx.Hash)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetTransactionMulti(hashes); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the first call tries a batch; every hash exists so batching is
+	// turned off, also for Chains derived from c.
+	if batch, single := transactionRequests(api); batch != 1 || single != 6 {
+		t.Fatal("unexpected requests", batch, single)
+	}
+	if _, err := c.WithContext(context.Background()).GetTransactionMulti(
+		hashes); err != nil {
+		t.Fatal(err)
+	}
+	if batch, _ := transactionRequests(api); batch != 1 {
+		t.Fatal("unexpected batch requests", batch)
+	}
+}
+
+func TestGetTransactionMultiCache(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 1)
+	c := newTestChain(t, chain.MainNet, api)
+	c.SetCache(chain.NewLRUCache(100), chain.CachePolicy{})
+
+	hashes := []string{}
+	for i := 0; i < 3; i++ {
+		tx := payment(fmt.Sprint(i), otherAddress, testAddress, 1)
+		api.addTransaction(tx)
+		hashes = append(hashes, tx.Hash)
+	}
+	if _, err := c.GetTransactionMulti(hashes); err != nil {
+		t.Fatal(err)
+	}
+
+	// Transactions from a batch are cached for GetTransaction.
+	if _, err := c.GetTransaction(hashes[1]); err != nil {
+		t.Fatal(err)
+	}
+	if batch, single := transactionRequests(api); batch != 1 || single != 0 {
+		t.Fatal("unexpected requests", batch, single)
+	}
+}
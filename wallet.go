@@ -0,0 +1,419 @@
+package chain
+
+import (
+	"os"
+	"sort"
+	"sync"
+)
+
+// WalletTransaction is a transaction in a Wallet's history together with its
+// effect on the watched addresses.
+type WalletTransaction struct {
+	Transaction Transaction
+
+	// Net is the amount received by watched addresses minus the amount they
+	// sent. It is negative for payments out of the wallet.
+	Net Amount
+}
+
+// WalletState is the persisted state of a Wallet.
+type WalletState struct {
+	// Addresses is the set of watched addresses.
+	Addresses []string
+
+	// Balances holds the balances of the watched addresses in the same order
+	// as Addresses.
+	Balances []Address
+
+	// Transactions is the deduplicated transaction history of the watched
+	// addresses, newest first.
+	Transactions []WalletTransaction
+
+	// Unspents is the set of unspent outputs paying to watched addresses.
+	Unspents []Output
+}
+
+// WalletStore persists the state of a Wallet.
+type WalletStore interface {
+	// LoadWallet returns the last saved state or nil if there is none.
+	LoadWallet() (*WalletState, error)
+
+	// SaveWallet replaces the saved state.
+	SaveWallet(*WalletState) error
+}
+
+// MemoryWalletStore is a WalletStore that keeps the state in memory.
+type MemoryWalletStore struct {
+	mu    sync.Mutex
+	state *WalletState
+}
+
+// LoadWallet implements WalletStore.
+func (s *MemoryWalletStore) LoadWallet() (*WalletState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return nil, nil
+	}
+	state := *s.state
+	return &state, nil
+}
+
+// SaveWallet implements WalletStore.
+func (s *MemoryWalletStore) SaveWallet(state *WalletState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *state
+	s.state = &copied
+	return nil
+}
+
+// FileWalletStore is a WalletStore that keeps the state as JSON in a file.
+// Saves are atomic.
+type FileWalletStore struct {
+	Path string
+}
+
+// LoadWallet implements WalletStore.
+func (s FileWalletStore) LoadWallet() (*WalletState, error) {
+	state := &WalletState{}
+	if err := readJSONFile(s.Path, state); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// SaveWallet implements WalletStore.
+func (s FileWalletStore) SaveWallet(state *WalletState) error {
+	return writeJSONFile(s.Path, state)
+}
+
+// Wallet is a watch-only wallet over a set of addresses. It tracks the
+// confirmed and unconfirmed balance, the transaction history and the unspent
+// outputs of the addresses using GetAddressMulti,
+// GetAddressTransactionsMulti and GetAddressUnspentOutputsMulti.
+//
+// Refresh is incremental: only the most recent transactions are fetched,
+// plus any known transactions that are still unconfirmed. Unconfirmed
+// transactions that disappear from the network, for example because they
+// were double spent, are removed from the history.
+//
+// A Wallet is safe for concurrent use.
+type Wallet struct {
+	chain *Chain
+	store WalletStore
+
+	mu    sync.Mutex
+	state WalletState
+}
+
+// NewWallet creates a Wallet that reads from c and persists its state in
+// store. Any previously saved state is loaded.
+func NewWallet(c *Chain, store WalletStore) (*Wallet, error) {
+	w := &Wallet{chain: c, store: store}
+	state, err := store.LoadWallet()
+	if err != nil {
+		return nil, err
+	}
+	if state != nil {
+		w.state = *state
+	}
+	return w, nil
+}
+
+// Watch adds addresses to the watched set. Call Refresh to fetch their
+// history.
+func (w *Wallet) Watch(addresses ...string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	watched := w.watched()
+	for _, a := range addresses {
+		if !watched[a] {
+			watched[a] = true
+			w.state.Addresses = append(w.state.Addresses, a)
+		}
+	}
+	return w.store.SaveWallet(&w.state)
+}
+
+// Unwatch removes addresses from the watched set along with any history and
+// unspent outputs that no longer involve a watched address.
+func (w *Wallet) Unwatch(addresses ...string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	removed := make(map[string]bool, len(addresses))
+	for _, a := range addresses {
+		removed[a] = true
+	}
+	kept, balances := []string{}, []Address{}
+	for i, a := range w.state.Addresses {
+		if removed[a] {
+			continue
+		}
+		kept = append(kept, a)
+		if i < len(w.state.Balances) {
+			balances = append(balances, w.state.Balances[i])
+		}
+	}
+	w.state.Addresses, w.state.Balances = kept, balances
+
+	watched := w.watched()
+	unspents := []Output{}
+	for _, out := range w.state.Unspents {
+		if involvesAny(out.Addresses, watched) {
+			unspents = append(unspents, out)
+		}
+	}
+	w.state.Unspents = unspents
+	w.mergeHistory(nil, nil)
+	return w.store.SaveWallet(&w.state)
+}
+
+func (w *Wallet) watched() map[string]bool {
+	watched := make(map[string]bool, len(w.state.Addresses))
+	for _, a := range w.state.Addresses {
+		watched[a] = true
+	}
+	return watched
+}
+
+func involvesAny(addresses []string, watched map[string]bool) bool {
+	for _, a := range addresses {
+		if watched[a] {
+			return true
+		}
+	}
+	return false
+}
+
+// netEffect returns the amount tx pays to watched addresses minus the amount
+// it spends from them, and whether tx involves them at all.
+func netEffect(tx Transaction, watched map[string]bool) (Amount, bool) {
+	net, involved := Amount(0), false
+	for _, in := range tx.Inputs {
+		if involvesAny(in.Addresses, watched) {
+			net -= in.Value
+			involved = true
+		}
+	}
+	for _, out := range tx.Outputs {
+		if involvesAny(out.Addresses, watched) {
+			net += out.Value
+			involved = true
+		}
+	}
+	return net, involved
+}
+
+// NetAmount returns the amount tx pays to addresses minus the amount it
+// spends from them.
+func NetAmount(tx Transaction, addresses []string) Amount {
+	watched := make(map[string]bool, len(addresses))
+	for _, a := range addresses {
+		watched[a] = true
+	}
+	net, _ := netEffect(tx, watched)
+	return net
+}
+
+// mergeHistory replaces known transactions with updated, adds new ones,
+// removes those in dropped, recomputes net effects and sorts the history
+// newest first. Transactions that no longer involve a watched address are
+// removed.
+func (w *Wallet) mergeHistory(updated []Transaction, dropped map[string]bool) {
+	byHash := make(map[string]Transaction, len(w.state.Transactions))
+	for _, wt := range w.state.Transactions {
+		byHash[wt.Transaction.Hash] = wt.Transaction
+	}
+	for _, tx := range updated {
+		byHash[tx.Hash] = tx
+	}
+
+	watched := w.watched()
+	history := make([]WalletTransaction, 0, len(byHash))
+	for hash, tx := range byHash {
+		if dropped[hash] {
+			continue
+		}
+		if net, ok := netEffect(tx, watched); ok {
+			history = append(history, WalletTransaction{tx, net})
+		}
+	}
+	sort.Slice(history, func(i, j int) bool {
+		a, b := history[i].Transaction, history[j].Transaction
+		// Unconfirmed transactions, with a zero height, sort first.
+		ha, hb := a.BlockHeight, b.BlockHeight
+		if a.Confirmations == 0 {
+			ha = 1<<63 - 1
+		}
+		if b.Confirmations == 0 {
+			hb = 1<<63 - 1
+		}
+		if ha != hb {
+			return ha > hb
+		}
+		return a.Hash < b.Hash
+	})
+	w.state.Transactions = history
+}
+
+// addressChunks splits addresses into groups accepted by Multi methods.
+func addressChunks(addresses []string) [][]string {
+	chunks := [][]string{}
+	for len(addresses) > MaxAddresses {
+		chunks = append(chunks, addresses[:MaxAddresses])
+		addresses = addresses[MaxAddresses:]
+	}
+	if len(addresses) > 0 {
+		chunks = append(chunks, addresses)
+	}
+	return chunks
+}
+
+// Refresh fetches balances, recent transactions and unspent outputs for the
+// watched addresses and persists the new state.
+func (w *Wallet) Refresh() error {
+	w.mu.Lock()
+	addresses := append([]string(nil), w.state.Addresses...)
+	known := make(map[string]Transaction, len(w.state.Transactions))
+	for _, wt := range w.state.Transactions {
+		known[wt.Transaction.Hash] = wt.Transaction
+	}
+	w.mu.Unlock()
+
+	balances, unspents, updated := []Address{}, []Output{}, []Transaction{}
+	fetched := map[string]bool{}
+	for _, chunk := range addressChunks(addresses) {
+		b, err := w.chain.GetAddressMulti(chunk)
+		if err != nil {
+			return err
+		}
+		balances = append(balances, b...)
+
+		u, err := w.chain.GetAddressUnspentOutputsMulti(chunk)
+		if err != nil {
+			return err
+		}
+		unspents = append(unspents, u...)
+
+		txns, err := w.recentTransactions(chunk, known)
+		if err != nil {
+			return err
+		}
+		for _, tx := range txns {
+			if !fetched[tx.Hash] {
+				fetched[tx.Hash] = true
+				updated = append(updated, tx)
+			}
+		}
+	}
+
+	// Known unconfirmed transactions that were not in the recent history
+	// are fetched individually to update their confirmations.
+	pending := []string{}
+	for hash, tx := range known {
+		if tx.Confirmations == 0 && !fetched[hash] {
+			pending = append(pending, hash)
+		}
+	}
+	dropped := map[string]bool{}
+	if len(pending) > 0 {
+		txns, err := w.chain.GetTransactionMulti(pending)
+		errs, _ := err.(MultiError)
+		if err != nil && errs == nil {
+			return err
+		}
+		for i, tx := range txns {
+			switch {
+			case errs != nil && IsNotFound(errs[i]):
+				dropped[pending[i]] = true
+			case errs != nil && errs[i] != nil:
+				return errs[i]
+			default:
+				updated = append(updated, tx)
+			}
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// If the watched set changed during the refresh the balances and
+	// unspent outputs are stale; the next refresh picks up the change.
+	if equalStrings(w.state.Addresses, addresses) {
+		w.state.Balances = balances
+		w.state.Unspents = unspents
+	}
+	w.mergeHistory(updated, dropped)
+	return w.store.SaveWallet(&w.state)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// recentTransactions fetches the most recent transactions for addresses. If
+// none of them are already known a larger page is fetched, so the first
+// refresh gets as much history as the API allows.
+func (w *Wallet) recentTransactions(addresses []string,
+	known map[string]Transaction) ([]Transaction, error) {
+	txns, err := w.chain.GetAddressTransactionsMulti(addresses,
+		DefaultAddressTransactionsLimit)
+	if err != nil {
+		return nil, err
+	}
+	if len(txns) < DefaultAddressTransactionsLimit {
+		return txns, nil
+	}
+	for _, tx := range txns {
+		if _, ok := known[tx.Hash]; ok {
+			return txns, nil
+		}
+	}
+	return w.chain.GetAddressTransactionsMulti(addresses,
+		MaxAddressTransactionsLimit)
+}
+
+// Addresses returns the watched addresses.
+func (w *Wallet) Addresses() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.state.Addresses...)
+}
+
+// Balance returns the confirmed balance and the unconfirmed change to it of
+// the watched addresses as of the last Refresh.
+func (w *Wallet) Balance() (confirmed, unconfirmed Amount) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, b := range w.state.Balances {
+		confirmed += b.Confirmed.Balance
+		unconfirmed += b.Total.Balance - b.Confirmed.Balance
+	}
+	return confirmed, unconfirmed
+}
+
+// History returns the transaction history, newest first.
+func (w *Wallet) History() []WalletTransaction {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]WalletTransaction(nil), w.state.Transactions...)
+}
+
+// Unspents returns the unspent outputs of the watched addresses.
+func (w *Wallet) Unspents() []Output {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]Output(nil), w.state.Unspents...)
+}
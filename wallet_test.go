@@ -0,0 +1,145 @@
+package chain_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/qedus/chain"
+)
+
+func TestWallet(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 10)
+	c := newTestChain(t, chain.MainNet, api)
+	store := chain.FileWalletStore{
+		Path: filepath.Join(t.TempDir(), "wallet.json"),
+	}
+
+	w, err := chain.NewWallet(c, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Watch(testAddress); err != nil {
+		t.Fatal(err)
+	}
+
+	incoming := payment("incoming", otherAddress, testAddress, 50000)
+	api.mine(incoming)
+
+	// Spend the incoming output back to the other address with change.
+	outgoing := chain.Transaction{
+		Hash: fakeHash("tx", "outgoing"),
+		Inputs: []chain.Input{{
+			OutputHash: incoming.Hash,
+			Value:      50000,
+			Addresses:  []string{testAddress},
+		}},
+		Outputs: []chain.Output{
+			{Value: 20000, Addresses: []string{otherAddress}},
+			{Value: 29000, Addresses: []string{testAddress}},
+		},
+		Fees: 1000,
+	}
+	api.addTransaction(outgoing)
+
+	if err := w.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	confirmed, unconfirmed := w.Balance()
+	if confirmed != 50000 || unconfirmed != -21000 {
+		t.Fatal("unexpected balance", confirmed, unconfirmed)
+	}
+
+	history := w.History()
+	if len(history) != 2 {
+		t.Fatal("expected 2 transactions", len(history))
+	}
+	if history[0].Transaction.Hash != outgoing.Hash || history[0].Net != -21000 {
+		t.Fatal("expected unconfirmed outgoing transaction first", history[0])
+	}
+	if history[1].Net != 50000 || history[1].Transaction.Confirmations != 1 {
+		t.Fatal("unexpected incoming transaction", history[1])
+	}
+
+	unspents := w.Unspents()
+	if len(unspents) != 1 || unspents[0].Value != 29000 {
+		t.Fatal("expected change output unspent", unspents)
+	}
+
+	// Reload the wallet from its store, confirm the outgoing transaction and
+	// refresh again.
+	if w, err = chain.NewWallet(c, store); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.History()) != 2 {
+		t.Fatal("expected history restored from store")
+	}
+	api.mine(outgoing)
+	api.extend(2)
+	if err := w.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if confirmed, unconfirmed := w.Balance(); confirmed != 29000 ||
+		unconfirmed != 0 {
+		t.Fatal("unexpected balance", confirmed, unconfirmed)
+	}
+	if history := w.History(); history[0].Transaction.Confirmations != 3 {
+		t.Fatal("expected updated confirmations", history[0])
+	}
+}
+
+func TestWalletDroppedTransaction(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 10)
+	c := newTestChain(t, chain.MainNet, api)
+
+	w, err := chain.NewWallet(c, &chain.MemoryWalletStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Watch(testAddress, otherAddress)
+	api.addTransaction(payment("pending", otherAddress, testAddress, 7000))
+	if err := w.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if history := w.History(); len(history) != 1 || history[0].Net != -1000 {
+		t.Fatal("expected fee as net effect between watched addresses",
+			history)
+	}
+
+	// The unconfirmed transaction disappears from the network.
+	api.mu.Lock()
+	delete(api.txns, fakeHash("tx", "pending"))
+	api.order = nil
+	api.mu.Unlock()
+	if err := w.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if history := w.History(); len(history) != 0 {
+		t.Fatal("expected dropped transaction removed", history)
+	}
+
+	if err := w.Unwatch(otherAddress); err != nil {
+		t.Fatal(err)
+	}
+	if addresses := w.Addresses(); len(addresses) != 1 ||
+		addresses[0] != testAddress {
+		t.Fatal("unexpected addresses", addresses)
+	}
+}
+
+func TestNetAmount(t *testing.T) {
+	tx := payment("net", otherAddress, testAddress, 7000)
+	for _, tc := range []struct {
+		addresses []string
+		want      chain.Amount
+	}{
+		{[]string{testAddress}, 7000},
+		{[]string{otherAddress}, -8000},
+		{[]string{testAddress, otherAddress}, -1000},
+		{nil, 0},
+	} {
+		if net := chain.NetAmount(tx, tc.addresses); net != tc.want {
+			t.Fatal("unexpected net amount", tc.addresses, net)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package chain
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxWebhookBodySize is the default size limit on incoming
+	// webhook request bodies.
+	DefaultMaxWebhookBodySize = 4 << 20
+
+	// WebhookTokenParam is the URL query parameter checked for the shared
+	// secret token of a WebhookHandler.
+	WebhookTokenParam = "token"
+
+	// WebhookTokenHeader is the HTTP header checked for the shared secret
+	// token of a WebhookHandler.
+	WebhookTokenHeader = "X-Webhook-Token"
+)
+
+// EventHeader holds the delivery details common to every notification event.
+//
+// Chain documentation can be found here
+// https://chain.com/docs#notifications-payload.
+type EventHeader struct {
+	ID              string
+	NotificationID  string `json:"notification_id"`
+	Sequence        int64
+	CreatedAt       time.Time `json:"created_at"`
+	DeliveryAttempt int       `json:"delivery_attempt"`
+
+	// Type and BlockChain are copied from the event payload.
+	Type       NotificationType `json:"-"`
+	BlockChain Network          `json:"-"`
+}
+
+// Event is implemented by *TransactionEvent, *BlockEvent, *AddressEvent and
+// *ConfirmationEvent.
+type Event interface {
+	Header() *EventHeader
+}
+
+// TransactionEvent is delivered for new-transaction notifications.
+type TransactionEvent struct {
+	EventHeader
+	Transaction Transaction
+}
+
+// BlockEvent is delivered for new-block notifications.
+type BlockEvent struct {
+	EventHeader
+	Block Block
+}
+
+// AddressEvent is delivered for address notifications when a transaction
+// involving the address is seen or confirmed.
+type AddressEvent struct {
+	EventHeader
+	Address         string
+	Sent            Amount
+	Received        Amount
+	InputAddresses  []string `json:"input_addresses"`
+	OutputAddresses []string `json:"output_addresses"`
+	TransactionHash string   `json:"transaction_hash"`
+	BlockHash       string   `json:"block_hash"`
+	Confirmations   int64
+}
+
+// ConfirmationEvent is delivered for transaction notifications when the
+// transaction reaches the target number of confirmations.
+type ConfirmationEvent struct {
+	EventHeader
+	TransactionHash string `json:"transaction_hash"`
+	BlockHash       string `json:"block_hash"`
+	Confirmations   int64
+}
+
+// Header implements Event.
+func (e *EventHeader) Header() *EventHeader {
+	return e
+}
+
+// ParseEvent decodes a Chain.com notification delivery into a typed Event.
+func ParseEvent(data []byte) (Event, error) {
+	envelope := struct {
+		EventHeader
+		Payload json.RawMessage
+	}{}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("%s with data %.30q", err.Error(), data)
+	}
+	if len(envelope.Payload) == 0 {
+		return nil, errors.New("notification has no payload")
+	}
+
+	payloadType := struct {
+		Type       NotificationType
+		BlockChain Network `json:"block_chain"`
+	}{}
+	if err := json.Unmarshal(envelope.Payload, &payloadType); err != nil {
+		return nil, err
+	}
+	header := envelope.EventHeader
+	header.Type, header.BlockChain = payloadType.Type, payloadType.BlockChain
+
+	switch payloadType.Type {
+	case NewTransactionNotification:
+		e := &TransactionEvent{EventHeader: header}
+		p := struct{ Transaction *Transaction }{}
+		if err := json.Unmarshal(envelope.Payload, &p); err != nil {
+			return nil, err
+		}
+		if p.Transaction == nil {
+			return nil, errors.New("new-transaction payload has no transaction")
+		}
+		e.Transaction = *p.Transaction
+		return e, nil
+	case NewBlockNotification:
+		e := &BlockEvent{EventHeader: header}
+		p := struct{ Block *Block }{}
+		if err := json.Unmarshal(envelope.Payload, &p); err != nil {
+			return nil, err
+		}
+		if p.Block == nil {
+			return nil, errors.New("new-block payload has no block")
+		}
+		e.Block = *p.Block
+		return e, nil
+	case AddressNotification:
+		e := &AddressEvent{}
+		if err := json.Unmarshal(envelope.Payload, e); err != nil {
+			return nil, err
+		}
+		if e.Address == "" {
+			return nil, errors.New("address payload has no address")
+		}
+		e.EventHeader = header
+		return e, nil
+	case TransactionNotification:
+		e := &ConfirmationEvent{}
+		if err := json.Unmarshal(envelope.Payload, e); err != nil {
+			return nil, err
+		}
+		if e.TransactionHash == "" {
+			return nil, errors.New("transaction payload has no transaction hash")
+		}
+		e.EventHeader = header
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown notification type %q", payloadType.Type)
+}
+
+// EventHandlers holds the callbacks events are dispatched to. A nil callback
+// means events of that type are acknowledged and dropped.
+type EventHandlers struct {
+	NewTransaction func(*TransactionEvent) error
+	NewBlock       func(*BlockEvent) error
+	Address        func(*AddressEvent) error
+	Confirmation   func(*ConfirmationEvent) error
+}
+
+// Dispatch calls the callback registered for the type of e.
+func (h EventHandlers) Dispatch(e Event) error {
+	switch e := e.(type) {
+	case *TransactionEvent:
+		if h.NewTransaction != nil {
+			return h.NewTransaction(e)
+		}
+	case *BlockEvent:
+		if h.NewBlock != nil {
+			return h.NewBlock(e)
+		}
+	case *AddressEvent:
+		if h.Address != nil {
+			return h.Address(e)
+		}
+	case *ConfirmationEvent:
+		if h.Confirmation != nil {
+			return h.Confirmation(e)
+		}
+	default:
+		return fmt.Errorf("unknown event type %T", e)
+	}
+	return nil
+}
+
+// WebhookHandler is an http.Handler that receives Chain.com notification
+// deliveries, decodes them into typed events and dispatches them to its
+// EventHandlers. Chain.com retries deliveries that do not receive a 2xx
+// response so callbacks should return an error when an event needs to be
+// delivered again.
+//
+// Anyone who knows the webhook URL can post to it, so deliveries can be
+// authenticated with a shared secret Token, checked against the referenced
+// data by re-fetching it through Verify, and deduplicated with a DedupStore.
+//
+// Responses are:
+//   - 200 when the event was dispatched successfully, had no callback or is
+//     a duplicate,
+//   - 400 for malformed or unknown notifications,
+//   - 401 when the token is missing or wrong,
+//   - 403 when the referenced transaction or block does not exist,
+//   - 405 for methods other than POST,
+//   - 413 for bodies larger than MaxBodySize,
+//   - 500 when a callback returns an error,
+//   - 503 when the referenced data could not be fetched for verification.
+type WebhookHandler struct {
+	Handlers EventHandlers
+
+	// Token, if set, must be sent with every delivery either in the
+	// WebhookTokenParam URL query parameter or the WebhookTokenHeader
+	// header. Use WebhookURL to add it to the URL given to Chain.com.
+	Token string
+
+	// Verify, if set, is used to re-fetch the transaction or block an event
+	// refers to. Events referring to data that does not exist are rejected
+	// and the fetched data replaces the data in the delivery.
+	Verify *Chain
+
+	// Dedup, if set, records event IDs so that replays and retries of an
+	// event that was already dispatched are acknowledged without being
+	// dispatched again.
+	Dedup DedupStore
+
+	// MaxBodySize limits the size of request bodies. It defaults to
+	// DefaultMaxWebhookBodySize.
+	MaxBodySize int64
+
+	// ErrorLog is used to log callback errors. If nil, the log package's
+	// standard logger is used.
+	ErrorLog *log.Logger
+}
+
+// NewWebhookHandler creates a WebhookHandler dispatching to h.
+func NewWebhookHandler(h EventHandlers) *WebhookHandler {
+	return &WebhookHandler{Handlers: h}
+}
+
+func (wh *WebhookHandler) logf(format string, args ...interface{}) {
+	if wh.ErrorLog != nil {
+		wh.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (wh *WebhookHandler) readBody(r *http.Request) ([]byte, int) {
+	maxSize := wh.MaxBodySize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxWebhookBodySize
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSize+1))
+	if err != nil {
+		return nil, http.StatusBadRequest
+	}
+	if int64(len(data)) > maxSize {
+		return nil, http.StatusRequestEntityTooLarge
+	}
+	return data, http.StatusOK
+}
+
+// WebhookURL returns rawURL with token added as the WebhookTokenParam query
+// parameter.
+func WebhookURL(rawURL, token string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set(WebhookTokenParam, token)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func (wh *WebhookHandler) authorized(r *http.Request) bool {
+	if wh.Token == "" {
+		return true
+	}
+	token := r.Header.Get(WebhookTokenHeader)
+	if token == "" {
+		token = r.URL.Query().Get(WebhookTokenParam)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(wh.Token)) == 1
+}
+
+// errEventRejected is returned by verify when an event refers to data that
+// does not exist.
+var errEventRejected = errors.New("event refers to unknown data")
+
+// verify re-fetches the data referenced by e and replaces the delivered copy
+// with it.
+func (wh *WebhookHandler) verify(e Event) error {
+	var err error
+	switch e := e.(type) {
+	case *TransactionEvent:
+		if e.Transaction.Hash == "" {
+			return errEventRejected
+		}
+		e.Transaction, err = wh.Verify.GetTransaction(e.Transaction.Hash)
+	case *BlockEvent:
+		if e.Block.Hash == "" {
+			return errEventRejected
+		}
+		e.Block, err = wh.Verify.GetBlockByHash(e.Block.Hash)
+	case *AddressEvent:
+		if e.TransactionHash == "" {
+			return errEventRejected
+		}
+		var tx Transaction
+		if tx, err = wh.Verify.GetTransaction(e.TransactionHash); err != nil {
+			break
+		}
+		if !transactionInvolves(tx, e.Address) {
+			return errEventRejected
+		}
+		e.BlockHash, e.Confirmations = tx.BlockHash, tx.Confirmations
+	case *ConfirmationEvent:
+		if e.TransactionHash == "" {
+			return errEventRejected
+		}
+		var tx Transaction
+		if tx, err = wh.Verify.GetTransaction(e.TransactionHash); err != nil {
+			break
+		}
+		if tx.Confirmations < e.Confirmations {
+			return errEventRejected
+		}
+		e.BlockHash, e.Confirmations = tx.BlockHash, tx.Confirmations
+	}
+	if IsNotFound(err) {
+		return errEventRejected
+	}
+	return err
+}
+
+func transactionInvolves(tx Transaction, address string) bool {
+	for _, in := range tx.Inputs {
+		for _, a := range in.Addresses {
+			if a == address {
+				return true
+			}
+		}
+	}
+	for _, out := range tx.Outputs {
+		for _, a := range out.Addresses {
+			if a == address {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ServeHTTP implements http.Handler.
+func (wh *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !wh.authorized(r) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	data, status := wh.readBody(r)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	e, err := ParseEvent(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := e.Header().ID
+
+	if wh.Dedup != nil {
+		if id == "" {
+			http.Error(w, "notification has no id", http.StatusBadRequest)
+			return
+		}
+		if !wh.Dedup.Reserve(id) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	status = http.StatusOK
+	if wh.Verify != nil {
+		if err := wh.verify(e); err == errEventRejected {
+			status = http.StatusForbidden
+			http.Error(w, err.Error(), status)
+		} else if err != nil {
+			wh.logf("chain: webhook verify event %s: %s", id, err)
+			status = http.StatusServiceUnavailable
+			http.Error(w, "verification unavailable", status)
+		}
+	}
+
+	if status == http.StatusOK {
+		if err := wh.Handlers.Dispatch(e); err != nil {
+			wh.logf("chain: webhook %s event %s: %s", e.Header().Type, id, err)
+			status = http.StatusInternalServerError
+			http.Error(w, "event handler failed", status)
+		}
+	}
+
+	if status != http.StatusOK {
+		if wh.Dedup != nil {
+			wh.Dedup.Release(id)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DedupStore records the IDs of delivered events so that each event is
+// dispatched at most once.
+type DedupStore interface {
+	// Reserve records id and returns true, or returns false if id has
+	// already been recorded.
+	Reserve(id string) bool
+
+	// Release forgets id so that a failed delivery can be retried.
+	Release(id string)
+}
+
+// MemoryDedupStore is a DedupStore that remembers a bounded number of the
+// most recently reserved IDs in memory. When full, the oldest ID is
+// forgotten.
+type MemoryDedupStore struct {
+	mu    sync.Mutex
+	ids   map[string]int
+	order []string
+	next  int
+}
+
+// NewMemoryDedupStore creates a MemoryDedupStore holding up to capacity IDs.
+func NewMemoryDedupStore(capacity int) *MemoryDedupStore {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &MemoryDedupStore{
+		ids:   make(map[string]int, capacity),
+		order: make([]string, capacity),
+	}
+}
+
+// Reserve implements DedupStore.
+func (s *MemoryDedupStore) Reserve(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.ids[id]; ok {
+		return false
+	}
+
+	if old := s.order[s.next]; old != "" && s.ids[old] == s.next {
+		delete(s.ids, old)
+	}
+	s.order[s.next] = id
+	s.ids[id] = s.next
+	s.next = (s.next + 1) % len(s.order)
+	return true
+}
+
+// Release implements DedupStore.
+func (s *MemoryDedupStore) Release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.ids[id]; ok {
+		delete(s.ids, id)
+		s.order[i] = ""
+	}
+}
@@ -0,0 +1,287 @@
+package chain_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/qedus/chain"
+)
+
+const (
+	testTxDelivery = `{
+		"id": "c7c5f58b-7ba4-4b7a-8b6d-7e6b1b5e9f01",
+		"notification_id": "5b3a6c2e-3a59-4d0e-a1d2-7f6c3f0e1d11",
+		"sequence": 12,
+		"created_at": "2014-11-28T10:00:00Z",
+		"delivery_attempt": 1,
+		"payload": {
+			"type": "new-transaction",
+			"block_chain": "bitcoin",
+			"transaction": {
+				"hash": "0bf0de38c26195919179f42d475beb7a6b15258c38b57236afdd60a07eddd2cc",
+				"outputs": [{"value": 5000, "addresses": ["1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"]}]
+			}
+		}
+	}`
+
+	testBlockDelivery = `{
+		"id": "0d6b8d0a-4c3c-4ae8-9d1e-3c1b6d0e8a22",
+		"notification_id": "9a1f2b3c-3a59-4d0e-a1d2-7f6c3f0e1d11",
+		"sequence": 3,
+		"payload": {
+			"type": "new-block",
+			"block_chain": "testnet3",
+			"block": {
+				"hash": "00000000000000000a7d2cbb4b1f0a2a4cde8b7d3f0f7a7a6a6d2b4b8c0e1f2a",
+				"height": 300000
+			}
+		}
+	}`
+
+	testAddressDelivery = `{
+		"id": "1f0e2d3c-4c3c-4ae8-9d1e-3c1b6d0e8a33",
+		"payload": {
+			"type": "address",
+			"block_chain": "bitcoin",
+			"address": "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa",
+			"sent": 0,
+			"received": 5000,
+			"input_addresses": ["1BoatSLRHtKNngkdXEeobR76b53LETtpyT"],
+			"output_addresses": ["1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"],
+			"transaction_hash": "0bf0de38c26195919179f42d475beb7a6b15258c38b57236afdd60a07eddd2cc",
+			"block_hash": null,
+			"confirmations": 0
+		}
+	}`
+)
+
+func postWebhook(h http.Handler, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/hook", strings.NewReader(body)))
+	return w
+}
+
+func TestWebhookHandlerDispatch(t *testing.T) {
+	var txEvent *chain.TransactionEvent
+	var blockEvent *chain.BlockEvent
+	var addrEvent *chain.AddressEvent
+	h := chain.NewWebhookHandler(chain.EventHandlers{
+		NewTransaction: func(e *chain.TransactionEvent) error {
+			txEvent = e
+			return nil
+		},
+		NewBlock: func(e *chain.BlockEvent) error {
+			blockEvent = e
+			return nil
+		},
+		Address: func(e *chain.AddressEvent) error {
+			addrEvent = e
+			return nil
+		},
+	})
+
+	for _, body := range []string{testTxDelivery, testBlockDelivery,
+		testAddressDelivery} {
+		if w := postWebhook(h, body); w.Code != http.StatusOK {
+			t.Fatal("unexpected status", w.Code, w.Body.String())
+		}
+	}
+
+	if txEvent == nil || txEvent.Transaction.Outputs[0].Value != 5000 ||
+		txEvent.Sequence != 12 || txEvent.BlockChain != chain.MainNet {
+		t.Fatal("unexpected transaction event", txEvent)
+	}
+	if blockEvent == nil || blockEvent.Block.Height != 300000 ||
+		blockEvent.Type != "new-block" ||
+		blockEvent.BlockChain != chain.TestNet3 {
+		t.Fatal("unexpected block event", blockEvent)
+	}
+	if addrEvent == nil || addrEvent.Received != 5000 ||
+		addrEvent.ID != "1f0e2d3c-4c3c-4ae8-9d1e-3c1b6d0e8a33" {
+		t.Fatal("unexpected address event", addrEvent)
+	}
+}
+
+func TestWebhookHandlerStatus(t *testing.T) {
+	h := chain.NewWebhookHandler(chain.EventHandlers{
+		NewTransaction: func(e *chain.TransactionEvent) error {
+			return errors.New("database unavailable")
+		},
+	})
+	h.ErrorLog = log.New(ioutil.Discard, "", 0)
+	h.MaxBodySize = 2048
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/hook", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatal("expected method not allowed", w.Code)
+	}
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{testTxDelivery, http.StatusInternalServerError},
+		{testBlockDelivery, http.StatusOK},
+		{`{"id": "1", "payload": {"type": "new-block"}}`, http.StatusBadRequest},
+		{`{"id": "1", "payload": {"type": "unknown"}}`, http.StatusBadRequest},
+		{`{"id": "1"}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+		{strings.Repeat(" ", 4096), http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		if w := postWebhook(h, test.body); w.Code != test.status {
+			t.Fatalf("%.30q: expected %d got %d", test.body, test.status,
+				w.Code)
+		}
+	}
+}
+
+func TestWebhookHandlerToken(t *testing.T) {
+	h := chain.NewWebhookHandler(chain.EventHandlers{})
+	h.Token = "s3cret"
+
+	u, err := chain.WebhookURL("https://example.com/hook?a=b", h.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "https://example.com/hook?a=b&token=s3cret" {
+		t.Fatal("unexpected webhook URL", u)
+	}
+
+	if w := postWebhook(h, testBlockDelivery); w.Code != http.StatusUnauthorized {
+		t.Fatal("expected unauthorized", w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", u,
+		strings.NewReader(testBlockDelivery)))
+	if w.Code != http.StatusOK {
+		t.Fatal("expected token in URL accepted", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/hook",
+		strings.NewReader(testBlockDelivery))
+	req.Header.Set(chain.WebhookTokenHeader, "wrong")
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatal("expected wrong token rejected", w.Code)
+	}
+}
+
+func TestWebhookHandlerVerify(t *testing.T) {
+	api := newFakeAPI(t, chain.MainNet, 1)
+	api.addTransaction(chain.Transaction{
+		Hash:          "0bf0de38c26195919179f42d475beb7a6b15258c38b57236afdd60a07eddd2cc",
+		Confirmations: 3,
+		Outputs: []chain.Output{{
+			Value:     5000,
+			Addresses: []string{"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"},
+		}},
+	})
+
+	var confirmations int64
+	h := chain.NewWebhookHandler(chain.EventHandlers{
+		NewTransaction: func(e *chain.TransactionEvent) error {
+			confirmations = e.Transaction.Confirmations
+			return nil
+		},
+	})
+	h.Verify = newTestChain(t, chain.MainNet, api)
+
+	if w := postWebhook(h, testTxDelivery); w.Code != http.StatusOK {
+		t.Fatal("unexpected status", w.Code, w.Body.String())
+	}
+	if confirmations != 3 {
+		t.Fatal("expected fetched transaction dispatched")
+	}
+	if w := postWebhook(h, testAddressDelivery); w.Code != http.StatusOK {
+		t.Fatal("unexpected status", w.Code, w.Body.String())
+	}
+
+	// The block in the delivery does not exist.
+	if w := postWebhook(h, testBlockDelivery); w.Code != http.StatusForbidden {
+		t.Fatal("expected forbidden", w.Code)
+	}
+	forged := strings.Replace(testAddressDelivery,
+		`"address": "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"`,
+		`"address": "1BoatSLRHtKNngkdXEeobR76b53LETtpyT1"`, 1)
+	if w := postWebhook(h, forged); w.Code != http.StatusForbidden {
+		t.Fatal("expected forbidden", w.Code)
+	}
+}
+
+func TestWebhookHandlerDedup(t *testing.T) {
+	calls, fail := 0, true
+	h := chain.NewWebhookHandler(chain.EventHandlers{
+		NewTransaction: func(e *chain.TransactionEvent) error {
+			calls++
+			if fail {
+				return errors.New("temporary failure")
+			}
+			return nil
+		},
+	})
+	h.ErrorLog = log.New(ioutil.Discard, "", 0)
+	h.Dedup = chain.NewMemoryDedupStore(2)
+
+	if w := postWebhook(h, testTxDelivery); w.Code != http.StatusInternalServerError {
+		t.Fatal("expected failure", w.Code)
+	}
+	fail = false
+	for i := 0; i < 3; i++ {
+		if w := postWebhook(h, testTxDelivery); w.Code != http.StatusOK {
+			t.Fatal("unexpected status", w.Code)
+		}
+	}
+	if calls != 2 {
+		t.Fatal("expected one failed and one successful dispatch", calls)
+	}
+}
+
+func TestMemoryDedupStore(t *testing.T) {
+	s := chain.NewMemoryDedupStore(2)
+	for _, id := range []string{"a", "b"} {
+		if !s.Reserve(id) {
+			t.Fatal("expected reserve", id)
+		}
+	}
+	if s.Reserve("a") {
+		t.Fatal("expected duplicate")
+	}
+	s.Reserve("c")
+	if !s.Reserve("a") {
+		t.Fatal("expected oldest id evicted")
+	}
+	s.Release("a")
+	if !s.Reserve("a") {
+		t.Fatal("expected released id reserved again")
+	}
+}
+
+func TestParseConfirmationEvent(t *testing.T) {
+	e, err := chain.ParseEvent([]byte(`{
+		"id": "2a0e2d3c-4c3c-4ae8-9d1e-3c1b6d0e8a44",
+		"payload": {
+			"type": "transaction",
+			"block_chain": "bitcoin",
+			"transaction_hash": "0bf0de38c26195919179f42d475beb7a6b15258c38b57236afdd60a07eddd2cc",
+			"block_hash": "000000000000000003dd5aa0232cc4e800295c348bc5ea3dc2f7db63c481d352",
+			"confirmations": 6
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ce, ok := e.(*chain.ConfirmationEvent)
+	if !ok || ce.Confirmations != 6 ||
+		ce.Type != chain.TransactionNotification {
+		t.Fatal("unexpected event", e)
+	}
+}
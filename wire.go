@@ -0,0 +1,156 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// hashSize is the size in bytes of a Bitcoin double SHA-256 hash.
+const hashSize = sha256.Size
+
+var errVarIntTooLarge = errors.New("variable length integer too large")
+
+// doubleSHA256 returns SHA-256(SHA-256(b)), the hash used for Bitcoin
+// transaction IDs, block hashes and merkle tree nodes.
+func doubleSHA256(b []byte) [hashSize]byte {
+	first := sha256.Sum256(b)
+	return sha256.Sum256(first[:])
+}
+
+// hashFromHex decodes a hash as displayed by the Chain.com API (and block
+// explorers) into internal byte order. Displayed hashes are byte reversed.
+func hashFromHex(s string) ([hashSize]byte, error) {
+	var h [hashSize]byte
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return h, err
+	}
+	if len(b) != hashSize {
+		return h, fmt.Errorf("hash %.16q has length %d, expected %d",
+			s, len(b), hashSize)
+	}
+	for i := range b {
+		h[i] = b[hashSize-1-i]
+	}
+	return h, nil
+}
+
+// hashToHex is the inverse of hashFromHex.
+func hashToHex(h [hashSize]byte) string {
+	var b [hashSize]byte
+	for i := range h {
+		b[i] = h[hashSize-1-i]
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// writeVarInt appends n to b using the Bitcoin CompactSize encoding.
+func writeVarInt(b []byte, n uint64) []byte {
+	switch {
+	case n < 0xfd:
+		return append(b, byte(n))
+	case n <= 0xffff:
+		b = append(b, 0xfd)
+		return binary.LittleEndian.AppendUint16(b, uint16(n))
+	case n <= 0xffffffff:
+		b = append(b, 0xfe)
+		return binary.LittleEndian.AppendUint32(b, uint32(n))
+	}
+	b = append(b, 0xff)
+	return binary.LittleEndian.AppendUint64(b, n)
+}
+
+// wireReader reads Bitcoin wire encoded values from a byte slice. The first
+// error encountered is kept in err and all subsequent reads become no-ops so
+// callers only need to check once at the end.
+type wireReader struct {
+	b   []byte
+	err error
+}
+
+func (r *wireReader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *wireReader) readByte() byte {
+	if b := r.read(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *wireReader) readUint16() uint16 {
+	if b := r.read(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *wireReader) readUint32() uint32 {
+	if b := r.read(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *wireReader) readUint64() uint64 {
+	if b := r.read(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *wireReader) readHash() [hashSize]byte {
+	var h [hashSize]byte
+	copy(h[:], r.read(hashSize))
+	return h
+}
+
+func (r *wireReader) readVarInt() uint64 {
+	switch prefix := r.readByte(); prefix {
+	case 0xfd:
+		return uint64(r.readUint16())
+	case 0xfe:
+		return uint64(r.readUint32())
+	case 0xff:
+		return r.readUint64()
+	default:
+		return uint64(prefix)
+	}
+}
+
+// readCount reads a variable length integer that is used as an element count
+// and checks it could plausibly fit in the remaining data, where each
+// element takes at least minSize bytes.
+func (r *wireReader) readCount(minSize int) int {
+	n := r.readVarInt()
+	if r.err != nil {
+		return 0
+	}
+	if minSize < 1 {
+		minSize = 1
+	}
+	if n > uint64(len(r.b)/minSize) {
+		r.err = errVarIntTooLarge
+		return 0
+	}
+	return int(n)
+}
+
+// readVarBytes reads a CompactSize length prefixed byte slice.
+func (r *wireReader) readVarBytes() []byte {
+	return r.read(r.readCount(1))
+}